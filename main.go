@@ -4,21 +4,27 @@ import (
 	"flag"
 	"fmt"
 	"net/http"
+	"os"
+	"sync"
 	"time"
+	// The image doesn't have a timezone database for schedules
+	_ "time/tzdata"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
 
 	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
 	"github.com/ogmaresca/azp-agent-autoscaler/pkg/azuredevops"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/controller"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/forecast"
 	"github.com/ogmaresca/azp-agent-autoscaler/pkg/health"
 	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
 	"github.com/ogmaresca/azp-agent-autoscaler/pkg/logging"
-	"github.com/ogmaresca/azp-agent-autoscaler/pkg/math"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/retry"
 	"github.com/ogmaresca/azp-agent-autoscaler/pkg/scaling"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/webhook"
 )
 
-const poolNameEnvVar = "AZP_POOL"
-
 func main() {
 	// Parse arguments
 	flag.Parse()
@@ -31,26 +37,37 @@ func main() {
 	logging.Logger.SetLevel(args.Logging.Level)
 
 	// Initialize Azure Devops client
-	azdClient := azuredevops.MakeClient(args.AZD.URL, args.AZD.Token)
+	azdClient, err := azuredevops.MakeClient(args.AZD)
+	if err != nil {
+		panic(err.Error())
+	}
 	k8sClient, err := kubernetes.MakeClient()
 	if err != nil {
 		panic(err.Error())
 	}
 
-	deploymentChan := make(chan kubernetes.WorkloadReturn)
-	verifyHPAChan := make(chan error)
 	agentPoolsChan := make(chan azuredevops.PoolDetailsResponse)
+	leaderCheck := &health.LeaderCheck{}
+	readinessCheck := &health.ReadinessCheck{}
+	if args.Leader.Enabled {
+		// Only the leader is ready
+		readinessCheck.Leader = leaderCheck
+	}
+	decisionLog := scaling.MakeDecisionLog(args.Decisions.LogSize)
+	scaling.SetDecisionLog(decisionLog)
 
-	// Get AZP agent workload
-	go k8sClient.GetWorkloadAsync(deploymentChan, args.Kubernetes)
-	// Verify there isn't a HorizontalPodAutoscaler
-	go k8sClient.VerifyNoHorizontalPodAutoscalerAsync(verifyHPAChan, args.Kubernetes)
 	// Get all agent pools
 	go azdClient.ListPoolsAsync(agentPoolsChan)
 	go func() {
 		mux := http.NewServeMux()
 		mux.Handle("/healthz", health.LivenessCheck{})
+		mux.Handle("/readyz", readinessCheck)
+		mux.Handle("/leader", leaderCheck)
 		mux.Handle("/metrics", promhttp.Handler())
+		mux.Handle("/decisions", decisionLog)
+		if args.Webhook.Enabled() {
+			mux.Handle("/webhook", webhook.MakeReceiver(args.Webhook.Secret, scaling.GetPoolTriggers()))
+		}
 		err := http.ListenAndServe(fmt.Sprintf(":%d", args.Health.Port), mux)
 		if err != nil {
 			logging.Logger.Panicf("Error serving health checks and metrics: %s", err.Error())
@@ -58,13 +75,6 @@ func main() {
 	}()
 
 	// Retrieve channel results
-	deployment := <-deploymentChan
-	if deployment.Err != nil {
-		logging.Logger.Panicf("Error retrieving %s in namespace %s: %s", args.Kubernetes.FriendlyName(), args.Kubernetes.Namespace, deployment.Err.Error())
-	}
-	if err := <-verifyHPAChan; err != nil {
-		logging.Logger.Panic(err.Error())
-	}
 	agentPools := <-agentPoolsChan
 	if agentPools.Err != nil {
 		logging.Logger.Panicf("Error retrieving agent pools: %s", agentPools.Err.Error())
@@ -72,48 +82,218 @@ func main() {
 		logging.Logger.Panic("Error - did not find any agent pools")
 	}
 
-	// Discover the pool name from the environment variables
-	agentPoolName, err := k8sClient.Sync().GetEnvValue(deployment.Resource.PodTemplateSpec.Spec, deployment.Resource.Namespace, poolNameEnvVar)
-	if err != nil {
-		logging.Logger.Panicf("Could not retrieve environment variable %s from %s: %s", poolNameEnvVar, deployment.Resource.FriendlyName, err)
+	readinessCheck.SetReady(true)
+
+	// Only the leader scales the agents
+	if args.Leader.Enabled {
+		err := k8sClient.Sync().LeaderElect(args.Leader, func(stop <-chan struct{}) {
+			leaderCheck.SetLeader(true)
+			run(azdClient, k8sClient, agentPools.Pools, args, stop)
+		}, func() {
+			leaderCheck.SetLeader(false)
+		})
+		if err != nil {
+			logging.Logger.Panic(err.Error())
+		}
+		// The scaling state of a former leader is stale, so start over as a follower.
+		// LeaderElect only returns after the agent pools stopped scaling.
+		logging.Logger.Error("Error - lost the leadership")
+		os.Exit(1)
 	} else {
-		logging.Logger.Debugf("Found agent pool %s from %s", agentPoolName, deployment.Resource.FriendlyName)
+		leaderCheck.SetLeader(true)
+		run(azdClient, k8sClient, agentPools.Pools, args, make(chan struct{}))
 	}
 
-	var agentPoolID *int
-	for _, agentPool := range agentPools.Pools {
-		if !agentPool.IsHosted && agentPool.Name == agentPoolName {
-			agentPoolID = &agentPool.ID
-			break
+	logging.Logger.Info("Exiting azp-agent-autoscaler")
+}
+
+// run scales the agent pools until they all stop
+func run(azdClient azuredevops.ClientAsync, k8sClient kubernetes.ClientAsync, agentPools []azuredevops.PoolDetails, args args.Args, stop <-chan struct{}) {
+	// Load the learned demand when starting to scale, since another leader may have saved it
+	if args.Forecast.Enabled() {
+		var store forecast.Store
+		if args.Forecast.File != "" {
+			store = forecast.FileStore{Path: args.Forecast.File}
+		} else {
+			store = forecast.ConfigMapStore{Client: k8sClient.Sync(), Namespace: args.Forecast.ConfigMapNamespace, Name: args.Forecast.ConfigMap}
+		}
+		// The live autoscaler owns the learned demand
+		if args.DryRun {
+			store = forecast.ReadOnlyStore{Store: store}
 		}
+		forecaster, err := forecast.MakeForecaster(store, args.Forecast.LeadTime)
+		if err != nil {
+			logging.Logger.Panicf("Error loading the forecast: %s", err.Error())
+		}
+		scaling.SetForecaster(forecaster)
 	}
-	if agentPoolID == nil {
-		logging.Logger.Panicf("Error - could not find an agent pool with name %s", agentPoolName)
-	} else {
-		logging.Logger.Debugf("Agent pool %s has ID %d", agentPoolName, *agentPoolID)
+
+	// Scale the agent pools declared by AzpAgentPool resources
+	if args.CRD.Enabled {
+		agentPoolController := controller.MakeController(azdClient, k8sClient, args)
+		if err := agentPoolController.Run(args.CRD.Namespace, stop); err != nil {
+			logging.Logger.Panicf("Error watching AzpAgentPool resources: %s", err.Error())
+		}
+		return
 	}
 
-	for {
-		err := scaling.Autoscale(azdClient, *agentPoolID, k8sClient, deployment.Resource, args)
-		if err != nil {
-			switch t := err.(type) {
-			case azuredevops.HTTPError:
-				httpError := err.(azuredevops.HTTPError)
-				if httpError.RetryAfter != nil {
-					logging.Logger.Warnf("%s %s", t, httpError.Error())
-					timeToSleep := math.MaxDuration(*httpError.RetryAfter, args.Rate)
-					logging.Logger.Infof("Retrying after %s", timeToSleep.String())
-					time.Sleep(timeToSleep)
+	// Queued jobs aren't routed between the workloads until they have all been autoscaled
+	for _, pool := range args.Pools {
+		scaling.ExpectWorkloadRoute(pool.Kubernetes.Namespace, pool.Kubernetes.FriendlyName(), args.PollInterval, time.Now())
+	}
+
+	// Scale each agent pool concurrently, sharing the clients.
+	// An agent pool that keeps failing with transient errors is restarted on its own, so that the other agent pools keep scaling.
+	// azp-agent-autoscaler exits on an error that restarting won't fix, or once every agent pool is failing.
+	failing := makeFailingPools(len(args.Pools))
+	var wg sync.WaitGroup
+	for _, pool := range args.Pools {
+		poolArgs := args.ForPool(pool)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			poolLogger := logging.Logger.WithFields(logrus.Fields{
+				"namespace": poolArgs.Kubernetes.Namespace,
+				"workload":  poolArgs.Kubernetes.FriendlyName(),
+			})
+			poolKey := poolArgs.Kubernetes.Namespace + "/" + poolArgs.Kubernetes.FriendlyName()
+			poolAgentPools := agentPools
+			for {
+				err := autoscalePool(azdClient, k8sClient, poolAgentPools, poolArgs, stop, func() { failing.recovered(poolKey) })
+				if err == nil {
+					return
+				}
+				if !retry.IsRetryable(err) {
+					poolLogger.Errorf("Error autoscaling %s in namespace %s: %s", poolArgs.Kubernetes.FriendlyName(), poolArgs.Kubernetes.Namespace, err.Error())
+					os.Exit(1)
+				}
+				if failing.failed(poolKey) {
+					poolLogger.Errorf("Error autoscaling %s in namespace %s, and every agent pool is failing: %s", poolArgs.Kubernetes.FriendlyName(), poolArgs.Kubernetes.Namespace, err.Error())
+					os.Exit(1)
 				}
-			default:
-				// Do nothing
+				poolLogger.Errorf("Error autoscaling %s in namespace %s, restarting it after %s: %s", poolArgs.Kubernetes.FriendlyName(), poolArgs.Kubernetes.Namespace, poolArgs.Retry.MaxBackoff.String(), err.Error())
+				retry.Restarted(poolArgs.Kubernetes.Namespace, poolArgs.Kubernetes.FriendlyName())
+				select {
+				case <-stop:
+					return
+				case <-time.After(poolArgs.Retry.MaxBackoff):
+				}
+				// The agent pool may have been created since
+				poolAgentPools = nil
 			}
+		}()
+	}
+	wg.Wait()
+}
 
-			logging.Logger.Panicf("Error autoscaling statefulset/%s: %s", deployment.Resource.Name, err.Error())
-		} else {
-			time.Sleep(args.Rate)
+// failingPools tracks the agent pools whose autoscaling failed, until they have an iteration that succeeds
+type failingPools struct {
+	numPools int
+	pools    map[string]bool
+	lock     sync.Mutex
+}
+
+// makeFailingPools returns a failingPools for a number of agent pools
+func makeFailingPools(numPools int) *failingPools {
+	return &failingPools{
+		numPools: numPools,
+		pools:    make(map[string]bool),
+	}
+}
+
+// failed marks an agent pool as failing, and returns whether every agent pool is failing
+func (f *failingPools) failed(key string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.pools[key] = true
+	return len(f.pools) >= f.numPools
+}
+
+// recovered marks an agent pool as no longer failing
+func (f *failingPools) recovered(key string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.pools, key)
+}
+
+// autoscalePool runs the autoscaling loop of one agent pool workload until stop is closed, calling succeeded after each
+// successful iteration. An error is returned if the workload can't be autoscaled, or the retries of a failing iteration are exhausted.
+func autoscalePool(azdClient azuredevops.ClientAsync, k8sClient kubernetes.ClientAsync, agentPools []azuredevops.PoolDetails, args args.Args, stop <-chan struct{}, succeeded func()) error {
+	deploymentChan := make(chan kubernetes.WorkloadReturn)
+	verifyHPAChan := make(chan error)
+
+	// Get AZP agent workload
+	go k8sClient.GetWorkloadAsync(deploymentChan, args.Kubernetes)
+	// Verify there isn't a HorizontalPodAutoscaler
+	go k8sClient.VerifyNoHorizontalPodAutoscalerAsync(verifyHPAChan, args.Kubernetes)
+
+	// Retrieve channel results
+	deployment := <-deploymentChan
+	verifyHPAErr := <-verifyHPAChan
+	if deployment.Err != nil {
+		return fmt.Errorf("Error retrieving %s in namespace %s: %w", args.Kubernetes.FriendlyName(), args.Kubernetes.Namespace, deployment.Err)
+	}
+	if verifyHPAErr != nil {
+		return verifyHPAErr
+	}
+
+	if agentPools == nil {
+		agentPoolsChan := make(chan azuredevops.PoolDetailsResponse)
+		go azdClient.ListPoolsAsync(agentPoolsChan)
+		response := <-agentPoolsChan
+		if response.Err != nil {
+			return fmt.Errorf("Error retrieving agent pools: %w", response.Err)
 		}
+		agentPools = response.Pools
 	}
 
-	logging.Logger.Info("Exiting azp-agent-autoscaler")
+	// Discover the pool name from the environment variables
+	agentPoolID, _, err := scaling.GetAgentPoolID(k8sClient, agentPools, deployment.Resource, args.PoolName)
+	if err != nil {
+		return err
+	}
+
+	// Cache the workload and its pods, and re-evaluate as soon as the pods change.
+	// The watches are stopped when this returns, so that restarting the autoscaling doesn't leak them.
+	workloadStop := make(chan struct{})
+	defer close(workloadStop)
+	refresher, err := scaling.MakeWorkloadRefresher(azdClient, k8sClient, args, deployment.Resource, agentPoolID, workloadStop)
+	if err != nil {
+		return err
+	}
+
+	retryPolicy := retry.MakePolicy(args.Rate, args.PollInterval, args.Retry, args.Kubernetes.Namespace, deployment.Resource.FriendlyName)
+	for {
+		iterationStart := time.Now()
+		// Pick up changes to the workload spec and its agent pool
+		err := refresher.Refresh(iterationStart)
+		// Notifications received from now on start the next iteration early
+		notified := scaling.GetPoolTriggers().Triggered(refresher.AgentPoolID)
+		if err == nil {
+			err = scaling.Autoscale(azdClient, refresher.AgentPoolID, k8sClient, refresher.Workload, args)
+		}
+		timeToSleep := args.PollInterval
+		if err != nil {
+			var retryErr error
+			timeToSleep, retryErr = retryPolicy.Failure(err)
+			if retryErr != nil {
+				return retryErr
+			}
+			logging.Logger.Warnf("Error autoscaling %s: %s", deployment.Resource.FriendlyName, err.Error())
+			logging.Logger.Infof("Retrying after %s", timeToSleep.String())
+		} else {
+			timeToSleep = retryPolicy.Success()
+			succeeded()
+		}
+
+		// Pod changes and notifications start the next iteration early, unless the last iteration failed and is being retried
+		triggers := refresher.PodsChanged
+		if err != nil {
+			triggers = nil
+			notified = nil
+		}
+		if !scaling.WaitForNextIteration(stop, triggers, notified, iterationStart, timeToSleep, args.MinTriggerInterval) {
+			return nil
+		}
+	}
 }
@@ -3,6 +3,7 @@ package args
 import (
 	"flag"
 	"fmt"
+	"regexp"
 	"strings"
 	"time"
 
@@ -11,36 +12,142 @@ import (
 
 var (
 	logLevel          = flag.String("log-level", "info", "Log level (trace, debug, info, warn, error, fatal, panic).")
-	min               = flag.Int("min", 1, "Minimum number of free agents to keep alive. Minimum of 1.")
+	min               = flag.Int("min", 1, "Minimum number of free agents to keep alive. 0 scales the agents to zero after the scale-to-zero delay without active agents or queued jobs.")
 	max               = flag.Int("max", 100, "Maximum number of agents allowed.")
 	rate              = flag.Duration("rate", 10*time.Second, "Duration to check the number of agents.")
+	triggerInterval   = flag.Duration("min-trigger-interval", 5*time.Second, "Minimum duration between autoscaling iterations started early by pod changes or notifications. Triggers within the interval are coalesced into one iteration.")
+	refreshInterval   = flag.Duration("refresh-interval", 5*time.Minute, "Duration to re-resolve the agent pool of the agents. Changes to the workload spec re-resolve it immediately. 0 only re-resolves it on workload spec changes.")
+	failureThreshold  = flag.Int("failure-threshold", 5, "Number of consecutive failed iterations allowed before the autoscaling of the workload is restarted, or the autoscaler exits if every workload is failing. Only transient errors are retried.")
+	maxBackoff        = flag.Duration("max-backoff", 5*time.Minute, "Maximum duration to wait between retries of failed iterations.")
+	mode              = flag.String("mode", "Replicas", "How agents are scaled. Replicas scales the workload, Jobs creates a one-shot Job from the workload's pod template for each queued job.")
 	scaleDownDelay    = flag.Duration("scale-down", 30*time.Second, "Wait time after scaling down to scale down again.")
 	scaleDownMax      = flag.Int("scale-down-max", 1, "Maximum allowed number of pods to scale down.")
-	resourceType      = flag.String("type", "StatefulSet", "Resource type of the agent. Only StatefulSet is supported.")
-	resourceName      = flag.String("name", "", "The name of the StatefulSet.")
-	resourceNamespace = flag.String("namespace", "", "The namespace of the StatefulSet.")
+	scaleToZeroDelay  = flag.Duration("scale-to-zero-delay", 10*time.Minute, "Time without active agents or queued jobs before scaling to zero, if min is 0.")
+	scaleUpWindow     = flag.Duration("scale-up-stabilization-window", 0, "Scale up to the lowest number of agents recommended over this window.")
+	scaleUpSelect     = flag.String("scale-up-select-policy", "Max", "Which scale up policy applies (Max, Min, Disabled). Max allows the most change, Min the least change.")
+	scaleDownWindow   = flag.Duration("scale-down-stabilization-window", 0, "Scale down to the highest number of agents recommended over this window.")
+	scaleDownSelect   = flag.String("scale-down-select-policy", "Max", "Which scale down policy applies (Max, Min, Disabled). Max allows the most change, Min the least change.")
+	scaleDownStrategy = flag.String("scale-down-strategy", "Replicas", "How pods are removed when scaling down (Replicas, DeletionCost, Delete). DeletionCost and Delete only remove idle agents and require a Deployment.")
+	policy            = flag.String("policy", "Default", "How the desired number of agents is computed (Default, FixedBuffer, Step, PercentageBuffer).")
+	policyBuffer      = flag.Int("policy-buffer", 1, "The number of free agents to keep while there are active agents or queued jobs, with the FixedBuffer policy.")
+	policyStep        = flag.Int("policy-step", 1, "The number of agents to scale by at a time, with the Step policy.")
+	policyPercent     = flag.Int("policy-percent", 20, "The free agents to keep as a percentage of the active agents, with the PercentageBuffer policy.")
+	policyFloor       = flag.Int("policy-floor", 1, "The minimum number of free agents to keep while there are active agents or queued jobs, with the PercentageBuffer policy.")
+	policyCeiling     = flag.Int("policy-ceiling", 10, "The maximum number of free agents to keep, with the PercentageBuffer policy. 0 is unlimited.")
+	resourceType      = flag.String("type", "StatefulSet", "Resource type of the agent. StatefulSet and Deployment are supported.")
+	resourceName      = flag.String("name", "", "The name of the StatefulSet or Deployment.")
+	resourceNamespace = flag.String("namespace", "", "The namespace of the StatefulSet or Deployment.")
+	poolName          = flag.String("pool", "", "The name of the agent pool. Defaults to the AZP_POOL environment variable of the agents.")
+	configFile        = flag.String("config", "", "A YAML or JSON file listing the agent pools to scale. If set, the agent pool flags are used as the defaults of each pool.")
+	crd               = flag.Bool("crd", false, "Scale the agent pools declared by AzpAgentPool resources. If set, the agent pool flags are used as the defaults of each pool.")
+	crdNamespace      = flag.String("crd-namespace", "", "The namespace to watch AzpAgentPool resources in. Defaults to all namespaces.")
+	leaderElect       = flag.Bool("leader-elect", false, "Elect a leader with a Lease, so that only one replica of azp-agent-autoscaler scales the agents.")
+	leaderElectName   = flag.String("leader-elect-name", "azp-agent-autoscaler", "The name of the leader election Lease.")
+	leaderElectNS     = flag.String("leader-elect-namespace", "", "The namespace of the leader election Lease.")
+	leaseDuration     = flag.Duration("leader-elect-lease-duration", 15*time.Second, "Duration that replicas wait to take over the leadership after the leader stops renewing it.")
+	renewDeadline     = flag.Duration("leader-elect-renew-deadline", 10*time.Second, "Duration that the leader retries renewing the leadership before giving it up.")
+	retryPeriod       = flag.Duration("leader-elect-retry-period", 2*time.Second, "Duration between leader election attempts.")
+	leaderElectPod    = flag.String("leader-elect-pod-name", "", "The name of this pod, in the Lease namespace. The pod is labeled azp-agent-autoscaler/leader=true while it is the leader.")
+	forecastFile      = flag.String("forecast-file", "", "A local file to persist the learned demand of the agents in, to pre-scale ahead of the expected demand.")
+	forecastCM        = flag.String("forecast-configmap", "", "A ConfigMap to persist the learned demand of the agents in, to pre-scale ahead of the expected demand.")
+	forecastCMNS      = flag.String("forecast-configmap-namespace", "", "The namespace of the forecast ConfigMap.")
+	forecastLeadTime  = flag.Duration("forecast-lead-time", 15*time.Minute, "How far ahead of the expected demand to pre-scale the agents.")
 	azpToken          = flag.String("token", "", "The Azure Devops token.")
-	azpURL            = flag.String("url", "", "The Azure Devops URL. https://dev.azure.com/AccountName")
+	azpURL            = flag.String("url", "", "The Azure Devops URL. https://dev.azure.com/AccountName, or the collection URL with Azure Devops Server, ex: https://tfs.example.com/tfs/DefaultCollection")
+	azpAPIVersion     = flag.String("api-version", "", "The Azure Devops API version, ex: 4.1 for TFS 2018. Negotiated with the server by default.")
+	azpCABundle       = flag.String("ca-bundle", "", "A PEM file of CA certificates to trust for the Azure Devops URL, in addition to the system CAs.")
+	azpInsecure       = flag.Bool("insecure-skip-tls-verify", false, "Don't verify the TLS certificate of the Azure Devops URL. Only use this with lab servers.")
+	azpAuth           = flag.String("auth", "PAT", "How to authenticate to Azure Devops. PAT uses the token, ServicePrincipal a client secret or certificate, and WorkloadIdentity a federated token file.")
+	azpTenantID       = flag.String("tenant-id", "", "The Entra ID tenant of the service principal or managed identity.")
+	azpClientID       = flag.String("client-id", "", "The client ID of the service principal or managed identity.")
+	azpClientSecret   = flag.String("client-secret", "", "The client secret of the service principal.")
+	azpClientCert     = flag.String("client-certificate", "", "A PEM file with the client certificate of the service principal and its RSA private key.")
+	azpFederatedToken = flag.String("federated-token-file", "", "A file with the federated token of the workload identity, ex: a projected service account token.")
+	azpAuthorityHost  = flag.String("authority-host", "https://login.microsoftonline.com", "The Entra ID authority host to request access tokens from.")
 	port              = flag.Int("port", 10101, "The port to serve health checks and metrics.")
+	webhookSecret     = flag.String("webhook-secret", "", "The shared secret of the Azure Devops service hook notifications sent to /webhook, as the basic authentication password. Enables the webhook receiver.")
+	webhookRate       = flag.Duration("webhook-rate", time.Minute, "Duration to check the number of agents while the webhook receiver is enabled, in case notifications are missed.")
+	decisionLogSize   = flag.Int("decision-log-size", 100, "The number of recent scaling decisions served on /decisions. 0 disables the decision log.")
+	dryRun            = flag.Bool("dry-run", false, "Compute and log the scaling decisions without scaling the agents, to trial settings alongside the live autoscaler.")
+	schedules         scheduleFlags
+	scaleUpPolicies   behaviorPolicyFlags
+	scaleDownPolicies behaviorPolicyFlags
 )
 
+// apiVersionRegex matches Azure Devops API versions, ex: 5.0 or 5.0-preview.1
+var apiVersionRegex = regexp.MustCompile(`^\d+\.\d+(-preview(\.\d+)?)?$`)
+
+func init() {
+	flag.Var(&schedules, "schedule", "A schedule rule overriding the min and max while its cron expression matches, ex: name=business-hours;cron=* 8-17 * * mon-fri;timezone=America/Toronto;min=5;max=50. Can be repeated; the first matching rule is used.")
+	flag.Var(&scaleUpPolicies, "scale-up-policy", "A limit of the agents added over a period, ex: type=Pods;value=4;period=1m or type=Percent;value=100;period=1m. Can be repeated.")
+	flag.Var(&scaleDownPolicies, "scale-down-policy", "A limit of the agents removed over a period, ex: type=Pods;value=4;period=1m or type=Percent;value=10;period=1m. Can be repeated.")
+}
+
 // Args holds all of the program arguments
 type Args struct {
-	Min  int32
-	Max  int32
-	Rate time.Duration
+	Min      int32
+	Max      int32
+	Rate     time.Duration
+	Mode     string
+	PoolName string
+	DryRun   bool
+
+	// RefreshInterval is the duration between re-resolving the agent pools of the workloads
+	RefreshInterval time.Duration
+	// MinTriggerInterval is the minimum duration between iterations started by pod changes or notifications
+	MinTriggerInterval time.Duration
+	// PollInterval is the duration between iterations that aren't triggered. It's the webhook rate when the webhook receiver is enabled.
+	PollInterval time.Duration
 
 	ScaleDown  ScaleDownArgs
+	Policy     PolicyArgs
+	Behavior   BehaviorArgs
+	Schedules  []ScheduleArgs
 	Logging    LoggingArgs
 	Kubernetes KubernetesArgs
 	AZD        AzureDevopsArgs
 	Health     HealthArgs
+	Decisions  DecisionArgs
+	Webhook    WebhookArgs
+	CRD        CRDArgs
+	Leader     LeaderElectionArgs
+	Retry      RetryArgs
+	Forecast   ForecastArgs
+
+	// Pools holds the args of every agent pool to scale
+	Pools []PoolArgs
+}
+
+// PoolArgs holds all of the args specific to one agent pool
+type PoolArgs struct {
+	Min      int32
+	Max      int32
+	Mode     string
+	PoolName string
+
+	ScaleDown  ScaleDownArgs
+	Policy     PolicyArgs
+	Behavior   BehaviorArgs
+	Kubernetes KubernetesArgs
+	Schedules  []ScheduleArgs
 }
 
 // ScaleDownArgs holds all of the scale-down related args
 type ScaleDownArgs struct {
-	Delay time.Duration
-	Max   int32
+	Delay       time.Duration
+	Max         int32
+	Strategy    string
+	ToZeroDelay time.Duration
+}
+
+// PolicyArgs holds the scaling policy of an agent pool
+type PolicyArgs struct {
+	Type    string
+	Buffer  int32
+	Step    int32
+	Percent int32
+	Floor   int32
+	Ceiling int32
 }
 
 // LoggingArgs holds all of the logging related args
@@ -60,6 +167,58 @@ type HealthArgs struct {
 	Port int
 }
 
+// DecisionArgs holds all of the decision log related args
+type DecisionArgs struct {
+	LogSize int
+}
+
+// WebhookArgs holds all of the Azure Devops service hook related args
+type WebhookArgs struct {
+	Secret string
+	Rate   time.Duration
+}
+
+// Enabled returns whether Azure Devops service hook notifications are received
+func (a WebhookArgs) Enabled() bool {
+	return a.Secret != ""
+}
+
+// CRDArgs holds all of the AzpAgentPool custom resource related args
+type CRDArgs struct {
+	Enabled   bool
+	Namespace string
+}
+
+// RetryArgs holds all of the error handling related args
+type RetryArgs struct {
+	FailureThreshold int
+	MaxBackoff       time.Duration
+}
+
+// LeaderElectionArgs holds all of the leader election related args
+type LeaderElectionArgs struct {
+	Enabled       bool
+	Name          string
+	Namespace     string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+	PodName       string
+}
+
+// ForecastArgs holds all of the predictive scaling related args
+type ForecastArgs struct {
+	File               string
+	ConfigMap          string
+	ConfigMapNamespace string
+	LeadTime           time.Duration
+}
+
+// Enabled returns whether the demand is forecasted
+func (a ForecastArgs) Enabled() bool {
+	return a.File != "" || a.ConfigMap != ""
+}
+
 // FriendlyName returns the name used to reference the resource in the CLI, ex: deployment/myapp
 func (a KubernetesArgs) FriendlyName() string {
 	return fmt.Sprintf("%s/%s", strings.ToLower(a.Type), a.Name)
@@ -69,35 +228,193 @@ func (a KubernetesArgs) FriendlyName() string {
 type AzureDevopsArgs struct {
 	Token string
 	URL   string
+
+	// APIVersion is the API version of every call. If empty, it is negotiated with the server.
+	APIVersion            string
+	CABundle              string
+	InsecureSkipTLSVerify bool
+
+	Auth AuthArgs
+}
+
+// AuthArgs holds the args to authenticate to Azure Devops with Entra ID, instead of the token
+type AuthArgs struct {
+	Type               string
+	TenantID           string
+	ClientID           string
+	ClientSecret       string
+	ClientCertificate  string
+	FederatedTokenFile string
+	AuthorityHost      string
+}
+
+// IsServicePrincipal returns whether to authenticate as a service principal, with a client secret or certificate
+func (a AuthArgs) IsServicePrincipal() bool {
+	return strings.EqualFold(a.Type, "ServicePrincipal")
+}
+
+// IsWorkloadIdentity returns whether to authenticate with workload identity federation
+func (a AuthArgs) IsWorkloadIdentity() bool {
+	return strings.EqualFold(a.Type, "WorkloadIdentity")
+}
+
+// PoolArgs returns the agent pool args of the Args
+func (a Args) PoolArgs() PoolArgs {
+	return PoolArgs{
+		Min:        a.Min,
+		Max:        a.Max,
+		Mode:       a.Mode,
+		PoolName:   a.PoolName,
+		ScaleDown:  a.ScaleDown,
+		Policy:     a.Policy,
+		Behavior:   a.Behavior,
+		Kubernetes: a.Kubernetes,
+		Schedules:  a.Schedules,
+	}
+}
+
+// ForPool returns a copy of the Args with the agent pool args replaced
+func (a Args) ForPool(pool PoolArgs) Args {
+	a.Min = pool.Min
+	a.Max = pool.Max
+	a.Mode = pool.Mode
+	a.PoolName = pool.PoolName
+	a.ScaleDown = pool.ScaleDown
+	a.Policy = pool.Policy
+	a.Behavior = pool.Behavior
+	a.Kubernetes = pool.Kubernetes
+	a.Schedules = pool.Schedules
+	a.Pools = []PoolArgs{pool}
+	return a
 }
 
 // ArgsFromFlags returns an Args parsed from the program flags
 func ArgsFromFlags() Args {
-	// error should be validated in ValidateArgs()
+	// errors should be validated in ValidateArgs()
 	logrusLevel, _ := log.ParseLevel(*logLevel)
+	pool := poolArgsFromFlags()
+	pools := []PoolArgs{pool}
+	if *configFile != "" {
+		pools, _ = LoadPools(*configFile, pool)
+	}
+	// Notifications trigger the autoscaling, so polling is only a fallback for missed notifications
+	pollInterval := *rate
+	if *webhookSecret != "" {
+		pollInterval = *webhookRate
+	}
 	return Args{
-		Min:  int32(*min),
-		Max:  int32(*max),
-		Rate: *rate,
-		ScaleDown: ScaleDownArgs{
-			Delay: *scaleDownDelay,
-			Max:   int32(*scaleDownMax),
-		},
+		Min:       pool.Min,
+		Max:       pool.Max,
+		Rate:      *rate,
+		Mode:      pool.Mode,
+		PoolName:  pool.PoolName,
+		DryRun:    *dryRun,
+		ScaleDown: pool.ScaleDown,
+		Policy:    pool.Policy,
+		Behavior:  pool.Behavior,
+		Schedules: pool.Schedules,
 		Logging: LoggingArgs{
 			Level: logrusLevel,
 		},
-		Kubernetes: KubernetesArgs{
-			Type:      *resourceType,
-			Name:      *resourceName,
-			Namespace: *resourceNamespace,
-		},
+		Kubernetes:         pool.Kubernetes,
+		RefreshInterval:    *refreshInterval,
+		MinTriggerInterval: *triggerInterval,
+		PollInterval:       pollInterval,
 		AZD: AzureDevopsArgs{
-			Token: *azpToken,
-			URL:   *azpURL,
+			Token:                 *azpToken,
+			URL:                   *azpURL,
+			APIVersion:            *azpAPIVersion,
+			CABundle:              *azpCABundle,
+			InsecureSkipTLSVerify: *azpInsecure,
+			Auth: AuthArgs{
+				Type:               *azpAuth,
+				TenantID:           *azpTenantID,
+				ClientID:           *azpClientID,
+				ClientSecret:       *azpClientSecret,
+				ClientCertificate:  *azpClientCert,
+				FederatedTokenFile: *azpFederatedToken,
+				AuthorityHost:      *azpAuthorityHost,
+			},
 		},
 		Health: HealthArgs{
 			Port: *port,
 		},
+		Decisions: DecisionArgs{
+			LogSize: *decisionLogSize,
+		},
+		Webhook: WebhookArgs{
+			Secret: *webhookSecret,
+			Rate:   *webhookRate,
+		},
+		CRD: CRDArgs{
+			Enabled:   *crd,
+			Namespace: *crdNamespace,
+		},
+		Retry: RetryArgs{
+			FailureThreshold: *failureThreshold,
+			MaxBackoff:       *maxBackoff,
+		},
+		Leader: LeaderElectionArgs{
+			Enabled:       *leaderElect,
+			Name:          *leaderElectName,
+			Namespace:     *leaderElectNS,
+			LeaseDuration: *leaseDuration,
+			RenewDeadline: *renewDeadline,
+			RetryPeriod:   *retryPeriod,
+			PodName:       *leaderElectPod,
+		},
+		Forecast: ForecastArgs{
+			File:               *forecastFile,
+			ConfigMap:          *forecastCM,
+			ConfigMapNamespace: *forecastCMNS,
+			LeadTime:           *forecastLeadTime,
+		},
+		Pools: pools,
+	}
+}
+
+func poolArgsFromFlags() PoolArgs {
+	// errors should be validated in ValidateArgs()
+	parsedSchedules, _ := parseSchedules(schedules)
+	parsedScaleUpPolicies, _ := parseBehaviorPolicies(scaleUpPolicies)
+	parsedScaleDownPolicies, _ := parseBehaviorPolicies(scaleDownPolicies)
+	return PoolArgs{
+		Min:      int32(*min),
+		Max:      int32(*max),
+		Mode:     *mode,
+		PoolName: *poolName,
+		ScaleDown: ScaleDownArgs{
+			Delay:       *scaleDownDelay,
+			Max:         int32(*scaleDownMax),
+			Strategy:    *scaleDownStrategy,
+			ToZeroDelay: *scaleToZeroDelay,
+		},
+		Policy: PolicyArgs{
+			Type:    *policy,
+			Buffer:  int32(*policyBuffer),
+			Step:    int32(*policyStep),
+			Percent: int32(*policyPercent),
+			Floor:   int32(*policyFloor),
+			Ceiling: int32(*policyCeiling),
+		},
+		Behavior: BehaviorArgs{
+			ScaleUp: ScalingRulesArgs{
+				StabilizationWindow: *scaleUpWindow,
+				SelectPolicy:        *scaleUpSelect,
+				Policies:            parsedScaleUpPolicies,
+			},
+			ScaleDown: ScalingRulesArgs{
+				StabilizationWindow: *scaleDownWindow,
+				SelectPolicy:        *scaleDownSelect,
+				Policies:            parsedScaleDownPolicies,
+			},
+		},
+		Kubernetes: KubernetesArgs{
+			Type:      *resourceType,
+			Name:      *resourceName,
+			Namespace: *resourceNamespace,
+		},
+		Schedules: parsedSchedules,
 	}
 }
 
@@ -109,40 +426,173 @@ func ValidateArgs() error {
 	if err != nil {
 		validationErrors = append(validationErrors, err.Error())
 	}
-	if *min < 1 {
-		validationErrors = append(validationErrors, "Min argument cannot be less than 1.")
-	}
-	if *max <= *min {
-		validationErrors = append(validationErrors, "Max pods argument must be greater than the minimum.")
-	}
 	if rate == nil {
 		validationErrors = append(validationErrors, "Rate is required.")
 	} else if rate.Seconds() <= 1 {
 		validationErrors = append(validationErrors, fmt.Sprintf("Rate '%s' is too low.", rate.String()))
 	}
-	if *scaleDownMax < 1 {
-		validationErrors = append(validationErrors, fmt.Sprintf("Scale-down-max argument cannot be less than 1."))
+	if *triggerInterval < 0 {
+		validationErrors = append(validationErrors, "Min trigger interval argument cannot be negative.")
 	}
-	if *resourceType != "StatefulSet" {
-		validationErrors = append(validationErrors, fmt.Sprintf("Unknown resource type %s.", *resourceType))
+	if _, err := parseSchedules(schedules); err != nil {
+		validationErrors = append(validationErrors, err.Error())
 	}
-	if *resourceName == "" {
-		validationErrors = append(validationErrors, fmt.Sprintf("%s name is required.", *resourceType))
+	if _, err := parseBehaviorPolicies(scaleUpPolicies); err != nil {
+		validationErrors = append(validationErrors, err.Error())
 	}
-	if *resourceNamespace == "" {
-		validationErrors = append(validationErrors, "Namespace is required.")
+	if _, err := parseBehaviorPolicies(scaleDownPolicies); err != nil {
+		validationErrors = append(validationErrors, err.Error())
+	}
+	if *failureThreshold < 1 {
+		validationErrors = append(validationErrors, "Failure threshold argument cannot be less than 1.")
+	}
+	if *maxBackoff < *rate {
+		validationErrors = append(validationErrors, fmt.Sprintf("Max backoff '%s' cannot be less than the rate.", maxBackoff.String()))
+	}
+	if *crd {
+		if *configFile != "" {
+			validationErrors = append(validationErrors, "The config file cannot be used with AzpAgentPool resources.")
+		}
+	} else if *configFile == "" {
+		validationErrors = append(validationErrors, ValidatePoolArgs(poolArgsFromFlags())...)
+	} else if pools, err := LoadPools(*configFile, poolArgsFromFlags()); err != nil {
+		validationErrors = append(validationErrors, err.Error())
+	} else if len(pools) == 0 {
+		validationErrors = append(validationErrors, fmt.Sprintf("Config file %s does not have any pools.", *configFile))
+	} else {
+		validationErrors = append(validationErrors, ValidatePools(pools)...)
+	}
+	if *leaderElect {
+		if *leaderElectName == "" {
+			validationErrors = append(validationErrors, "The leader election Lease name is required.")
+		}
+		if *leaderElectNS == "" {
+			validationErrors = append(validationErrors, "The leader election Lease namespace is required.")
+		}
+		if *retryPeriod <= 0 {
+			validationErrors = append(validationErrors, "The leader election retry period must be greater than 0.")
+		}
+		if *renewDeadline <= *retryPeriod {
+			validationErrors = append(validationErrors, "The leader election renew deadline must be greater than the retry period.")
+		}
+		if *leaseDuration <= *renewDeadline {
+			validationErrors = append(validationErrors, "The leader election lease duration must be greater than the renew deadline.")
+		}
+	}
+	if *forecastFile != "" && *forecastCM != "" {
+		validationErrors = append(validationErrors, "The forecast file and ConfigMap cannot both be set.")
+	}
+	if *forecastCM != "" && *forecastCMNS == "" {
+		validationErrors = append(validationErrors, "The forecast ConfigMap namespace is required.")
 	}
-	if *azpToken == "" {
-		validationErrors = append(validationErrors, "The Azure Devops token is required.")
+	if *forecastLeadTime < 0 {
+		validationErrors = append(validationErrors, "The forecast lead time cannot be negative.")
+	}
+	if strings.EqualFold(*azpAuth, "PAT") {
+		if *azpToken == "" {
+			validationErrors = append(validationErrors, "The Azure Devops token is required.")
+		}
+	} else if strings.EqualFold(*azpAuth, "ServicePrincipal") || strings.EqualFold(*azpAuth, "WorkloadIdentity") {
+		if *azpTenantID == "" {
+			validationErrors = append(validationErrors, "The tenant ID is required.")
+		}
+		if *azpClientID == "" {
+			validationErrors = append(validationErrors, "The client ID is required.")
+		}
+		if strings.EqualFold(*azpAuth, "ServicePrincipal") && (*azpClientSecret == "") == (*azpClientCert == "") {
+			validationErrors = append(validationErrors, "Exactly one of the client secret and the client certificate is required.")
+		}
+		if strings.EqualFold(*azpAuth, "WorkloadIdentity") && *azpFederatedToken == "" {
+			validationErrors = append(validationErrors, "The federated token file is required.")
+		}
+		if *azpAuthorityHost == "" {
+			validationErrors = append(validationErrors, "The authority host is required.")
+		}
+	} else {
+		validationErrors = append(validationErrors, fmt.Sprintf("Unknown auth %s. Expected PAT, ServicePrincipal or WorkloadIdentity.", *azpAuth))
 	}
 	if *azpURL == "" {
 		validationErrors = append(validationErrors, "The Azure Devops URL is required.")
 	}
+	if *azpAPIVersion != "" && !apiVersionRegex.MatchString(*azpAPIVersion) {
+		validationErrors = append(validationErrors, fmt.Sprintf("Invalid API version %s. Expected a version like 5.0 or 5.0-preview.1.", *azpAPIVersion))
+	}
 	if *port < 0 {
 		validationErrors = append(validationErrors, "The port must be greater than 0.")
 	}
+	if *refreshInterval < 0 {
+		validationErrors = append(validationErrors, "The refresh interval cannot be negative.")
+	}
+	if *webhookSecret != "" && *webhookRate < *rate {
+		validationErrors = append(validationErrors, fmt.Sprintf("Webhook rate '%s' cannot be less than the rate.", webhookRate.String()))
+	}
+	if *webhookSecret != "" && *triggerInterval <= 0 {
+		validationErrors = append(validationErrors, "Min trigger interval argument must be greater than 0 when the webhook receiver is enabled.")
+	}
+	if *decisionLogSize < 0 {
+		validationErrors = append(validationErrors, "The decision log size cannot be negative.")
+	}
 	if len(validationErrors) > 0 {
 		return fmt.Errorf("Error(s) with arguments:\n%s", strings.Join(validationErrors, "\n"))
 	}
 	return nil
 }
+
+// ValidatePoolArgs validates the args of one agent pool
+func ValidatePoolArgs(pool PoolArgs) []string {
+	var validationErrors []string
+	if pool.Min < 0 {
+		validationErrors = append(validationErrors, "Min argument cannot be less than 0.")
+	}
+	if pool.Max <= pool.Min {
+		validationErrors = append(validationErrors, "Max pods argument must be greater than the minimum.")
+	}
+	if !strings.EqualFold(pool.Mode, "Replicas") && !strings.EqualFold(pool.Mode, "Jobs") {
+		validationErrors = append(validationErrors, fmt.Sprintf("Unknown mode %s.", pool.Mode))
+	} else if strings.EqualFold(pool.Mode, "Jobs") && !strings.EqualFold(pool.ScaleDown.Strategy, "Replicas") {
+		validationErrors = append(validationErrors, fmt.Sprintf("Scale down strategy %s cannot be used with Jobs mode.", pool.ScaleDown.Strategy))
+	}
+	if pool.ScaleDown.ToZeroDelay < 0 {
+		validationErrors = append(validationErrors, "Scale-to-zero-delay argument cannot be negative.")
+	}
+	if pool.ScaleDown.Max < 1 {
+		validationErrors = append(validationErrors, fmt.Sprintf("Scale-down-max argument cannot be less than 1."))
+	}
+	if !strings.EqualFold(pool.Policy.Type, "Default") && !strings.EqualFold(pool.Policy.Type, "FixedBuffer") && !strings.EqualFold(pool.Policy.Type, "Step") && !strings.EqualFold(pool.Policy.Type, "PercentageBuffer") {
+		validationErrors = append(validationErrors, fmt.Sprintf("Unknown scaling policy %s.", pool.Policy.Type))
+	}
+	if pool.Policy.Buffer < 0 {
+		validationErrors = append(validationErrors, "Policy-buffer argument cannot be less than 0.")
+	}
+	if pool.Policy.Step < 1 {
+		validationErrors = append(validationErrors, "Policy-step argument cannot be less than 1.")
+	}
+	if pool.Policy.Percent < 0 {
+		validationErrors = append(validationErrors, "Policy-percent argument cannot be less than 0.")
+	}
+	if pool.Policy.Floor < 0 {
+		validationErrors = append(validationErrors, "Policy-floor argument cannot be less than 0.")
+	}
+	if pool.Policy.Ceiling < 0 {
+		validationErrors = append(validationErrors, "Policy-ceiling argument cannot be less than 0.")
+	} else if pool.Policy.Ceiling > 0 && pool.Policy.Ceiling < pool.Policy.Floor {
+		validationErrors = append(validationErrors, "Policy-ceiling argument cannot be less than the floor.")
+	}
+	if !strings.EqualFold(pool.Kubernetes.Type, "StatefulSet") && !strings.EqualFold(pool.Kubernetes.Type, "Deployment") {
+		validationErrors = append(validationErrors, fmt.Sprintf("Unknown resource type %s.", pool.Kubernetes.Type))
+	}
+	if !strings.EqualFold(pool.ScaleDown.Strategy, "Replicas") && !strings.EqualFold(pool.ScaleDown.Strategy, "DeletionCost") && !strings.EqualFold(pool.ScaleDown.Strategy, "Delete") {
+		validationErrors = append(validationErrors, fmt.Sprintf("Unknown scale down strategy %s.", pool.ScaleDown.Strategy))
+	} else if !strings.EqualFold(pool.ScaleDown.Strategy, "Replicas") && !strings.EqualFold(pool.Kubernetes.Type, "Deployment") {
+		validationErrors = append(validationErrors, fmt.Sprintf("Scale down strategy %s requires a Deployment.", pool.ScaleDown.Strategy))
+	}
+	if pool.Kubernetes.Name == "" {
+		validationErrors = append(validationErrors, fmt.Sprintf("%s name is required.", pool.Kubernetes.Type))
+	}
+	if pool.Kubernetes.Namespace == "" {
+		validationErrors = append(validationErrors, "Namespace is required.")
+	}
+	validationErrors = append(validationErrors, validateBehavior(pool.Behavior)...)
+	validationErrors = append(validationErrors, validateSchedules(pool)...)
+	return validationErrors
+}
@@ -0,0 +1,114 @@
+package args
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBehaviorPeriod is the longest period of a behavior policy, and the longest stabilization window, like the HorizontalPodAutoscaler
+const maxBehaviorPeriod = 30 * time.Minute
+
+// BehaviorArgs holds the scaling behavior of an agent pool in each direction, like the HorizontalPodAutoscaler behavior
+type BehaviorArgs struct {
+	ScaleUp   ScalingRulesArgs
+	ScaleDown ScalingRulesArgs
+}
+
+// ScalingRulesArgs holds the scaling rules of one direction
+type ScalingRulesArgs struct {
+	// The recommendations over the window are used to avoid flapping.
+	// Scaling up uses the lowest recommendation, and scaling down uses the highest.
+	StabilizationWindow time.Duration
+	// Max selects the policy allowing the most change, Min the least change, and Disabled prevents scaling in the direction
+	SelectPolicy string
+	Policies     []BehaviorPolicyArgs
+}
+
+// BehaviorPolicyArgs limits the change of replicas over a period
+type BehaviorPolicyArgs struct {
+	// Pods or Percent
+	Type   string
+	Value  int32
+	Period time.Duration
+}
+
+// behaviorPolicyFlags holds each -scale-up-policy or -scale-down-policy flag
+type behaviorPolicyFlags []string
+
+func (b *behaviorPolicyFlags) String() string {
+	return strings.Join(*b, " ")
+}
+
+// Set adds a behavior policy flag. Errors are validated in ValidateArgs().
+func (b *behaviorPolicyFlags) Set(value string) error {
+	*b = append(*b, value)
+	return nil
+}
+
+// parseBehaviorPolicies parses behavior policy flags, ex: type=Pods;value=4;period=1m
+func parseBehaviorPolicies(flags behaviorPolicyFlags) ([]BehaviorPolicyArgs, error) {
+	var policies []BehaviorPolicyArgs
+	for _, flag := range flags {
+		policy := BehaviorPolicyArgs{}
+		for _, setting := range strings.Split(flag, ";") {
+			keyValue := strings.SplitN(setting, "=", 2)
+			if len(keyValue) != 2 {
+				return nil, fmt.Errorf("Behavior policy '%s' has an invalid setting '%s'.", flag, setting)
+			}
+			key, value := strings.TrimSpace(keyValue[0]), strings.TrimSpace(keyValue[1])
+			switch strings.ToLower(key) {
+			case "type":
+				policy.Type = value
+			case "value":
+				parsed, err := strconv.ParseInt(value, 10, 32)
+				if err != nil {
+					return nil, fmt.Errorf("Behavior policy '%s' has an invalid value '%s'.", flag, value)
+				}
+				policy.Value = int32(parsed)
+			case "period":
+				period, err := time.ParseDuration(value)
+				if err != nil {
+					return nil, fmt.Errorf("Behavior policy '%s' has an invalid period '%s'.", flag, value)
+				}
+				policy.Period = period
+			default:
+				return nil, fmt.Errorf("Behavior policy '%s' has an unknown setting '%s'.", flag, key)
+			}
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// validateBehavior validates the scaling behavior of one agent pool
+func validateBehavior(behavior BehaviorArgs) []string {
+	var validationErrors []string
+	for _, rules := range []struct {
+		direction string
+		rules     ScalingRulesArgs
+	}{
+		{"Scale up", behavior.ScaleUp},
+		{"Scale down", behavior.ScaleDown},
+	} {
+		if rules.rules.StabilizationWindow < 0 || rules.rules.StabilizationWindow > maxBehaviorPeriod {
+			validationErrors = append(validationErrors, fmt.Sprintf("%s stabilization window must be between 0 and %s.", rules.direction, maxBehaviorPeriod.String()))
+		}
+		if rules.rules.SelectPolicy != "" && !strings.EqualFold(rules.rules.SelectPolicy, "Max") && !strings.EqualFold(rules.rules.SelectPolicy, "Min") && !strings.EqualFold(rules.rules.SelectPolicy, "Disabled") {
+			validationErrors = append(validationErrors, fmt.Sprintf("%s select policy %s is unknown.", rules.direction, rules.rules.SelectPolicy))
+		}
+		for i, policy := range rules.rules.Policies {
+			if !strings.EqualFold(policy.Type, "Pods") && !strings.EqualFold(policy.Type, "Percent") {
+				validationErrors = append(validationErrors, fmt.Sprintf("%s policy %d has an unknown type %s.", rules.direction, i, policy.Type))
+			}
+			if policy.Value < 1 {
+				validationErrors = append(validationErrors, fmt.Sprintf("%s policy %d value must be greater than 0.", rules.direction, i))
+			}
+			if policy.Period <= 0 || policy.Period > maxBehaviorPeriod {
+				validationErrors = append(validationErrors, fmt.Sprintf("%s policy %d period must be greater than 0 and at most %s.", rules.direction, i, maxBehaviorPeriod.String()))
+			}
+		}
+	}
+	return validationErrors
+}
@@ -0,0 +1,212 @@
+package args
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// config is the structure of the file passed to -config
+type config struct {
+	Pools []json.RawMessage `json:"pools"`
+}
+
+// poolConfig is the structure of a pool in the config file
+type poolConfig struct {
+	Pool      string           `json:"pool"`
+	Kind      string           `json:"kind"`
+	Name      string           `json:"name"`
+	Namespace string           `json:"namespace"`
+	Min       int32            `json:"min"`
+	Max       int32            `json:"max"`
+	Mode      string           `json:"mode"`
+	ScaleDown scaleDownConfig  `json:"scaleDown"`
+	Policy    policyConfig     `json:"policy"`
+	Behavior  behaviorConfig   `json:"behavior"`
+	Schedules []scheduleConfig `json:"schedules"`
+}
+
+// scaleDownConfig is the structure of a pool's scale down settings in the config file
+type scaleDownConfig struct {
+	Delay       Duration `json:"delay"`
+	Max         int32    `json:"max"`
+	Strategy    string   `json:"strategy"`
+	ToZeroDelay Duration `json:"scaleToZeroDelay"`
+}
+
+// policyConfig is the structure of a pool's scaling policy in the config file
+type policyConfig struct {
+	Type    string `json:"type"`
+	Buffer  int32  `json:"buffer"`
+	Step    int32  `json:"step"`
+	Percent int32  `json:"percent"`
+	Floor   int32  `json:"floor"`
+	Ceiling int32  `json:"ceiling"`
+}
+
+// behaviorConfig is the structure of a pool's scaling behavior in the config file
+type behaviorConfig struct {
+	ScaleUp   scalingRulesConfig `json:"scaleUp"`
+	ScaleDown scalingRulesConfig `json:"scaleDown"`
+}
+
+// scalingRulesConfig is the structure of a pool's scaling rules of one direction in the config file
+type scalingRulesConfig struct {
+	StabilizationWindow Duration               `json:"stabilizationWindow"`
+	SelectPolicy        string                 `json:"selectPolicy"`
+	Policies            []behaviorPolicyConfig `json:"policies"`
+}
+
+// behaviorPolicyConfig is the structure of a behavior policy in the config file
+type behaviorPolicyConfig struct {
+	Type   string   `json:"type"`
+	Value  int32    `json:"value"`
+	Period Duration `json:"period"`
+}
+
+// scheduleConfig is the structure of a pool's schedule rule in the config file
+type scheduleConfig struct {
+	Name     string `json:"name"`
+	Cron     string `json:"cron"`
+	Timezone string `json:"timezone"`
+	Min      *int32 `json:"min"`
+	Max      *int32 `json:"max"`
+}
+
+// Duration is a time.Duration that is written as a string (ex: 30s) in config files
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("Error parsing duration %s: durations must be strings, ex: 30s", string(data))
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// LoadPools reads the pools from a config file. Missing values are taken from the defaults.
+func LoadPools(path string, defaults PoolArgs) ([]PoolArgs, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading config file %s: %s", path, err.Error())
+	}
+	jsonContent, err := yaml.YAMLToJSON(content)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing config file %s: %s", path, err.Error())
+	}
+	parsedConfig := config{}
+	if err := json.Unmarshal(jsonContent, &parsedConfig); err != nil {
+		return nil, fmt.Errorf("Error parsing config file %s: %s", path, err.Error())
+	}
+
+	var pools []PoolArgs
+	for i, rawPool := range parsedConfig.Pools {
+		pool := poolConfig{
+			Pool:      defaults.PoolName,
+			Kind:      defaults.Kubernetes.Type,
+			Namespace: defaults.Kubernetes.Namespace,
+			Min:       defaults.Min,
+			Max:       defaults.Max,
+			Mode:      defaults.Mode,
+			ScaleDown: scaleDownConfig{
+				Delay:       Duration(defaults.ScaleDown.Delay),
+				Max:         defaults.ScaleDown.Max,
+				Strategy:    defaults.ScaleDown.Strategy,
+				ToZeroDelay: Duration(defaults.ScaleDown.ToZeroDelay),
+			},
+			Policy: policyConfig(defaults.Policy),
+			Behavior: behaviorConfig{
+				ScaleUp: scalingRulesConfig{
+					StabilizationWindow: Duration(defaults.Behavior.ScaleUp.StabilizationWindow),
+					SelectPolicy:        defaults.Behavior.ScaleUp.SelectPolicy,
+				},
+				ScaleDown: scalingRulesConfig{
+					StabilizationWindow: Duration(defaults.Behavior.ScaleDown.StabilizationWindow),
+					SelectPolicy:        defaults.Behavior.ScaleDown.SelectPolicy,
+				},
+			},
+		}
+		if err := json.Unmarshal(rawPool, &pool); err != nil {
+			return nil, fmt.Errorf("Error parsing pool %d in config file %s: %s", i, path, err.Error())
+		}
+		// Schedules are replaced instead of merged, so the defaults are only used if the pool has none
+		poolSchedules := defaults.Schedules
+		if pool.Schedules != nil {
+			poolSchedules = nil
+			for _, schedule := range pool.Schedules {
+				poolSchedules = append(poolSchedules, ScheduleArgs(schedule))
+			}
+		}
+		pools = append(pools, PoolArgs{
+			Min:      pool.Min,
+			Max:      pool.Max,
+			Mode:     pool.Mode,
+			PoolName: pool.Pool,
+			ScaleDown: ScaleDownArgs{
+				Delay:       time.Duration(pool.ScaleDown.Delay),
+				Max:         pool.ScaleDown.Max,
+				Strategy:    pool.ScaleDown.Strategy,
+				ToZeroDelay: time.Duration(pool.ScaleDown.ToZeroDelay),
+			},
+			Policy: PolicyArgs(pool.Policy),
+			Behavior: BehaviorArgs{
+				ScaleUp:   pool.Behavior.ScaleUp.rules(defaults.Behavior.ScaleUp),
+				ScaleDown: pool.Behavior.ScaleDown.rules(defaults.Behavior.ScaleDown),
+			},
+			Kubernetes: KubernetesArgs{
+				Type:      pool.Kind,
+				Name:      pool.Name,
+				Namespace: pool.Namespace,
+			},
+			Schedules: poolSchedules,
+		})
+	}
+	return pools, nil
+}
+
+// ValidatePools validates the pools of a config file. Each workload can only be listed once.
+func ValidatePools(pools []PoolArgs) []string {
+	var validationErrors []string
+	workloads := make(map[string]bool)
+	for i, pool := range pools {
+		for _, validationError := range ValidatePoolArgs(pool) {
+			validationErrors = append(validationErrors, fmt.Sprintf("Pool %d (%s): %s", i, pool.Kubernetes.FriendlyName(), validationError))
+		}
+		workload := fmt.Sprintf("%s/%s", pool.Kubernetes.Namespace, strings.ToLower(pool.Kubernetes.FriendlyName()))
+		if workloads[workload] {
+			validationErrors = append(validationErrors, fmt.Sprintf("Pool %d (%s): the workload is listed more than once.", i, pool.Kubernetes.FriendlyName()))
+		}
+		workloads[workload] = true
+	}
+	return validationErrors
+}
+
+// rules returns the scaling rules of one direction. Policies are replaced instead of merged, so the defaults are only used if the pool has none.
+func (c scalingRulesConfig) rules(defaults ScalingRulesArgs) ScalingRulesArgs {
+	rules := ScalingRulesArgs{
+		StabilizationWindow: time.Duration(c.StabilizationWindow),
+		SelectPolicy:        c.SelectPolicy,
+		Policies:            defaults.Policies,
+	}
+	if c.Policies != nil {
+		rules.Policies = nil
+		for _, policy := range c.Policies {
+			rules.Policies = append(rules.Policies, BehaviorPolicyArgs{
+				Type:   policy.Type,
+				Value:  policy.Value,
+				Period: time.Duration(policy.Period),
+			})
+		}
+	}
+	return rules
+}
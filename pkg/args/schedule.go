@@ -0,0 +1,116 @@
+package args
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/cron"
+)
+
+// ScheduleArgs holds a schedule rule, which overrides the min and max of an agent pool while its cron expression matches
+type ScheduleArgs struct {
+	Name     string
+	Cron     string
+	Timezone string
+	Min      *int32
+	Max      *int32
+}
+
+// scheduleFlags holds each -schedule flag
+type scheduleFlags []string
+
+func (s *scheduleFlags) String() string {
+	return strings.Join(*s, " ")
+}
+
+// Set adds a -schedule flag. Errors are validated in ValidateArgs().
+func (s *scheduleFlags) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// parseSchedules parses the -schedule flags, ex: name=business-hours;cron=* 8-17 * * mon-fri;timezone=America/Toronto;min=5;max=50
+func parseSchedules(flags scheduleFlags) ([]ScheduleArgs, error) {
+	var schedules []ScheduleArgs
+	for _, flag := range flags {
+		schedule := ScheduleArgs{}
+		for _, setting := range strings.Split(flag, ";") {
+			keyValue := strings.SplitN(setting, "=", 2)
+			if len(keyValue) != 2 {
+				return nil, fmt.Errorf("Schedule '%s' has an invalid setting '%s'.", flag, setting)
+			}
+			key, value := strings.TrimSpace(keyValue[0]), strings.TrimSpace(keyValue[1])
+			switch strings.ToLower(key) {
+			case "name":
+				schedule.Name = value
+			case "cron":
+				schedule.Cron = value
+			case "timezone":
+				schedule.Timezone = value
+			case "min", "max":
+				parsed, err := strconv.ParseInt(value, 10, 32)
+				if err != nil {
+					return nil, fmt.Errorf("Schedule '%s' has an invalid %s '%s'.", flag, key, value)
+				}
+				parsed32 := int32(parsed)
+				if strings.EqualFold(key, "min") {
+					schedule.Min = &parsed32
+				} else {
+					schedule.Max = &parsed32
+				}
+			default:
+				return nil, fmt.Errorf("Schedule '%s' has an unknown setting '%s'.", flag, key)
+			}
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, nil
+}
+
+// validateSchedules validates the schedule rules of one agent pool
+func validateSchedules(pool PoolArgs) []string {
+	var validationErrors []string
+	names := make(map[string]bool)
+	for i, schedule := range pool.Schedules {
+		name := schedule.Name
+		if name == "" {
+			validationErrors = append(validationErrors, fmt.Sprintf("Schedule %d name is required.", i))
+			name = strconv.Itoa(i)
+		} else if names[name] {
+			validationErrors = append(validationErrors, fmt.Sprintf("Schedule %s is listed more than once.", name))
+		}
+		names[name] = true
+
+		if _, err := cron.Parse(schedule.Cron); err != nil {
+			validationErrors = append(validationErrors, fmt.Sprintf("Schedule %s: %s.", name, err.Error()))
+		}
+		if _, err := time.LoadLocation(schedule.Timezone); err != nil {
+			validationErrors = append(validationErrors, fmt.Sprintf("Schedule %s has an unknown timezone %s.", name, schedule.Timezone))
+		}
+		if schedule.Min == nil && schedule.Max == nil {
+			validationErrors = append(validationErrors, fmt.Sprintf("Schedule %s must override the min or the max.", name))
+		}
+
+		min, max := schedule.Apply(pool.Min, pool.Max)
+		if min < 0 {
+			validationErrors = append(validationErrors, fmt.Sprintf("Schedule %s min cannot be less than 0.", name))
+		}
+		if max <= min {
+			validationErrors = append(validationErrors, fmt.Sprintf("Schedule %s max must be greater than the minimum.", name))
+		}
+	}
+	return validationErrors
+}
+
+// Apply returns the min and max of an agent pool overridden by the schedule
+func (s ScheduleArgs) Apply(min int32, max int32) (int32, int32) {
+	if s.Min != nil {
+		min = *s.Min
+	}
+	if s.Max != nil {
+		max = *s.Max
+	}
+	return min, max
+}
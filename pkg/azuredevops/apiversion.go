@@ -0,0 +1,178 @@
+package azuredevops
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/logging"
+)
+
+// preferredAPIVersion is the API version requested from servers that support it
+const preferredAPIVersion = "5.0"
+
+// defaultAPIVersion is used when the API versions can't be negotiated with the server
+const defaultAPIVersion = "5.0-preview.1"
+
+// resourceLocationsEndpoint lists the API versions of every resource, with an OPTIONS request
+const resourceLocationsEndpoint = "/_apis"
+
+// negotiationTimeout is the time to wait for the resource locations, since the calls of every agent pool wait for the negotiation
+const negotiationTimeout = 30 * time.Second
+
+// The area and names of the resources called by the Client
+const (
+	distributedTaskArea = "distributedtask"
+	poolsResource       = "pools"
+	agentsResource      = "agents"
+	jobRequestsResource = "jobrequests"
+)
+
+// ResourceLocation is the API versions supported by the server for a resource
+type ResourceLocation struct {
+	ID              string `json:"id"`
+	Area            string `json:"area"`
+	ResourceName    string `json:"resourceName"`
+	RouteTemplate   string `json:"routeTemplate"`
+	ResourceVersion int    `json:"resourceVersion"`
+	MinVersion      string `json:"minVersion"`
+	MaxVersion      string `json:"maxVersion"`
+	ReleasedVersion string `json:"releasedVersion"`
+}
+
+// ResourceLocations is the response of the resource locations endpoint
+type ResourceLocations struct {
+	Count int                `json:"count"`
+	Value []ResourceLocation `json:"value"`
+}
+
+// apiVersions negotiates the API version of each resource with the server, the first time it's called.
+// Azure Devops Server and TFS collections support older API versions than Azure Devops Services.
+type apiVersions struct {
+	// The configured API version, used for every resource instead of negotiating
+	configured string
+
+	versions   map[string]string
+	negotiated bool
+	// Closed when the negotiation in progress finishes
+	negotiating chan struct{}
+	lock        sync.Mutex
+}
+
+// get returns the API version to call a resource with
+func (v *apiVersions) get(c ClientImpl, resource string) string {
+	if v.configured != "" {
+		return v.configured
+	}
+
+	v.lock.Lock()
+	if !v.negotiated {
+		if v.negotiating == nil {
+			// The lock isn't held during the request. The other calls wait for it, until it times out.
+			done := make(chan struct{})
+			v.negotiating = done
+			v.lock.Unlock()
+			versions, negotiated := v.negotiate(c)
+			v.lock.Lock()
+			v.versions, v.negotiated, v.negotiating = versions, negotiated, nil
+			close(done)
+		} else {
+			// Wait for the negotiation in progress, which times out
+			done := v.negotiating
+			v.lock.Unlock()
+			<-done
+			v.lock.Lock()
+		}
+	}
+	version, exists := v.versions[resource]
+	v.lock.Unlock()
+
+	if exists {
+		return version
+	}
+	return defaultAPIVersion
+}
+
+// negotiate retrieves the resource locations of the server, and returns the API version of each resource,
+// and whether the negotiation is done
+func (v *apiVersions) negotiate(c ClientImpl) (map[string]string, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), negotiationTimeout)
+	defer cancel()
+
+	locations := new(ResourceLocations)
+	if err := c.executeRequest(ctx, "OPTIONS", resourceLocationsEndpoint, "application/json", locations); err != nil {
+		logging.Logger.Warnf("Error negotiating the Azure Devops API versions, using API version %s: %s", defaultAPIVersion, err.Error())
+		// Servers without resource locations won't get them later, but transient errors are retried on the next call
+		httpErr, ok := err.(*HTTPError)
+		return nil, ok && httpErr.StatusCode >= 400 && httpErr.StatusCode < 500 && httpErr.StatusCode != http.StatusTooManyRequests
+	}
+
+	versions := make(map[string]string)
+	for _, resource := range []string{poolsResource, agentsResource, jobRequestsResource} {
+		for _, location := range locations.Value {
+			if strings.EqualFold(location.Area, distributedTaskArea) && strings.EqualFold(location.ResourceName, resource) {
+				versions[resource] = NegotiateAPIVersion(preferredAPIVersion, location)
+				logging.Logger.Debugf("Using API version %s for Azure Devops resource %s", versions[resource], resource)
+				break
+			}
+		}
+	}
+	return versions, true
+}
+
+// NegotiateAPIVersion returns the API version to call a resource with, as close to the requested version as the server supports.
+// Versions that aren't released yet are called as previews.
+func NegotiateAPIVersion(requested string, location ResourceLocation) string {
+	version := requested
+	if compareAPIVersions(version, location.MaxVersion) > 0 {
+		version = location.MaxVersion
+	} else if compareAPIVersions(version, location.MinVersion) < 0 {
+		version = location.MinVersion
+	}
+	if compareAPIVersions(location.ReleasedVersion, version) < 0 {
+		resourceVersion := location.ResourceVersion
+		if resourceVersion < 1 {
+			resourceVersion = 1
+		}
+		return fmt.Sprintf("%s-preview.%d", version, resourceVersion)
+	}
+	return version
+}
+
+// compareAPIVersions compares API versions like 5.0 and 4.1, returning -1, 0 or 1. Invalid versions are 0.0.
+func compareAPIVersions(a string, b string) int {
+	aMajor, aMinor := parseAPIVersion(a)
+	bMajor, bMinor := parseAPIVersion(b)
+	if aMajor != bMajor {
+		if aMajor < bMajor {
+			return -1
+		}
+		return 1
+	} else if aMinor != bMinor {
+		if aMinor < bMinor {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+func parseAPIVersion(version string) (int, int) {
+	version = strings.SplitN(version, "-", 2)[0]
+	parts := strings.SplitN(version, ".", 2)
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0
+	}
+	minor := 0
+	if len(parts) == 2 {
+		if minor, err = strconv.Atoi(parts[1]); err != nil {
+			return 0, 0
+		}
+	}
+	return major, minor
+}
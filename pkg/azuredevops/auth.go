@@ -0,0 +1,324 @@
+package azuredevops
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/logging"
+)
+
+// azureDevopsScope is the Entra ID scope of Azure Devops, from its application ID
+const azureDevopsScope = "499b84ac-1321-427f-aa17-267ca6975798/.default"
+
+// Parameter 1 is the authority host, parameter 2 is the tenant ID
+const tokenEndpoint = "%s/%s/oauth2/v2.0/token"
+
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// tokenRefreshBefore is how long before they expire that access tokens are refreshed
+const tokenRefreshBefore = 5 * time.Minute
+
+// clientAssertionLifetime is how long the client assertions signed with a certificate are valid
+const clientAssertionLifetime = 10 * time.Minute
+
+var (
+	tokenRefreshCounts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "azp_agent_autoscaler_azd_token_refresh_count",
+		Help: "The total number of Entra ID access tokens requested to call Azure Devops",
+	})
+	tokenRefreshFailureCounts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "azp_agent_autoscaler_azd_token_refresh_failures_count",
+		Help: "The total number of failed Entra ID access token requests",
+	})
+	tokenExpiry = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "azp_agent_autoscaler_azd_token_expiry_timestamp_seconds",
+		Help: "The time the current Entra ID access token expires, as a Unix timestamp",
+	})
+)
+
+// AuthProvider authenticates the calls to Azure Devops
+type AuthProvider interface {
+	Authenticate(request *http.Request) error
+}
+
+// MakeAuthProvider returns the AuthProvider of the configured authentication type
+func MakeAuthProvider(args args.AzureDevopsArgs) (AuthProvider, error) {
+	switch {
+	case args.Auth.IsServicePrincipal():
+		if args.Auth.ClientCertificate != "" {
+			credential, err := makeClientCertificateCredential(args.Auth.ClientCertificate)
+			if err != nil {
+				return nil, err
+			}
+			return makeEntraIDAuthProvider(args.Auth, credential), nil
+		}
+		return makeEntraIDAuthProvider(args.Auth, clientSecretCredential(args.Auth.ClientSecret)), nil
+	case args.Auth.IsWorkloadIdentity():
+		return makeEntraIDAuthProvider(args.Auth, federatedTokenCredential(args.Auth.FederatedTokenFile)), nil
+	default:
+		return PATAuthProvider{args.Token}, nil
+	}
+}
+
+// PATAuthProvider authenticates with a personal access token, as the basic authentication password
+type PATAuthProvider struct {
+	token string
+}
+
+// Authenticate sets the personal access token of the request
+func (p PATAuthProvider) Authenticate(request *http.Request) error {
+	request.SetBasicAuth("user", p.token)
+	return nil
+}
+
+// clientCredential returns the form values that authenticate a service principal to the token endpoint.
+// The token endpoint URL is the audience of client assertions.
+type clientCredential func(tokenURL string, clientID string) (url.Values, error)
+
+// EntraIDAuthProvider authenticates as an Entra ID service principal or managed identity, with an access token.
+// The access token is cached, and refreshed before it expires.
+type EntraIDAuthProvider struct {
+	tokenURL   string
+	clientID   string
+	credential clientCredential
+	httpClient *http.Client
+
+	accessToken string
+	expiresOn   time.Time
+	lock        sync.Mutex
+}
+
+func makeEntraIDAuthProvider(auth args.AuthArgs, credential clientCredential) *EntraIDAuthProvider {
+	return &EntraIDAuthProvider{
+		tokenURL:   fmt.Sprintf(tokenEndpoint, strings.TrimRight(auth.AuthorityHost, "/"), url.PathEscape(auth.TenantID)),
+		clientID:   auth.ClientID,
+		credential: credential,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Authenticate sets the access token of the request
+func (p *EntraIDAuthProvider) Authenticate(request *http.Request) error {
+	accessToken, err := p.getAccessToken(time.Now())
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+accessToken)
+	return nil
+}
+
+// getAccessToken returns the cached access token, or requests a new one if it expires soon.
+// If the token can't be refreshed, the cached token is used until it expires.
+func (p *EntraIDAuthProvider) getAccessToken(now time.Time) (string, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.accessToken != "" && now.Add(tokenRefreshBefore).Before(p.expiresOn) {
+		return p.accessToken, nil
+	}
+
+	accessToken, expiresOn, err := p.requestAccessToken(now)
+	if err != nil {
+		tokenRefreshFailureCounts.Inc()
+		if p.accessToken != "" && now.Before(p.expiresOn) {
+			logging.Logger.Warnf("Error refreshing the Entra ID access token, using the current token until it expires at %s: %s", p.expiresOn.Format(time.RFC3339), err.Error())
+			return p.accessToken, nil
+		}
+		return "", err
+	}
+
+	logging.Logger.Debugf("Retrieved an Entra ID access token for client %s, expiring at %s", p.clientID, expiresOn.Format(time.RFC3339))
+	p.accessToken = accessToken
+	p.expiresOn = expiresOn
+	tokenExpiry.Set(float64(expiresOn.Unix()))
+	return accessToken, nil
+}
+
+// tokenResponse is the response of the token endpoint
+type tokenResponse struct {
+	TokenType        string `json:"token_type"`
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// requestAccessToken requests an access token with the client credentials grant
+func (p *EntraIDAuthProvider) requestAccessToken(now time.Time) (string, time.Time, error) {
+	tokenRefreshCounts.Inc()
+
+	form, err := p.credential(p.tokenURL, p.clientID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", p.clientID)
+	form.Set("scope", azureDevopsScope)
+
+	request, err := http.NewRequest("POST", p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("User-Agent", "go-azp-agent-autoscaler")
+
+	httpResponse, err := p.httpClient.Do(request)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer httpResponse.Body.Close()
+
+	response := new(tokenResponse)
+	decodeErr := json.NewDecoder(httpResponse.Body).Decode(response)
+	if httpResponse.StatusCode != 200 {
+		if decodeErr == nil && response.Error != "" {
+			logging.Logger.Errorf("Error requesting an Entra ID access token for client %s: %s: %s", p.clientID, response.Error, response.ErrorDescription)
+		}
+		return "", time.Time{}, NewHTTPError(httpResponse)
+	} else if decodeErr != nil {
+		return "", time.Time{}, fmt.Errorf("Error - could not parse JSON response from %s: %s", p.tokenURL, decodeErr.Error())
+	} else if response.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("Error - the token endpoint %s returned no access token", p.tokenURL)
+	}
+
+	return response.AccessToken, now.Add(time.Duration(response.ExpiresIn) * time.Second), nil
+}
+
+// clientSecretCredential authenticates a service principal with a client secret
+func clientSecretCredential(clientSecret string) clientCredential {
+	return func(tokenURL string, clientID string) (url.Values, error) {
+		return url.Values{"client_secret": {clientSecret}}, nil
+	}
+}
+
+// federatedTokenCredential authenticates with a federated token, such as a projected service account token
+// with workload identity federation. The file is read on each token request, since the kubelet rotates it.
+func federatedTokenCredential(tokenFile string) clientCredential {
+	return func(tokenURL string, clientID string) (url.Values, error) {
+		token, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading the federated token file %s: %s", tokenFile, err.Error())
+		}
+		return url.Values{
+			"client_assertion_type": {clientAssertionType},
+			"client_assertion":      {strings.TrimSpace(string(token))},
+		}, nil
+	}
+}
+
+// makeClientCertificateCredential authenticates a service principal with a client assertion signed by a certificate.
+// The file has the PEM certificate and its RSA private key.
+func makeClientCertificateCredential(certificateFile string) (clientCredential, error) {
+	certificate, privateKey, err := readClientCertificate(certificateFile)
+	if err != nil {
+		return nil, err
+	}
+	thumbprint := sha1.Sum(certificate.Raw)
+
+	return func(tokenURL string, clientID string) (url.Values, error) {
+		assertion, err := signClientAssertion(tokenURL, clientID, thumbprint[:], privateKey, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		return url.Values{
+			"client_assertion_type": {clientAssertionType},
+			"client_assertion":      {assertion},
+		}, nil
+	}, nil
+}
+
+func readClientCertificate(certificateFile string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	contents, err := os.ReadFile(certificateFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading the client certificate %s: %s", certificateFile, err.Error())
+	}
+
+	var certificate *x509.Certificate
+	var privateKey *rsa.PrivateKey
+	for block, rest := pem.Decode(contents); block != nil; block, rest = pem.Decode(rest) {
+		switch block.Type {
+		case "CERTIFICATE":
+			if certificate == nil {
+				if certificate, err = x509.ParseCertificate(block.Bytes); err != nil {
+					return nil, nil, fmt.Errorf("Error parsing the client certificate %s: %s", certificateFile, err.Error())
+				}
+			}
+		case "RSA PRIVATE KEY":
+			if privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+				return nil, nil, fmt.Errorf("Error parsing the private key of the client certificate %s: %s", certificateFile, err.Error())
+			}
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Error parsing the private key of the client certificate %s: %s", certificateFile, err.Error())
+			}
+			var ok bool
+			if privateKey, ok = key.(*rsa.PrivateKey); !ok {
+				return nil, nil, fmt.Errorf("Error - the private key of the client certificate %s is not an RSA key", certificateFile)
+			}
+		}
+	}
+
+	if certificate == nil {
+		return nil, nil, fmt.Errorf("Error - the client certificate %s has no PEM certificate", certificateFile)
+	} else if privateKey == nil {
+		return nil, nil, fmt.Errorf("Error - the client certificate %s has no PEM RSA private key", certificateFile)
+	}
+	return certificate, privateKey, nil
+}
+
+// signClientAssertion returns a JWT signed with the private key of the client certificate, identified by its SHA-1 thumbprint
+func signClientAssertion(tokenURL string, clientID string, thumbprint []byte, privateKey *rsa.PrivateKey, now time.Time) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"x5t": base64.RawURLEncoding.EncodeToString(thumbprint),
+	})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": tokenURL,
+		"iss": clientID,
+		"sub": clientID,
+		"jti": hex.EncodeToString(jti),
+		"nbf": now.Unix(),
+		"exp": now.Add(clientAssertionLifetime).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("Error signing the client assertion: %s", err.Error())
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -1,12 +1,16 @@
 package azuredevops
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/logging"
 )
 
 const getPoolsEndpoint = "/_apis/distributedtask/pools?poolName=%s"
@@ -16,7 +20,8 @@ const getPoolAgentsEndpoint = "/_apis/distributedtask/pools/%d/agents?includeCap
 
 const getPoolJobRequestsEndpoint = "/_apis/distributedtask/pools/%d/jobrequests"
 
-const acceptHeader = "application/json;api-version=5.0-preview.1"
+// Parameter 1 is the API version
+const acceptHeader = "application/json;api-version=%s"
 
 var (
 	azdDurations = promauto.NewHistogramVec(prometheus.HistogramOpts{
@@ -45,34 +50,60 @@ type Client interface {
 
 // ClientImpl is the interface implementation that calls Azure Devops
 type ClientImpl struct {
+	// The organization URL, or the collection URL with Azure Devops Server
 	baseURL string
 
-	token string
+	auth AuthProvider
+
+	rateLimiter *RateLimiter
+
+	httpClient *http.Client
+
+	apiVersions *apiVersions
 }
 
-func (c ClientImpl) executeGETRequest(endpoint string, response interface{}) error {
-	request, err := http.NewRequest("GET", c.baseURL+endpoint, nil)
+// executeGETRequest calls an endpoint of a resource, with the API version negotiated for the resource
+func (c ClientImpl) executeGETRequest(resource string, endpoint string, response interface{}) error {
+	return c.executeRequest(context.Background(), "GET", endpoint, fmt.Sprintf(acceptHeader, c.apiVersions.get(c, resource)), response)
+}
+
+func (c ClientImpl) executeRequest(ctx context.Context, method string, endpoint string, accept string, response interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, method, c.baseURL+endpoint, nil)
 
 	if err != nil {
 		return err
 	}
 
-	request.Header.Set("Accept", acceptHeader)
+	request.Header.Set("Accept", accept)
 	request.Header.Set("User-Agent", "go-azp-agent-autoscaler")
 
-	request.SetBasicAuth("user", c.token)
+	// Slow down when near the rate limit
+	if c.rateLimiter != nil {
+		if delay := c.rateLimiter.Delay(time.Now()); delay > 0 {
+			logging.Logger.Debugf("Delaying the call to %s by %s to stay under the Azure Devops rate limit", endpoint, delay.String())
+			time.Sleep(delay)
+		}
+	}
+
+	// Authenticate after the delay, so that the access token doesn't expire while waiting
+	if err := c.auth.Authenticate(request); err != nil {
+		return err
+	}
 
-	httpClient := http.Client{}
-	httpResponse, err := httpClient.Do(request)
+	httpResponse, err := c.httpClient.Do(request)
 	if err != nil {
 		return err
 	}
 
 	defer httpResponse.Body.Close()
 
+	if c.rateLimiter != nil {
+		c.rateLimiter.Observe(httpResponse.Header, time.Now())
+	}
+
 	if httpResponse.StatusCode != 200 {
 		httpErr := NewHTTPError(httpResponse)
-		if httpErr.RetryAfter != nil {
+		if httpErr.StatusCode == http.StatusTooManyRequests {
 			azd429Counts.Inc()
 		}
 		return httpErr
@@ -99,7 +130,7 @@ func (c ClientImpl) ListPoolsByName(poolName string) ([]PoolDetails, error) {
 
 	response := new(PoolList)
 	endpoint := fmt.Sprintf(getPoolsEndpoint, poolName)
-	err := c.executeGETRequest(endpoint, response)
+	err := c.executeGETRequest(poolsResource, endpoint, response)
 	if err != nil {
 		return nil, err
 	} else {
@@ -115,7 +146,7 @@ func (c ClientImpl) ListPoolAgents(poolID int) ([]AgentDetails, error) {
 
 	response := new(Pool)
 	endpoint := fmt.Sprintf(getPoolAgentsEndpoint, poolID)
-	err := c.executeGETRequest(endpoint, response)
+	err := c.executeGETRequest(agentsResource, endpoint, response)
 	if err != nil {
 		return nil, err
 	} else {
@@ -131,7 +162,7 @@ func (c ClientImpl) ListJobRequests(poolID int) ([]JobRequest, error) {
 
 	response := new(JobRequests)
 	endpoint := fmt.Sprintf(getPoolJobRequestsEndpoint, poolID)
-	err := c.executeGETRequest(endpoint, response)
+	err := c.executeGETRequest(jobRequestsResource, endpoint, response)
 	if err != nil {
 		return nil, err
 	} else {
@@ -1,7 +1,7 @@
 package azuredevops
 
 import (
-	"strings"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
 )
 
 // ClientAsync is an async version of Client
@@ -17,17 +17,30 @@ type ClientAsyncImpl struct {
 	client Client
 }
 
-// MakeClient creates a new Azure Devops client
-func MakeClient(baseURL string, token string) ClientAsync {
-	if !strings.HasSuffix(baseURL, "") {
-		baseURL = strings.TrimSuffix(baseURL, "/")
+// MakeClient creates a new Azure Devops client.
+// The URL is the organization URL, or the collection URL with Azure Devops Server, ex: https://tfs.example.com/tfs/DefaultCollection
+func MakeClient(args args.AzureDevopsArgs) (ClientAsync, error) {
+	baseURL, err := NormalizeURL(args.URL)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := makeHTTPClient(args)
+	if err != nil {
+		return nil, err
+	}
+	auth, err := MakeAuthProvider(args)
+	if err != nil {
+		return nil, err
 	}
 	return ClientAsyncImpl{
 		client: ClientImpl{
-			baseURL: baseURL,
-			token:   token,
+			baseURL:     baseURL,
+			auth:        auth,
+			rateLimiter: &RateLimiter{},
+			httpClient:  httpClient,
+			apiVersions: &apiVersions{configured: args.APIVersion},
 		},
-	}
+	}, nil
 }
 
 // PoolDetailsResponse is a wrapper for []PoolDetails to allow also returning an error in channels
@@ -0,0 +1,139 @@
+package azuredevops
+
+import (
+	"strconv"
+	"strings"
+)
+
+// systemCapabilityPrefix is the prefix of the capabilities the agent sets itself, ex: Agent.Version
+const systemCapabilityPrefix = "Agent."
+
+// Capabilities are the capabilities of an agent, by name.
+// A nil value means the capability exists, but its value isn't known.
+type Capabilities map[string]*string
+
+// Demand is a requirement of a job on the capabilities of an agent.
+// curl -u user:token https://dev.azure.com/organization/_apis/distributedtask/pools/9/jobrequests returns demands like "java" or "Agent.Version -gtVersion 2.163.1"
+type Demand struct {
+	Name     string
+	Operator DemandOperator
+	Value    string
+}
+
+// DemandOperator is how a demand is compared to a capability
+type DemandOperator string
+
+const (
+	// DemandOperatorExists requires the capability to exist
+	DemandOperatorExists DemandOperator = "exists"
+	// DemandOperatorEquals requires the capability to equal the value, ignoring case
+	DemandOperatorEquals DemandOperator = "equals"
+	// DemandOperatorGtVersion requires the capability to be a version greater than or equal to the value
+	DemandOperatorGtVersion DemandOperator = "gtVersion"
+)
+
+// ParseDemand parses a demand, ex: "java", "java -equals 11", or "Agent.Version -gtVersion 2.163.1"
+func ParseDemand(demand string) Demand {
+	parts := strings.SplitN(strings.TrimSpace(demand), " -", 2)
+	parsed := Demand{
+		Name:     strings.TrimSpace(parts[0]),
+		Operator: DemandOperatorExists,
+	}
+	if len(parts) == 2 {
+		operatorValue := strings.SplitN(strings.TrimSpace(parts[1]), " ", 2)
+		parsed.Operator = DemandOperator(operatorValue[0])
+		if len(operatorValue) == 2 {
+			parsed.Value = strings.TrimSpace(operatorValue[1])
+		}
+	}
+	return parsed
+}
+
+// SatisfiedBy returns whether agents with the given capabilities can run a job with the demand.
+// Capabilities the agent sets itself (Agent.*), unknown capability values, and unknown operators
+// can't be evaluated before an agent is online, so they are assumed to be satisfied.
+func (d Demand) SatisfiedBy(capabilities Capabilities) bool {
+	value, exists := findCapability(capabilities, d.Name)
+	if !exists {
+		return strings.HasPrefix(d.Name, systemCapabilityPrefix)
+	}
+	if value == nil {
+		return true
+	}
+
+	switch {
+	case strings.EqualFold(string(d.Operator), string(DemandOperatorExists)):
+		return true
+	case strings.EqualFold(string(d.Operator), string(DemandOperatorEquals)):
+		return strings.EqualFold(*value, d.Value)
+	case strings.EqualFold(string(d.Operator), string(DemandOperatorGtVersion)):
+		return compareVersions(*value, d.Value) >= 0
+	default:
+		return true
+	}
+}
+
+// MaySatisfy returns whether agents with the given declared capabilities may be able to run a job with the demand.
+// Agents also detect capabilities themselves when they start, ex. tools on the PATH like docker or maven, so a
+// capability that isn't declared may be satisfied. A declared capability must satisfy the demand.
+func (d Demand) MaySatisfy(capabilities Capabilities) bool {
+	if _, exists := findCapability(capabilities, d.Name); !exists {
+		return true
+	}
+	return d.SatisfiedBy(capabilities)
+}
+
+// DemandsSatisfied returns whether agents with the given capabilities can run a job with the demands
+func DemandsSatisfied(demands []string, capabilities Capabilities) bool {
+	for _, demand := range demands {
+		if !ParseDemand(demand).SatisfiedBy(capabilities) {
+			return false
+		}
+	}
+	return true
+}
+
+// DemandsMaySatisfy returns whether agents with the given declared capabilities may be able to run a job with the demands
+func DemandsMaySatisfy(demands []string, capabilities Capabilities) bool {
+	for _, demand := range demands {
+		if !ParseDemand(demand).MaySatisfy(capabilities) {
+			return false
+		}
+	}
+	return true
+}
+
+// findCapability finds a capability, ignoring case like Azure Devops
+func findCapability(capabilities Capabilities, name string) (*string, bool) {
+	if value, exists := capabilities[name]; exists {
+		return value, true
+	}
+	for capability, value := range capabilities {
+		if strings.EqualFold(capability, name) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// compareVersions compares dotted versions, ex: 2.163.1. Missing or non-numeric parts are 0.
+func compareVersions(version1 string, version2 string) int {
+	parts1 := strings.Split(version1, ".")
+	parts2 := strings.Split(version2, ".")
+	for i := 0; i < len(parts1) || i < len(parts2); i++ {
+		part1, part2 := 0, 0
+		if i < len(parts1) {
+			part1, _ = strconv.Atoi(parts1[i])
+		}
+		if i < len(parts2) {
+			part2, _ = strconv.Atoi(parts2[i])
+		}
+		if part1 != part2 {
+			if part1 < part2 {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
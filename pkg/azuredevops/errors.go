@@ -19,13 +19,7 @@ type HTTPError struct {
 func NewHTTPError(response *http.Response) *HTTPError {
 	var retryAfter *time.Duration
 	if response.StatusCode == http.StatusTooManyRequests || response.StatusCode == http.StatusServiceUnavailable {
-		retryAfterStr, exists := response.Header["Retry-After"]
-		if exists {
-			retryAfterVal, err := time.ParseDuration(fmt.Sprintf("%ss", retryAfterStr))
-			if err != nil {
-				retryAfter = &retryAfterVal
-			}
-		}
+		retryAfter = ParseRetryAfter(response.Header.Get("Retry-After"), time.Now())
 	}
 
 	return &HTTPError{
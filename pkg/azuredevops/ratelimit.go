@@ -0,0 +1,121 @@
+package azuredevops
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// rateLimitThreshold is the fraction of the rate limit left under which requests are slowed down
+const rateLimitThreshold = 0.1
+
+// maxRateLimitDelay caps the time a request is delayed for
+const maxRateLimitDelay = 5 * time.Minute
+
+var (
+	azdRateLimitGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "azp_agent_autoscaler_azd_rate_limit",
+		Help: "The Azure Devops rate limit, from the X-RateLimit-Limit header",
+	})
+	azdRateLimitRemainingGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "azp_agent_autoscaler_azd_rate_limit_remaining",
+		Help: "The Azure Devops rate limit remaining, from the X-RateLimit-Remaining header",
+	})
+	azdRateLimitResetGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "azp_agent_autoscaler_azd_rate_limit_reset_seconds",
+		Help: "The time the Azure Devops rate limit resets, in seconds since the epoch, from the X-RateLimit-Reset header",
+	})
+	azdRateLimitDelayGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "azp_agent_autoscaler_azd_rate_limit_delay_seconds",
+		Help: "The time Azure Devops requests are delayed for to stay under the rate limit",
+	})
+)
+
+// RateLimiter tracks the Azure Devops rate limit headers, and delays requests when the limit is near or exceeded
+type RateLimiter struct {
+	lock sync.Mutex
+
+	limit     int
+	remaining int
+	reset     time.Time
+
+	// Requests are blocked until this time, after a Retry-After
+	blockedUntil time.Time
+}
+
+// Observe updates the rate limit state from the headers of a response
+func (r *RateLimiter) Observe(header http.Header, now time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if limit, err := strconv.Atoi(header.Get("X-RateLimit-Limit")); err == nil {
+		r.limit = limit
+		azdRateLimitGauge.Set(float64(limit))
+	}
+	if remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining")); err == nil {
+		r.remaining = remaining
+		azdRateLimitRemainingGauge.Set(float64(remaining))
+	}
+	if reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		r.reset = time.Unix(reset, 0)
+		azdRateLimitResetGauge.Set(float64(reset))
+	}
+	if retryAfter := ParseRetryAfter(header.Get("Retry-After"), now); retryAfter != nil {
+		if blockedUntil := now.Add(*retryAfter); blockedUntil.After(r.blockedUntil) {
+			r.blockedUntil = blockedUntil
+		}
+	}
+}
+
+// Delay returns the time to wait before sending the next request.
+// After a Retry-After, requests wait until it has passed. When the remaining rate limit
+// is under the threshold, the remaining requests are spread out until the limit resets.
+func (r *RateLimiter) Delay(now time.Time) time.Duration {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delay := time.Duration(0)
+	if now.Before(r.blockedUntil) {
+		delay = r.blockedUntil.Sub(now)
+	} else if r.limit > 0 && float64(r.remaining) < float64(r.limit)*rateLimitThreshold && now.Before(r.reset) {
+		remaining := r.remaining
+		if remaining < 1 {
+			remaining = 1
+		}
+		delay = r.reset.Sub(now) / time.Duration(remaining)
+	}
+	if delay > maxRateLimitDelay {
+		delay = maxRateLimitDelay
+	}
+
+	azdRateLimitDelayGauge.Set(delay.Seconds())
+	return delay
+}
+
+// ParseRetryAfter parses a Retry-After header, which is either a number of seconds or an HTTP date.
+// nil is returned if the header is empty or invalid.
+func ParseRetryAfter(value string, now time.Time) *time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+
+	var retryAfter time.Duration
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		retryAfter = time.Duration(seconds * float64(time.Second))
+	} else if date, err := http.ParseTime(value); err == nil {
+		retryAfter = date.Sub(now)
+	} else {
+		return nil
+	}
+
+	if retryAfter < 0 {
+		retryAfter = 0
+	}
+	return &retryAfter
+}
@@ -0,0 +1,66 @@
+package azuredevops
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/logging"
+)
+
+// NormalizeURL validates an organization or collection URL, and removes its trailing slashes and _apis path,
+// so that endpoints can be appended to it
+func NormalizeURL(baseURL string) (string, error) {
+	parsedURL, err := url.Parse(strings.TrimSpace(baseURL))
+	if err != nil {
+		return "", fmt.Errorf("Error parsing the Azure Devops URL %s: %s", baseURL, err.Error())
+	}
+	if !strings.EqualFold(parsedURL.Scheme, "https") && !strings.EqualFold(parsedURL.Scheme, "http") {
+		return "", fmt.Errorf("Error - the Azure Devops URL %s must be an http or https URL", baseURL)
+	} else if parsedURL.Host == "" {
+		return "", fmt.Errorf("Error - the Azure Devops URL %s has no host", baseURL)
+	} else if parsedURL.RawQuery != "" || parsedURL.Fragment != "" {
+		return "", fmt.Errorf("Error - the Azure Devops URL %s cannot have a query string or a fragment", baseURL)
+	}
+
+	path := strings.TrimRight(parsedURL.Path, "/")
+	if strings.HasSuffix(strings.ToLower(path), "/_apis") {
+		path = strings.TrimRight(path[:len(path)-len("/_apis")], "/")
+	}
+	parsedURL.Path = path
+	parsedURL.RawPath = ""
+	return parsedURL.String(), nil
+}
+
+// makeHTTPClient returns the HTTP client to call Azure Devops with.
+// Azure Devops Server is often served with a certificate from an internal CA, which can be trusted with a CA bundle.
+func makeHTTPClient(args args.AzureDevopsArgs) (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+	if args.CABundle != "" {
+		caBundle, err := os.ReadFile(args.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading the CA bundle %s: %s", args.CABundle, err.Error())
+		}
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil || rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("Error - the CA bundle %s has no PEM certificates", args.CABundle)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	if args.InsecureSkipTLSVerify {
+		logging.Logger.Warn("The TLS certificate of Azure Devops is not verified")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
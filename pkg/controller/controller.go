@@ -0,0 +1,355 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/azuredevops"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/logging"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/retry"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/scaling"
+)
+
+// Controller runs an autoscaling loop for each AzpAgentPool resource
+type Controller struct {
+	azdClient azuredevops.ClientAsync
+	k8sClient kubernetes.ClientAsync
+	args      args.Args
+
+	// The autoscaling loops, by AzpAgentPool namespace and name
+	loops map[string]*agentPoolLoop
+	// The done channel of the last autoscaling loop of each workload, by workload namespace and name.
+	// A new loop of a workload waits for the previous one to exit, so that they don't scale the workload at the same time.
+	workloadLoops map[string]chan struct{}
+}
+
+// agentPoolLoop is the autoscaling loop of one AzpAgentPool
+type agentPoolLoop struct {
+	pool *kubernetes.AzpAgentPool
+	// The workload, nil if the spec is invalid
+	workload *args.KubernetesArgs
+	// Whether the loop isn't running because another AzpAgentPool autoscales the workload
+	rejected bool
+	stop     chan struct{}
+	// Closed once the loop has exited
+	done chan struct{}
+}
+
+// MakeController returns a Controller. The args are used as the defaults of every AzpAgentPool.
+func MakeController(azdClient azuredevops.ClientAsync, k8sClient kubernetes.ClientAsync, args args.Args) *Controller {
+	return &Controller{
+		azdClient:     azdClient,
+		k8sClient:     k8sClient,
+		args:          args,
+		loops:         make(map[string]*agentPoolLoop),
+		workloadLoops: make(map[string]chan struct{}),
+	}
+}
+
+// Run watches AzpAgentPool resources in a namespace (or all namespaces if empty) until stopCh is closed.
+// The autoscaling loops have exited when it returns.
+func (c *Controller) Run(namespace string, stopCh <-chan struct{}) error {
+	events, err := c.k8sClient.Sync().WatchAgentPools(namespace, stopCh)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stopCh:
+			for key, loop := range c.loops {
+				close(loop.stop)
+				delete(c.loops, key)
+			}
+			for _, done := range c.workloadLoops {
+				<-done
+			}
+			return nil
+		case event := <-events:
+			c.reconcile(event)
+		}
+	}
+}
+
+// reconcile starts, restarts or stops the autoscaling loop of an AzpAgentPool
+func (c *Controller) reconcile(event kubernetes.AgentPoolEvent) {
+	if event.Err != nil {
+		logging.Logger.Errorf("Error watching AzpAgentPool resources: %s", event.Err.Error())
+		return
+	}
+
+	key := fmt.Sprintf("%s/%s", event.Pool.Namespace, event.Pool.Name)
+	loop, exists := c.loops[key]
+	if event.Deleted {
+		if exists {
+			logging.Logger.Infof("Stopping autoscaling azpagentpool/%s in namespace %s", event.Pool.Name, event.Pool.Namespace)
+			close(loop.stop)
+			delete(c.loops, key)
+			if !loop.rejected {
+				c.startRejected(loop.workload)
+				if loop.workload != nil && c.workloadOwner(key, loop.workload) == nil {
+					scaling.ForgetWorkloadRoute(loop.workload.Namespace, loop.workload.FriendlyName())
+				}
+			}
+		}
+		return
+	}
+
+	// Status updates don't change the generation
+	if exists && loop.pool.Generation == event.Pool.Generation {
+		return
+	}
+	if exists {
+		logging.Logger.Infof("Restarting autoscaling azpagentpool/%s in namespace %s", event.Pool.Name, event.Pool.Namespace)
+		close(loop.stop)
+		delete(c.loops, key)
+	} else {
+		logging.Logger.Infof("Starting autoscaling azpagentpool/%s in namespace %s", event.Pool.Name, event.Pool.Namespace)
+	}
+	c.start(key, event.Pool)
+	// The spec may reference another workload now
+	if exists && !loop.rejected {
+		c.startRejected(loop.workload)
+	}
+}
+
+// start starts the autoscaling loop of an AzpAgentPool, unless another AzpAgentPool autoscales its workload.
+// The loop waits for the previous loop of the workload to exit first.
+func (c *Controller) start(key string, pool *kubernetes.AzpAgentPool) {
+	loop := &agentPoolLoop{
+		pool: pool,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	c.loops[key] = loop
+	if poolArgs, err := pool.PoolArgs(c.args.PoolArgs()); err == nil {
+		loop.workload = &poolArgs.Kubernetes
+	}
+
+	var previous chan struct{}
+	if loop.workload != nil {
+		if owner := c.workloadOwner(key, loop.workload); owner != nil {
+			loop.rejected = true
+			err := fmt.Errorf("Error - %s is already autoscaled by azpagentpool/%s", loop.workload.FriendlyName(), owner.pool.Name)
+			logging.Logger.Errorf("Not autoscaling azpagentpool/%s in namespace %s: %s", pool.Name, pool.Namespace, err.Error())
+			c.updateStatus(pool, nil, 0, err)
+			return
+		}
+
+		workloadKey := fmt.Sprintf("%s/%s", loop.workload.Namespace, loop.workload.FriendlyName())
+		previous = c.workloadLoops[workloadKey]
+		if previous == nil {
+			// Queued jobs aren't routed between the workloads until the new workload has been autoscaled
+			scaling.ExpectWorkloadRoute(loop.workload.Namespace, loop.workload.FriendlyName(), c.args.PollInterval, time.Now())
+		}
+		c.workloadLoops[workloadKey] = loop.done
+	}
+
+	go func() {
+		defer close(loop.done)
+		if previous != nil {
+			select {
+			case <-previous:
+			case <-loop.stop:
+				return
+			}
+		}
+		c.autoscale(pool, loop.stop)
+	}()
+}
+
+// startRejected starts the autoscaling loop of an AzpAgentPool that was rejected because another
+// AzpAgentPool autoscaled the workload, once the workload is free
+func (c *Controller) startRejected(workload *args.KubernetesArgs) {
+	if workload == nil || c.workloadOwner("", workload) != nil {
+		return
+	}
+
+	var keys []string
+	for key, loop := range c.loops {
+		if loop.rejected && sameWorkload(loop.workload, workload) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	sort.Strings(keys)
+	loop := c.loops[keys[0]]
+	logging.Logger.Infof("Starting autoscaling azpagentpool/%s in namespace %s", loop.pool.Name, loop.pool.Namespace)
+	c.start(keys[0], loop.pool)
+}
+
+// workloadOwner returns the loop of another AzpAgentPool that autoscales the workload, or nil
+func (c *Controller) workloadOwner(key string, workload *args.KubernetesArgs) *agentPoolLoop {
+	for otherKey, loop := range c.loops {
+		if otherKey != key && !loop.rejected && sameWorkload(loop.workload, workload) {
+			return loop
+		}
+	}
+	return nil
+}
+
+// sameWorkload returns whether two AzpAgentPools reference the same workload
+func sameWorkload(a *args.KubernetesArgs, b *args.KubernetesArgs) bool {
+	return a != nil && b != nil && a.Namespace == b.Namespace && a.FriendlyName() == b.FriendlyName()
+}
+
+// autoscale runs the autoscaling loop of an AzpAgentPool until stop is closed
+func (c *Controller) autoscale(pool *kubernetes.AzpAgentPool, stop <-chan struct{}) {
+	poolArgs, err := pool.PoolArgs(c.args.PoolArgs())
+	if err == nil {
+		if validationErrors := args.ValidatePoolArgs(poolArgs); len(validationErrors) > 0 {
+			err = fmt.Errorf("Error(s) with the spec: %s", strings.Join(validationErrors, " "))
+		}
+	}
+	if err != nil {
+		// Wait for the spec to change before trying again
+		c.updateStatus(pool, nil, 0, err)
+		return
+	}
+	args := c.args.ForPool(poolArgs)
+
+	// The autoscaling is restarted after transient errors, such as an Azure Devops outage
+	for {
+		if !c.autoscaleWorkload(pool, args, stop) {
+			return
+		}
+		logging.Logger.Infof("Restarting autoscaling azpagentpool/%s in namespace %s after %s", pool.Name, pool.Namespace, args.Retry.MaxBackoff.String())
+		retry.Restarted(args.Kubernetes.Namespace, args.Kubernetes.FriendlyName())
+		select {
+		case <-stop:
+			return
+		case <-time.After(args.Retry.MaxBackoff):
+		}
+	}
+}
+
+// autoscaleWorkload runs the autoscaling loop of the workload of an AzpAgentPool until stop is closed.
+// It returns true if the autoscaling failed with a retryable error and should be restarted.
+func (c *Controller) autoscaleWorkload(pool *kubernetes.AzpAgentPool, args args.Args, stop <-chan struct{}) bool {
+	// The watches are stopped when this returns, so that restarting the autoscaling doesn't leak them
+	workloadStop := make(chan struct{})
+	defer close(workloadStop)
+
+	var workload *kubernetes.Workload
+	var refresher *scaling.WorkloadRefresher
+	agentPoolID := 0
+	retryPolicy := retry.MakePolicy(args.Rate, args.PollInterval, args.Retry, args.Kubernetes.Namespace, args.Kubernetes.FriendlyName())
+	for {
+		iterationStart := time.Now()
+		var err error
+		if refresher == nil {
+			workload, agentPoolID, err = c.resolve(args)
+			if err == nil {
+				refresher, err = scaling.MakeWorkloadRefresher(c.azdClient, c.k8sClient, args, workload, agentPoolID, workloadStop)
+			}
+		} else {
+			// Pick up changes to the workload spec and its agent pool
+			err = refresher.Refresh(iterationStart)
+			workload, agentPoolID = refresher.Workload, refresher.AgentPoolID
+		}
+		// Notifications received from now on start the next iteration early
+		notified := scaling.GetPoolTriggers().Triggered(agentPoolID)
+		if err == nil {
+			err = scaling.Autoscale(c.azdClient, agentPoolID, c.k8sClient, workload, args)
+		}
+
+		timeToSleep := args.PollInterval
+		if err != nil {
+			var retryErr error
+			timeToSleep, retryErr = retryPolicy.Failure(err)
+			if retryErr != nil {
+				logging.Logger.Errorf("Error autoscaling azpagentpool/%s in namespace %s: %s", pool.Name, pool.Namespace, retryErr.Error())
+				c.updateStatus(pool, workload, agentPoolID, retryErr)
+				// Restarting won't fix errors such as a missing workload or missing permissions, so wait for the spec to change
+				return retry.IsRetryable(retryErr)
+			}
+			logging.Logger.Warnf("Error autoscaling azpagentpool/%s in namespace %s, retrying after %s: %s", pool.Name, pool.Namespace, timeToSleep.String(), err.Error())
+		} else {
+			timeToSleep = retryPolicy.Success()
+		}
+		c.updateStatus(pool, workload, agentPoolID, err)
+
+		// Pod changes and notifications start the next iteration early, unless the last iteration failed and is being retried
+		var triggers <-chan struct{}
+		if refresher != nil && err == nil {
+			triggers = refresher.PodsChanged
+		} else {
+			notified = nil
+		}
+		if !scaling.WaitForNextIteration(stop, triggers, notified, iterationStart, timeToSleep, args.MinTriggerInterval) {
+			return false
+		}
+	}
+}
+
+// resolve retrieves the workload of an AzpAgentPool and the ID of its agent pool
+func (c *Controller) resolve(args args.Args) (*kubernetes.Workload, int, error) {
+	workloadChan := make(chan kubernetes.WorkloadReturn)
+	verifyHPAChan := make(chan error)
+	agentPoolsChan := make(chan azuredevops.PoolDetailsResponse)
+
+	go c.k8sClient.GetWorkloadAsync(workloadChan, args.Kubernetes)
+	go c.k8sClient.VerifyNoHorizontalPodAutoscalerAsync(verifyHPAChan, args.Kubernetes)
+	go c.azdClient.ListPoolsAsync(agentPoolsChan)
+
+	// Receive every result, so that the goroutines don't leak when resolving is retried
+	workload := <-workloadChan
+	verifyHPAErr := <-verifyHPAChan
+	agentPools := <-agentPoolsChan
+	if workload.Err != nil {
+		return nil, 0, fmt.Errorf("Error retrieving %s in namespace %s: %w", args.Kubernetes.FriendlyName(), args.Kubernetes.Namespace, workload.Err)
+	}
+	if verifyHPAErr != nil {
+		return nil, 0, verifyHPAErr
+	}
+	if agentPools.Err != nil {
+		return nil, 0, fmt.Errorf("Error retrieving agent pools: %w", agentPools.Err)
+	}
+
+	agentPoolID, _, err := scaling.GetAgentPoolID(c.k8sClient, agentPools.Pools, workload.Resource, args.PoolName)
+	if err != nil {
+		return nil, 0, err
+	}
+	return workload.Resource, agentPoolID, nil
+}
+
+// updateStatus writes the state observed by the last scaling iteration to the AzpAgentPool status
+func (c *Controller) updateStatus(pool *kubernetes.AzpAgentPool, workload *kubernetes.Workload, agentPoolID int, err error) {
+	// The live autoscaler owns the status
+	if c.args.DryRun {
+		return
+	}
+
+	now := metav1.Now()
+	status := kubernetes.AzpAgentPoolStatus{
+		ObservedGeneration: pool.Generation,
+		PoolID:             agentPoolID,
+		LastUpdateTime:     &now,
+	}
+	if workload != nil {
+		workloadStatus := scaling.GetWorkloadStatus(workload)
+		status.Agents = workloadStatus.TotalAgents
+		status.ActiveAgents = workloadStatus.ActiveAgents
+		status.QueuedJobs = workloadStatus.QueuedJobs
+		status.DesiredReplicas = workloadStatus.DesiredReplicas
+		if workloadStatus.LastScaleTime != nil {
+			lastScaleTime := metav1.NewTime(*workloadStatus.LastScaleTime)
+			status.LastScaleTime = &lastScaleTime
+		}
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	if updateErr := c.k8sClient.Sync().UpdateAgentPoolStatus(pool, status); updateErr != nil {
+		logging.Logger.Errorf("Error updating the status of azpagentpool/%s in namespace %s: %s", pool.Name, pool.Namespace, updateErr.Error())
+	}
+}
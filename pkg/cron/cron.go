@@ -0,0 +1,149 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// field is the allowed range of one field of a cron expression
+type field struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+var fields = []field{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}},
+	// 7 is also Sunday
+	{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}},
+}
+
+// Schedule is a parsed standard 5 field cron expression (minute, hour, day of month, month, day of week)
+type Schedule struct {
+	minutes     map[int]bool
+	hours       map[int]bool
+	daysOfMonth map[int]bool
+	months      map[int]bool
+	daysOfWeek  map[int]bool
+
+	// Whether the day of month or day of week fields are restricted (not *)
+	daysOfMonthRestricted bool
+	daysOfWeekRestricted  bool
+}
+
+// Parse parses a standard 5 field cron expression, ex: "* 8-17 * * mon-fri".
+// Fields support *, lists (1,2), ranges (1-5), steps (*/15, 0-30/5), and month and day of week names.
+func Parse(expression string) (*Schedule, error) {
+	parts := strings.Fields(expression)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("Error parsing cron expression '%s': expected %d fields, but found %d", expression, len(fields), len(parts))
+	}
+
+	values := make([]map[int]bool, len(fields))
+	for i, part := range parts {
+		value, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing cron expression '%s': %s", expression, err.Error())
+		}
+		values[i] = value
+	}
+
+	// Sunday is both 0 and 7
+	if values[4][7] {
+		values[4][0] = true
+	}
+
+	return &Schedule{
+		minutes:               values[0],
+		hours:                 values[1],
+		daysOfMonth:           values[2],
+		months:                values[3],
+		daysOfWeek:            values[4],
+		daysOfMonthRestricted: !strings.HasPrefix(parts[2], "*"),
+		daysOfWeekRestricted:  !strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+// Matches returns whether the minute of a time matches the schedule.
+// Like cron, if both the day of month and day of week are restricted, either can match.
+func (s *Schedule) Matches(t time.Time) bool {
+	if !s.minutes[t.Minute()] || !s.hours[t.Hour()] || !s.months[int(t.Month())] {
+		return false
+	}
+
+	dayOfMonthMatches := s.daysOfMonth[t.Day()]
+	dayOfWeekMatches := s.daysOfWeek[int(t.Weekday())]
+	if s.daysOfMonthRestricted && s.daysOfWeekRestricted {
+		return dayOfMonthMatches || dayOfWeekMatches
+	}
+	return dayOfMonthMatches && dayOfWeekMatches
+}
+
+// parseField parses one comma-separated field of a cron expression
+func parseField(expression string, f field) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, item := range strings.Split(expression, ",") {
+		step := 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(item[i+1:])
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step '%s' in the %s field", item[i+1:], f.name)
+			}
+			item = item[:i]
+		}
+
+		start, end := f.min, f.max
+		if item != "*" {
+			bounds := strings.SplitN(item, "-", 2)
+			var err error
+			start, err = parseValue(bounds[0], f)
+			if err != nil {
+				return nil, err
+			}
+			end = start
+			if len(bounds) == 2 {
+				end, err = parseValue(bounds[1], f)
+				if err != nil {
+					return nil, err
+				}
+			} else if step > 1 {
+				// ex: 5/15 means 5-max/15
+				end = f.max
+			}
+			if end < start {
+				return nil, fmt.Errorf("invalid range '%s' in the %s field", item, f.name)
+			}
+		}
+
+		for value := start; value <= end; value = value + step {
+			values[value] = true
+		}
+	}
+	return values, nil
+}
+
+// parseValue parses a number or a name in a field of a cron expression
+func parseValue(expression string, f field) (int, error) {
+	if value, exists := f.names[strings.ToLower(expression)]; exists {
+		return value, nil
+	}
+	value, err := strconv.Atoi(expression)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value '%s' in the %s field", expression, f.name)
+	}
+	if value < f.min || value > f.max {
+		return 0, fmt.Errorf("value %d is out of the range %d-%d of the %s field", value, f.min, f.max, f.name)
+	}
+	return value, nil
+}
@@ -0,0 +1,172 @@
+package forecast
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// SlotDuration is the length of the time slots that the demand is learned in
+const SlotDuration = 15 * time.Minute
+
+const (
+	slotsPerDay  = int64(24 * time.Hour / SlotDuration)
+	slotsPerWeek = 7 * slotsPerDay
+
+	// smoothing is the weight of the latest peak demand of a slot, versus the previous weeks or days
+	smoothing = 0.3
+)
+
+// Slot is the learned demand of a time slot
+type Slot struct {
+	Demand  float64 `json:"demand"`
+	Samples int     `json:"samples"`
+}
+
+// Model is the learned demand (active agents and queued jobs) of one agent pool workload.
+// The demand is learned for each slot of the week, and each slot of the day until a slot of the week has history.
+type Model struct {
+	Weekly []Slot `json:"weekly"`
+	Daily  []Slot `json:"daily"`
+
+	// The slot being observed, counted from the Unix epoch, and its peak demand so far
+	CurrentSlot int64 `json:"currentSlot"`
+	CurrentPeak int32 `json:"currentPeak"`
+}
+
+// NewModel returns a Model without history
+func NewModel() *Model {
+	return &Model{
+		Weekly: make([]Slot, slotsPerWeek),
+		Daily:  make([]Slot, slotsPerDay),
+	}
+}
+
+// slotNumber returns the slot of a time, counted from the Unix epoch
+func slotNumber(t time.Time) int64 {
+	return t.Unix() / int64(SlotDuration/time.Second)
+}
+
+// Observe records the demand at a time. Once a slot is over, its peak demand is learned.
+// Returns whether a slot was learned.
+func (m *Model) Observe(now time.Time, demand int32) bool {
+	slot := slotNumber(now)
+	if m.CurrentSlot == slot {
+		if demand > m.CurrentPeak {
+			m.CurrentPeak = demand
+		}
+		return false
+	}
+
+	learned := false
+	// Slots missed while the autoscaler wasn't running aren't learned
+	if m.CurrentSlot > 0 && m.CurrentSlot < slot {
+		learnSlot(&m.Weekly[m.CurrentSlot%slotsPerWeek], m.CurrentPeak)
+		learnSlot(&m.Daily[m.CurrentSlot%slotsPerDay], m.CurrentPeak)
+		learned = true
+	}
+	m.CurrentSlot = slot
+	m.CurrentPeak = demand
+	return learned
+}
+
+func learnSlot(slot *Slot, peak int32) {
+	if slot.Samples == 0 {
+		slot.Demand = float64(peak)
+	} else {
+		slot.Demand = smoothing*float64(peak) + (1-smoothing)*slot.Demand
+	}
+	slot.Samples = slot.Samples + 1
+}
+
+// Predict returns the learned demand at a time, or 0 if there is no history for it
+func (m *Model) Predict(t time.Time) float64 {
+	slot := slotNumber(t)
+	if weekly := m.Weekly[slot%slotsPerWeek]; weekly.Samples > 0 {
+		return weekly.Demand
+	}
+	if daily := m.Daily[slot%slotsPerDay]; daily.Samples > 0 {
+		return daily.Demand
+	}
+	return 0
+}
+
+// PredictPeak returns the highest learned demand between now and the lead time, rounded up
+func (m *Model) PredictPeak(now time.Time, leadTime time.Duration) int32 {
+	peak := m.Predict(now)
+	for t := now.Add(SlotDuration); !t.After(now.Add(leadTime)); t = t.Add(SlotDuration) {
+		peak = math.Max(peak, m.Predict(t))
+	}
+	if leadTime%SlotDuration != 0 {
+		peak = math.Max(peak, m.Predict(now.Add(leadTime)))
+	}
+	return int32(math.Ceil(peak))
+}
+
+// valid returns whether a Model loaded from a Store has the expected number of slots
+func (m *Model) valid() bool {
+	return int64(len(m.Weekly)) == slotsPerWeek && int64(len(m.Daily)) == slotsPerDay
+}
+
+// Prediction is the forecasted demand of a workload
+type Prediction struct {
+	// The learned demand of the current slot
+	Current float64
+	// The highest learned demand between now and the lead time
+	Peak int32
+}
+
+// Forecaster learns the demand of each agent pool workload, and persists it in a Store
+type Forecaster struct {
+	store    Store
+	leadTime time.Duration
+	models   map[string]*Model
+	lock     sync.Mutex
+}
+
+// MakeForecaster returns a Forecaster with the history loaded from the store
+func MakeForecaster(store Store, leadTime time.Duration) (*Forecaster, error) {
+	models, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	for key, model := range models {
+		if model == nil || !model.valid() {
+			delete(models, key)
+		}
+	}
+	return &Forecaster{
+		store:    store,
+		leadTime: leadTime,
+		models:   models,
+	}, nil
+}
+
+// Observe records the demand of a workload, and returns its forecasted demand.
+// The history is saved to the store each time a slot is learned.
+func (f *Forecaster) Observe(key string, now time.Time, demand int32) (Prediction, error) {
+	f.lock.Lock()
+	model, exists := f.models[key]
+	if !exists {
+		model = NewModel()
+		f.models[key] = model
+	}
+	learned := model.Observe(now, demand)
+	prediction := Prediction{
+		Current: model.Predict(now),
+		Peak:    model.PredictPeak(now, f.leadTime),
+	}
+	f.lock.Unlock()
+
+	if learned {
+		return prediction, f.Save()
+	}
+	return prediction, nil
+}
+
+// Save saves the history of every workload to the store
+func (f *Forecaster) Save() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.store.Save(f.models)
+}
@@ -0,0 +1,116 @@
+package forecast
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
+)
+
+// configMapKey is the key of the ConfigMap the history is saved in
+const configMapKey = "forecast.json"
+
+// Store persists the learned demand of each workload across restarts
+type Store interface {
+	Load() (map[string]*Model, error)
+	Save(models map[string]*Model) error
+}
+
+// FileStore saves the history in a local JSON file
+type FileStore struct {
+	Path string
+}
+
+// Load reads the history from the file. A missing file has no history.
+func (s FileStore) Load() (map[string]*Model, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return make(map[string]*Model), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Error reading forecast file %s: %s", s.Path, err.Error())
+	}
+	return unmarshalModels(data, s.Path)
+}
+
+// Save writes the history to a temporary file, then replaces the file, so that it is never partially written
+func (s FileStore) Save(models map[string]*Model) error {
+	data, err := json.Marshal(models)
+	if err != nil {
+		return err
+	}
+	tempFile, err := ioutil.TempFile(filepath.Dir(s.Path), filepath.Base(s.Path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("Error writing forecast file %s: %s", s.Path, err.Error())
+	}
+	defer os.Remove(tempFile.Name())
+	if _, err := tempFile.Write(data); err != nil {
+		tempFile.Close()
+		return fmt.Errorf("Error writing forecast file %s: %s", s.Path, err.Error())
+	}
+	if err := tempFile.Close(); err != nil {
+		return fmt.Errorf("Error writing forecast file %s: %s", s.Path, err.Error())
+	}
+	if err := os.Rename(tempFile.Name(), s.Path); err != nil {
+		return fmt.Errorf("Error writing forecast file %s: %s", s.Path, err.Error())
+	}
+	return nil
+}
+
+// ConfigMapStore saves the history in a ConfigMap, so that it is shared by every replica of the autoscaler
+type ConfigMapStore struct {
+	Client    kubernetes.Client
+	Namespace string
+	Name      string
+}
+
+// Load reads the history from the ConfigMap. A missing ConfigMap has no history.
+func (s ConfigMapStore) Load() (map[string]*Model, error) {
+	data, err := s.Client.GetConfigMapData(s.Namespace, s.Name)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading forecast ConfigMap %s/%s: %s", s.Namespace, s.Name, err.Error())
+	}
+	value, exists := data[configMapKey]
+	if !exists {
+		return make(map[string]*Model), nil
+	}
+	return unmarshalModels([]byte(value), fmt.Sprintf("ConfigMap %s/%s", s.Namespace, s.Name))
+}
+
+// Save creates or replaces the ConfigMap
+func (s ConfigMapStore) Save(models map[string]*Model) error {
+	data, err := json.Marshal(models)
+	if err != nil {
+		return err
+	}
+	if err := s.Client.SetConfigMapData(s.Namespace, s.Name, map[string]string{configMapKey: string(data)}); err != nil {
+		return fmt.Errorf("Error writing forecast ConfigMap %s/%s: %s", s.Namespace, s.Name, err.Error())
+	}
+	return nil
+}
+
+// ReadOnlyStore loads the history from another Store, but never saves it, ex. so that a dry run doesn't
+// overwrite the history of the live autoscaler
+type ReadOnlyStore struct {
+	Store Store
+}
+
+// Load reads the history from the other Store
+func (s ReadOnlyStore) Load() (map[string]*Model, error) {
+	return s.Store.Load()
+}
+
+// Save does nothing
+func (s ReadOnlyStore) Save(models map[string]*Model) error {
+	return nil
+}
+
+func unmarshalModels(data []byte, source string) (map[string]*Model, error) {
+	models := make(map[string]*Model)
+	if err := json.Unmarshal(data, &models); err != nil {
+		return nil, fmt.Errorf("Error parsing forecast %s: %s", source, err.Error())
+	}
+	return models, nil
+}
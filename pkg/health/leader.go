@@ -0,0 +1,40 @@
+package health
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/logging"
+)
+
+// LeaderCheck is an HTTP Handler that succeeds while the replica is the leader
+type LeaderCheck struct {
+	leader int32
+}
+
+// SetLeader sets whether the replica is the leader
+func (c *LeaderCheck) SetLeader(leader bool) {
+	if leader {
+		atomic.StoreInt32(&c.leader, 1)
+	} else {
+		atomic.StoreInt32(&c.leader, 0)
+	}
+}
+
+// IsLeader returns whether the replica is the leader
+func (c *LeaderCheck) IsLeader() bool {
+	return atomic.LoadInt32(&c.leader) == 1
+}
+
+func (c *LeaderCheck) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	logging.Logger.Trace("Leader check")
+
+	if !c.IsLeader() {
+		writer.WriteHeader(503)
+		writer.Write([]byte("Not the leader"))
+		return
+	}
+
+	writer.WriteHeader(200)
+	writer.Write([]byte("Leader"))
+}
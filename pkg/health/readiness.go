@@ -0,0 +1,46 @@
+package health
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/logging"
+)
+
+// ReadinessCheck is an HTTP Handler that succeeds once the replica has started.
+// With leader election, it only succeeds while the replica is the leader.
+type ReadinessCheck struct {
+	ready int32
+	// The leadership of the replica, if leader election is enabled
+	Leader *LeaderCheck
+}
+
+// SetReady sets whether readiness probes succeed
+func (c *ReadinessCheck) SetReady(ready bool) {
+	if ready {
+		atomic.StoreInt32(&c.ready, 1)
+	} else {
+		atomic.StoreInt32(&c.ready, 0)
+	}
+}
+
+// IsReady returns whether readiness probes succeed
+func (c *ReadinessCheck) IsReady() bool {
+	if c.Leader != nil && !c.Leader.IsLeader() {
+		return false
+	}
+	return atomic.LoadInt32(&c.ready) == 1
+}
+
+func (c *ReadinessCheck) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	logging.Logger.Trace("Readiness probe")
+
+	if !c.IsReady() {
+		writer.WriteHeader(503)
+		writer.Write([]byte("Not ready"))
+		return
+	}
+
+	writer.WriteHeader(200)
+	writer.Write([]byte("OK"))
+}
@@ -0,0 +1,237 @@
+package kubernetes
+
+import (
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+)
+
+// AgentPoolResource is the resource of the AzpAgentPool custom resource definition
+var AgentPoolResource = schema.GroupVersionResource{
+	Group:    "azp.ogmaresca.github.io",
+	Version:  "v1alpha1",
+	Resource: "azpagentpools",
+}
+
+// AzpAgentPool declares an agent pool workload to autoscale
+type AzpAgentPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AzpAgentPoolSpec   `json:"spec"`
+	Status AzpAgentPoolStatus `json:"status,omitempty"`
+}
+
+// AzpAgentPoolSpec is the spec of an AzpAgentPool. Optional fields default to the program arguments.
+type AzpAgentPoolSpec struct {
+	WorkloadRef AzpAgentPoolWorkloadRef `json:"workloadRef"`
+	Pool        string                  `json:"pool,omitempty"`
+	Min         *int32                  `json:"min,omitempty"`
+	Max         *int32                  `json:"max,omitempty"`
+	Mode        string                  `json:"mode,omitempty"`
+	ScaleDown   *AzpAgentPoolScaleDown  `json:"scaleDown,omitempty"`
+	Policy      *AzpAgentPoolPolicy     `json:"policy,omitempty"`
+	Behavior    *AzpAgentPoolBehavior   `json:"behavior,omitempty"`
+	Schedules   []AzpAgentPoolSchedule  `json:"schedules,omitempty"`
+}
+
+// AzpAgentPoolWorkloadRef references the agent workload, in the same namespace as the AzpAgentPool
+type AzpAgentPoolWorkloadRef struct {
+	Kind string `json:"kind,omitempty"`
+	Name string `json:"name"`
+}
+
+// AzpAgentPoolScaleDown is the scale down policy of an AzpAgentPool
+type AzpAgentPoolScaleDown struct {
+	Delay            string `json:"delay,omitempty"`
+	Max              *int32 `json:"max,omitempty"`
+	Strategy         string `json:"strategy,omitempty"`
+	ScaleToZeroDelay string `json:"scaleToZeroDelay,omitempty"`
+}
+
+// AzpAgentPoolPolicy is the scaling policy of an AzpAgentPool
+type AzpAgentPoolPolicy struct {
+	Type    string `json:"type,omitempty"`
+	Buffer  *int32 `json:"buffer,omitempty"`
+	Step    *int32 `json:"step,omitempty"`
+	Percent *int32 `json:"percent,omitempty"`
+	Floor   *int32 `json:"floor,omitempty"`
+	Ceiling *int32 `json:"ceiling,omitempty"`
+}
+
+// AzpAgentPoolBehavior is the scaling behavior of an AzpAgentPool in each direction
+type AzpAgentPoolBehavior struct {
+	ScaleUp   *AzpAgentPoolScalingRules `json:"scaleUp,omitempty"`
+	ScaleDown *AzpAgentPoolScalingRules `json:"scaleDown,omitempty"`
+}
+
+// AzpAgentPoolScalingRules are the scaling rules of an AzpAgentPool in one direction
+type AzpAgentPoolScalingRules struct {
+	StabilizationWindow string                       `json:"stabilizationWindow,omitempty"`
+	SelectPolicy        string                       `json:"selectPolicy,omitempty"`
+	Policies            []AzpAgentPoolBehaviorPolicy `json:"policies,omitempty"`
+}
+
+// AzpAgentPoolBehaviorPolicy limits the change of replicas of an AzpAgentPool over a period
+type AzpAgentPoolBehaviorPolicy struct {
+	Type   string `json:"type"`
+	Value  int32  `json:"value"`
+	Period string `json:"period"`
+}
+
+// AzpAgentPoolSchedule overrides the min and max of an AzpAgentPool while its cron expression matches
+type AzpAgentPoolSchedule struct {
+	Name     string `json:"name"`
+	Cron     string `json:"cron"`
+	Timezone string `json:"timezone,omitempty"`
+	Min      *int32 `json:"min,omitempty"`
+	Max      *int32 `json:"max,omitempty"`
+}
+
+// AzpAgentPoolStatus is the observed state of an AzpAgentPool
+type AzpAgentPoolStatus struct {
+	ObservedGeneration int64        `json:"observedGeneration,omitempty"`
+	PoolID             int          `json:"poolId,omitempty"`
+	Agents             int32        `json:"agents"`
+	ActiveAgents       int32        `json:"activeAgents"`
+	QueuedJobs         int32        `json:"queuedJobs"`
+	DesiredReplicas    int32        `json:"desiredReplicas"`
+	LastScaleTime      *metav1.Time `json:"lastScaleTime,omitempty"`
+	LastUpdateTime     *metav1.Time `json:"lastUpdateTime,omitempty"`
+	Error              string       `json:"error,omitempty"`
+}
+
+// AgentPoolEvent is sent when an AzpAgentPool is created, updated or deleted
+type AgentPoolEvent struct {
+	Pool    *AzpAgentPool
+	Deleted bool
+	Err     error
+}
+
+// PoolArgs returns the args of an AzpAgentPool, using the defaults for optional fields
+func (p *AzpAgentPool) PoolArgs(defaults args.PoolArgs) (args.PoolArgs, error) {
+	pool := defaults
+	pool.Kubernetes.Name = p.Spec.WorkloadRef.Name
+	pool.Kubernetes.Namespace = p.Namespace
+	if p.Spec.WorkloadRef.Kind != "" {
+		pool.Kubernetes.Type = p.Spec.WorkloadRef.Kind
+	}
+	if p.Spec.Pool != "" {
+		pool.PoolName = p.Spec.Pool
+	}
+	if p.Spec.Min != nil {
+		pool.Min = *p.Spec.Min
+	}
+	if p.Spec.Max != nil {
+		pool.Max = *p.Spec.Max
+	}
+	if p.Spec.Mode != "" {
+		pool.Mode = p.Spec.Mode
+	}
+	if p.Spec.ScaleDown != nil {
+		if p.Spec.ScaleDown.Delay != "" {
+			delay, err := time.ParseDuration(p.Spec.ScaleDown.Delay)
+			if err != nil {
+				return pool, fmt.Errorf("Error parsing scaleDown.delay: %s", err.Error())
+			}
+			pool.ScaleDown.Delay = delay
+		}
+		if p.Spec.ScaleDown.Max != nil {
+			pool.ScaleDown.Max = *p.Spec.ScaleDown.Max
+		}
+		if p.Spec.ScaleDown.Strategy != "" {
+			pool.ScaleDown.Strategy = p.Spec.ScaleDown.Strategy
+		}
+		if p.Spec.ScaleDown.ScaleToZeroDelay != "" {
+			scaleToZeroDelay, err := time.ParseDuration(p.Spec.ScaleDown.ScaleToZeroDelay)
+			if err != nil {
+				return pool, fmt.Errorf("Error parsing scaleDown.scaleToZeroDelay: %s", err.Error())
+			}
+			pool.ScaleDown.ToZeroDelay = scaleToZeroDelay
+		}
+	}
+	if p.Spec.Policy != nil {
+		if p.Spec.Policy.Type != "" {
+			pool.Policy.Type = p.Spec.Policy.Type
+		}
+		if p.Spec.Policy.Buffer != nil {
+			pool.Policy.Buffer = *p.Spec.Policy.Buffer
+		}
+		if p.Spec.Policy.Step != nil {
+			pool.Policy.Step = *p.Spec.Policy.Step
+		}
+		if p.Spec.Policy.Percent != nil {
+			pool.Policy.Percent = *p.Spec.Policy.Percent
+		}
+		if p.Spec.Policy.Floor != nil {
+			pool.Policy.Floor = *p.Spec.Policy.Floor
+		}
+		if p.Spec.Policy.Ceiling != nil {
+			pool.Policy.Ceiling = *p.Spec.Policy.Ceiling
+		}
+	}
+	if p.Spec.Behavior != nil {
+		var err error
+		if pool.Behavior.ScaleUp, err = p.Spec.Behavior.ScaleUp.rules(pool.Behavior.ScaleUp); err != nil {
+			return pool, fmt.Errorf("Error parsing behavior.scaleUp: %s", err.Error())
+		}
+		if pool.Behavior.ScaleDown, err = p.Spec.Behavior.ScaleDown.rules(pool.Behavior.ScaleDown); err != nil {
+			return pool, fmt.Errorf("Error parsing behavior.scaleDown: %s", err.Error())
+		}
+	}
+	if p.Spec.Schedules != nil {
+		pool.Schedules = nil
+		for _, schedule := range p.Spec.Schedules {
+			pool.Schedules = append(pool.Schedules, args.ScheduleArgs(schedule))
+		}
+	}
+	return pool, nil
+}
+
+// rules returns the scaling rules of one direction, using the defaults for optional fields
+func (r *AzpAgentPoolScalingRules) rules(defaults args.ScalingRulesArgs) (args.ScalingRulesArgs, error) {
+	rules := defaults
+	if r == nil {
+		return rules, nil
+	}
+	if r.StabilizationWindow != "" {
+		window, err := time.ParseDuration(r.StabilizationWindow)
+		if err != nil {
+			return rules, fmt.Errorf("Error parsing stabilizationWindow: %s", err.Error())
+		}
+		rules.StabilizationWindow = window
+	}
+	if r.SelectPolicy != "" {
+		rules.SelectPolicy = r.SelectPolicy
+	}
+	if r.Policies != nil {
+		rules.Policies = nil
+		for i, policy := range r.Policies {
+			period, err := time.ParseDuration(policy.Period)
+			if err != nil {
+				return rules, fmt.Errorf("Error parsing policies[%d].period: %s", i, err.Error())
+			}
+			rules.Policies = append(rules.Policies, args.BehaviorPolicyArgs{
+				Type:   policy.Type,
+				Value:  policy.Value,
+				Period: period,
+			})
+		}
+	}
+	return rules, nil
+}
+
+func agentPoolFromUnstructured(obj *unstructured.Unstructured) (*AzpAgentPool, error) {
+	pool := &AzpAgentPool{}
+	err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), pool)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing azpagentpool/%s in namespace %s: %s", obj.GetName(), obj.GetNamespace(), err.Error())
+	}
+	return pool, nil
+}
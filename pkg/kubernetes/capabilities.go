@@ -0,0 +1,40 @@
+package kubernetes
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/azuredevops"
+)
+
+// CapabilityLabelPrefix is the prefix of the pod template labels that declare agent capabilities, ex: capability.azp-agent-autoscaler/java: "11"
+const CapabilityLabelPrefix = "capability.azp-agent-autoscaler/"
+
+// GetCapabilities returns the capabilities agents created from a pod template will have.
+// Agents report their environment variables as capabilities, and labels can declare other capabilities.
+// Environment variables from ConfigMaps and Secrets exist, but their values aren't known.
+func GetCapabilities(podTemplate *corev1.PodTemplateSpec) azuredevops.Capabilities {
+	capabilities := make(azuredevops.Capabilities)
+	if podTemplate == nil {
+		return capabilities
+	}
+
+	for _, container := range podTemplate.Spec.Containers {
+		for _, env := range container.Env {
+			if env.ValueFrom != nil {
+				capabilities[env.Name] = nil
+			} else {
+				value := env.Value
+				capabilities[env.Name] = &value
+			}
+		}
+	}
+	for label, value := range podTemplate.Labels {
+		if strings.HasPrefix(label, CapabilityLabelPrefix) {
+			labelValue := value
+			capabilities[strings.TrimPrefix(label, CapabilityLabelPrefix)] = &labelValue
+		}
+	}
+	return capabilities
+}
@@ -2,18 +2,28 @@ package kubernetes
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
 
 	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
 	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	apimachinery "k8s.io/apimachinery/pkg/apis/meta/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	k8s "k8s.io/client-go/kubernetes"
 	k8srest "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	k8sclientcmd "k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 )
 
 // Client is a wrapper around the client-go package for Kubernetes
@@ -21,13 +31,29 @@ type Client interface {
 	GetWorkload(args args.KubernetesArgs) (*Workload, error)
 	VerifyNoHorizontalPodAutoscaler(args args.KubernetesArgs) error
 	Scale(resource *Workload, replicas int32) error
+	AnnotateWorkload(resource *Workload, annotations map[string]*string) error
 	GetEnvValue(podSpec corev1.PodSpec, namespace string, envName string) (string, error)
 	GetPods(workload *Workload) ([]corev1.Pod, error)
+	AnnotatePod(pod *corev1.Pod, annotations map[string]*string) error
+	DeletePod(pod *corev1.Pod) error
+	ListJobs(workload *Workload) ([]batchv1.Job, error)
+	CreateJob(job *batchv1.Job) error
+	DeleteJob(job *batchv1.Job) error
+	WatchAgentPools(namespace string, stopCh <-chan struct{}) (<-chan AgentPoolEvent, error)
+	UpdateAgentPoolStatus(pool *AzpAgentPool, status AzpAgentPoolStatus) error
+	LeaderElect(args args.LeaderElectionArgs, onStartedLeading func(stop <-chan struct{}), onStoppedLeading func()) error
+	GetConfigMapData(namespace string, name string) (map[string]string, error)
+	SetConfigMapData(namespace string, name string, data map[string]string) error
+	RecordEvent(resource *Workload, eventType string, reason string, message string)
+	WatchWorkload(workload *Workload, agentJobs bool, stopCh <-chan struct{}) (<-chan struct{}, error)
 }
 
 // ClientImpl is the interface implementation of Client
 type ClientImpl struct {
-	client *k8s.Clientset
+	client        k8s.Interface
+	dynamicClient dynamic.Interface
+	recorder      record.EventRecorder
+	watches       *watchCache
 }
 
 // makeClient returns a Client
@@ -52,13 +78,22 @@ func makeClient() (Client, error) {
 	if err != nil {
 		return nil, err
 	}
-	return ClientImpl{clientset}, nil
+	dynamicClient, err := dynamic.NewForConfig(k8sConfig)
+	if err != nil {
+		return nil, err
+	}
+	return ClientImpl{clientset, dynamicClient, makeEventRecorder(clientset), makeWatchCache()}, nil
 }
 
-// GetWorkload retrieves a Workload
+// GetWorkload retrieves a Workload, from the watch cache if it's watched
 func (c ClientImpl) GetWorkload(args args.KubernetesArgs) (*Workload, error) {
+	if workload, watched, err := c.watches.getWorkload(args.Type, args.Namespace, args.Name); watched {
+		return workload, err
+	}
 	if strings.EqualFold(args.Type, "StatefulSet") {
 		return c.getStatefulSet(args.Namespace, args.Name)
+	} else if strings.EqualFold(args.Type, "Deployment") {
+		return c.getDeployment(args.Namespace, args.Name)
 	} else {
 		return nil, fmt.Errorf("Resource kind %s is not implemented", args.Type)
 	}
@@ -75,6 +110,17 @@ func (c ClientImpl) getStatefulSet(namespace string, name string) (*Workload, er
 	}
 }
 
+func (c ClientImpl) getDeployment(namespace string, name string) (*Workload, error) {
+	deployment, err := c.client.AppsV1().Deployments(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	} else if deployment == nil {
+		return nil, fmt.Errorf("Could not find deployment/%s in namespace %s", name, namespace)
+	} else {
+		return GetWorkloadFromDeployment(deployment)
+	}
+}
+
 // VerifyNoHorizontalPodAutoscaler returns an error if the given resource has a HorizontalPodAutoscaler
 func (c ClientImpl) VerifyNoHorizontalPodAutoscaler(args args.KubernetesArgs) error {
 	hpas, err := c.client.AutoscalingV1().HorizontalPodAutoscalers(args.Namespace).List(metav1.ListOptions{})
@@ -103,6 +149,15 @@ func (c ClientImpl) Scale(resource *Workload, replicas int32) error {
 			scale, err := statefulsets.UpdateScale(resource.Name, scale)
 			return err
 		}
+	} else if strings.EqualFold(resource.Kind, "Deployment") {
+		deployments := c.client.AppsV1().Deployments(resource.Namespace)
+		getScaleFunc = func() (*autoscalingv1.Scale, error) {
+			return deployments.GetScale(resource.Name, metav1.GetOptions{})
+		}
+		doScaleFunc = func(scale *autoscalingv1.Scale) error {
+			scale, err := deployments.UpdateScale(resource.Name, scale)
+			return err
+		}
 	} else {
 		return fmt.Errorf("Resource kind %s is not implemented", resource.Kind)
 	}
@@ -118,6 +173,26 @@ func (c ClientImpl) Scale(resource *Workload, replicas int32) error {
 	return doScaleFunc(scale)
 }
 
+// AnnotateWorkload sets annotations on a given Kubernetes resource. Annotations with a nil value are removed.
+func (c ClientImpl) AnnotateWorkload(resource *Workload, annotations map[string]*string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(resource.Kind, "StatefulSet") {
+		_, err = c.client.AppsV1().StatefulSets(resource.Namespace).Patch(resource.Name, types.MergePatchType, patch)
+	} else if strings.EqualFold(resource.Kind, "Deployment") {
+		_, err = c.client.AppsV1().Deployments(resource.Namespace).Patch(resource.Name, types.MergePatchType, patch)
+	} else {
+		err = fmt.Errorf("Resource kind %s is not implemented", resource.Kind)
+	}
+	return err
+}
+
 // GetEnvValue gets an environment variable value from a pod
 func (c ClientImpl) GetEnvValue(podSpec corev1.PodSpec, namespace string, envName string) (string, error) {
 	env := GetEnvVar(podSpec, envName)
@@ -161,8 +236,11 @@ func (c ClientImpl) GetEnvValue(podSpec corev1.PodSpec, namespace string, envNam
 	return "", fmt.Errorf("Error getting value for environment variable %s", env.Name)
 }
 
-// GetPods gets all pods attached to some workload
+// GetPods gets all pods attached to some workload, from the watch cache if they're watched
 func (c ClientImpl) GetPods(workload *Workload) ([]corev1.Pod, error) {
+	if pods, watched, err := c.watches.getPods(workload); watched {
+		return pods, err
+	}
 	listOptions := metav1.ListOptions{
 		LabelSelector: apimachinery.FormatLabelSelector(workload.PodSelector),
 	}
@@ -172,3 +250,136 @@ func (c ClientImpl) GetPods(workload *Workload) ([]corev1.Pod, error) {
 	}
 	return pods.Items, nil
 }
+
+// AnnotatePod sets annotations on a pod. Annotations with a nil value are removed.
+func (c ClientImpl) AnnotatePod(pod *corev1.Pod, annotations map[string]*string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.client.CoreV1().Pods(pod.Namespace).Patch(pod.Name, types.MergePatchType, patch)
+	return err
+}
+
+// DeletePod deletes a pod
+func (c ClientImpl) DeletePod(pod *corev1.Pod) error {
+	return c.client.CoreV1().Pods(pod.Namespace).Delete(pod.Name, &metav1.DeleteOptions{})
+}
+
+// ListJobs gets all Jobs created for some workload
+func (c ClientImpl) ListJobs(workload *Workload) ([]batchv1.Job, error) {
+	listOptions := metav1.ListOptions{
+		LabelSelector: apimachinery.FormatLabelSelector(AgentJobSelector(workload)),
+	}
+	jobs, err := c.client.BatchV1().Jobs(workload.Namespace).List(listOptions)
+	if err != nil {
+		return nil, err
+	}
+	return jobs.Items, nil
+}
+
+// CreateJob creates a Job
+func (c ClientImpl) CreateJob(job *batchv1.Job) error {
+	_, err := c.client.BatchV1().Jobs(job.Namespace).Create(job)
+	return err
+}
+
+// DeleteJob deletes a Job and its pods
+func (c ClientImpl) DeleteJob(job *batchv1.Job) error {
+	propagationPolicy := metav1.DeletePropagationBackground
+	return c.client.BatchV1().Jobs(job.Namespace).Delete(job.Name, &metav1.DeleteOptions{
+		PropagationPolicy: &propagationPolicy,
+	})
+}
+
+// WatchAgentPools sends an event each time an AzpAgentPool is created, updated or deleted, until stopCh is closed.
+// An empty namespace watches all namespaces.
+func (c ClientImpl) WatchAgentPools(namespace string, stopCh <-chan struct{}) (<-chan AgentPoolEvent, error) {
+	events := make(chan AgentPoolEvent)
+	sendEvent := func(obj interface{}, deleted bool) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		unstructuredPool, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return
+		}
+		pool, err := agentPoolFromUnstructured(unstructuredPool)
+		select {
+		case events <- AgentPoolEvent{pool, deleted, err}:
+		case <-stopCh:
+		}
+	}
+
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.dynamicClient, 0, namespace, nil)
+	informer := factory.ForResource(AgentPoolResource).Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			sendEvent(obj, false)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			sendEvent(newObj, false)
+		},
+		DeleteFunc: func(obj interface{}) {
+			sendEvent(obj, true)
+		},
+	})
+	factory.Start(stopCh)
+	return events, nil
+}
+
+// UpdateAgentPoolStatus replaces the status of an AzpAgentPool
+func (c ClientImpl) UpdateAgentPoolStatus(pool *AzpAgentPool, status AzpAgentPoolStatus) error {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return err
+	}
+	// A merge patch only removes the fields set to null, so clear the error of a previous iteration
+	if status.Error == "" {
+		content["error"] = nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": content,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.dynamicClient.Resource(AgentPoolResource).Namespace(pool.Namespace).Patch(pool.Name, types.MergePatchType, patch, metav1.PatchOptions{}, "status")
+	return err
+}
+
+// GetConfigMapData retrieves the data of a ConfigMap. A missing ConfigMap has no data.
+func (c ClientImpl) GetConfigMapData(namespace string, name string) (map[string]string, error) {
+	configMap, err := c.client.CoreV1().ConfigMaps(namespace).Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return configMap.Data, nil
+}
+
+// SetConfigMapData creates a ConfigMap, or replaces its data if it exists
+func (c ClientImpl) SetConfigMapData(namespace string, name string, data map[string]string) error {
+	configMaps := c.client.CoreV1().ConfigMaps(namespace)
+	configMap, err := configMaps.Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+			},
+			Data: data,
+		})
+		return err
+	} else if err != nil {
+		return err
+	}
+	configMap.Data = data
+	_, err = configMaps.Update(configMap)
+	return err
+}
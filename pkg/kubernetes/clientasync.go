@@ -3,7 +3,10 @@ package kubernetes
 import (
 	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
 
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
+	k8s "k8s.io/client-go/kubernetes"
 )
 
 // WorkloadReturn is used to fix the issue with channels not supporting pair return values
@@ -19,8 +22,15 @@ type ClientAsync interface {
 	GetWorkloadAsync(channel chan<- WorkloadReturn, args args.KubernetesArgs)
 	VerifyNoHorizontalPodAutoscalerAsync(channel chan<- error, args args.KubernetesArgs)
 	ScaleAsync(channel chan<- error, resource *Workload, replicas int32)
+	AnnotateWorkloadAsync(channel chan<- error, resource *Workload, annotations map[string]*string)
 	GetEnvValueAsync(channel chan<- EnvValueReturn, podSpec corev1.PodSpec, namespace string, envName string)
 	GetPodsAsync(channel chan<- Pods, workload *Workload)
+	AnnotatePodAsync(channel chan<- error, pod *corev1.Pod, annotations map[string]*string)
+	DeletePodAsync(channel chan<- error, pod *corev1.Pod)
+	ListJobsAsync(channel chan<- Jobs, workload *Workload)
+	CreateJobAsync(channel chan<- error, job *batchv1.Job)
+	DeleteJobAsync(channel chan<- error, job *batchv1.Job)
+	UpdateAgentPoolStatusAsync(channel chan<- error, pool *AzpAgentPool, status AzpAgentPoolStatus)
 }
 
 // ClientAsyncImpl is the interface implementation of ClientAsync
@@ -42,6 +52,11 @@ func MakeFromClient(syncClient Client) ClientAsync {
 	return ClientAsyncImpl{syncClient}
 }
 
+// MakeFromClientsets returns a ClientAsync using the given clientsets, ex. the fake clientsets in tests
+func MakeFromClientsets(clientset k8s.Interface, dynamicClient dynamic.Interface) ClientAsync {
+	return ClientAsyncImpl{ClientImpl{clientset, dynamicClient, makeEventRecorder(clientset), makeWatchCache()}}
+}
+
 // Sync returns the synchronous client
 func (c ClientAsyncImpl) Sync() Client {
 	return c.syncClient
@@ -63,6 +78,11 @@ func (c ClientAsyncImpl) ScaleAsync(channel chan<- error, resource *Workload, re
 	channel <- c.syncClient.Scale(resource, replicas)
 }
 
+// AnnotateWorkloadAsync sets annotations on a given Kubernetes resource. Annotations with a nil value are removed.
+func (c ClientAsyncImpl) AnnotateWorkloadAsync(channel chan<- error, resource *Workload, annotations map[string]*string) {
+	channel <- c.syncClient.AnnotateWorkload(resource, annotations)
+}
+
 // EnvValueReturn is a wrapper around string to allow returning multiple values in a channel
 type EnvValueReturn struct {
 	Value string
@@ -86,3 +106,40 @@ func (c ClientAsyncImpl) GetPodsAsync(channel chan<- Pods, workload *Workload) {
 	value, err := c.syncClient.GetPods(workload)
 	channel <- Pods{value, err}
 }
+
+// AnnotatePodAsync sets annotations on a pod. Annotations with a nil value are removed.
+func (c ClientAsyncImpl) AnnotatePodAsync(channel chan<- error, pod *corev1.Pod, annotations map[string]*string) {
+	channel <- c.syncClient.AnnotatePod(pod, annotations)
+}
+
+// DeletePodAsync deletes a pod
+func (c ClientAsyncImpl) DeletePodAsync(channel chan<- error, pod *corev1.Pod) {
+	channel <- c.syncClient.DeletePod(pod)
+}
+
+// Jobs is a wrapper around []batchv1.Job to allow returning multiple values in a channel
+type Jobs struct {
+	Jobs []batchv1.Job
+	Err  error
+}
+
+// ListJobsAsync gets all Jobs created for some workload
+func (c ClientAsyncImpl) ListJobsAsync(channel chan<- Jobs, workload *Workload) {
+	value, err := c.syncClient.ListJobs(workload)
+	channel <- Jobs{value, err}
+}
+
+// CreateJobAsync creates a Job
+func (c ClientAsyncImpl) CreateJobAsync(channel chan<- error, job *batchv1.Job) {
+	channel <- c.syncClient.CreateJob(job)
+}
+
+// DeleteJobAsync deletes a Job and its pods
+func (c ClientAsyncImpl) DeleteJobAsync(channel chan<- error, job *batchv1.Job) {
+	channel <- c.syncClient.DeleteJob(job)
+}
+
+// UpdateAgentPoolStatusAsync replaces the status of an AzpAgentPool
+func (c ClientAsyncImpl) UpdateAgentPoolStatusAsync(channel chan<- error, pool *AzpAgentPool, status AzpAgentPoolStatus) {
+	channel <- c.syncClient.UpdateAgentPoolStatus(pool, status)
+}
@@ -0,0 +1,49 @@
+package kubernetes
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	k8s "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/logging"
+)
+
+// eventComponent is the source of the Events recorded by azp-agent-autoscaler
+const eventComponent = "azp-agent-autoscaler"
+
+// The reasons of the Events recorded on the agent workloads
+const (
+	EventReasonScaledUp           = "ScaledUp"
+	EventReasonScaledDown         = "ScaledDown"
+	EventReasonFailedScale        = "FailedScale"
+	EventReasonCreatedAgentJobs   = "CreatedAgentJobs"
+	EventReasonFailedCreateJobs   = "FailedCreateAgentJobs"
+	EventReasonScaleDownDelayed   = "ScaleDownDelayed"
+	EventReasonScaleDownBlocked   = "ScaleDownBlocked"
+	EventReasonPendingPods        = "PendingPods"
+	EventReasonUnschedulablePods  = "UnschedulablePods"
+	EventReasonFailedGetAgentPool = "FailedGetAgentPool"
+	EventReasonAgentPoolChanged   = "AgentPoolChanged"
+	EventReasonDryRun             = "DryRun"
+)
+
+// makeEventRecorder returns an EventRecorder that writes Events to the API server
+func makeEventRecorder(clientset k8s.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(logging.Logger.Tracef)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: eventComponent})
+}
+
+// RecordEvent records an Event on a given Kubernetes resource. Events are sent in the background.
+func (c ClientImpl) RecordEvent(resource *Workload, eventType string, reason string, message string) {
+	c.recorder.Event(&corev1.ObjectReference{
+		APIVersion: resource.APIVersion,
+		Kind:       resource.Kind,
+		Name:       resource.Name,
+		Namespace:  resource.Namespace,
+		UID:        resource.UID,
+	}, eventType, reason, message)
+}
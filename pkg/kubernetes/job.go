@@ -0,0 +1,101 @@
+package kubernetes
+
+import (
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AgentJobLabel is the label added to Jobs (and their pods) created for a workload, with the workload name as the value
+const AgentJobLabel = "azp-agent-autoscaler/job-owner"
+
+// agentPoolEnvVar is used to find the agent container in a pod template
+const agentPoolEnvVar = "AZP_POOL"
+
+// AgentJobSelector returns the label selector matching the Jobs (and their pods) created for a workload
+func AgentJobSelector(workload *Workload) *metav1.LabelSelector {
+	return &metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			AgentJobLabel: workload.Name,
+		},
+	}
+}
+
+// MakeAgentJob creates a Job that runs a single agent from the workload's pod template.
+// The agent container is given the --once argument, so the agent exits after running one Azure Pipelines job.
+// The pods keep the template labels, such as the ones used by admission webhooks and NetworkPolicies, except the
+// labels of the workload's selector, so that they aren't counted as pods of the workload. They have the AgentJobLabel instead.
+func MakeAgentJob(workload *Workload) *batchv1.Job {
+	template := workload.PodTemplateSpec.DeepCopy()
+	if template.Labels == nil {
+		template.Labels = make(map[string]string)
+	}
+	if workload.PodSelector != nil {
+		for key := range workload.PodSelector.MatchLabels {
+			delete(template.Labels, key)
+		}
+		for _, expression := range workload.PodSelector.MatchExpressions {
+			delete(template.Labels, expression.Key)
+		}
+	}
+	template.Labels[AgentJobLabel] = workload.Name
+	template.Spec.RestartPolicy = corev1.RestartPolicyNever
+
+	agentContainer := 0
+	for i, container := range template.Spec.Containers {
+		if GetEnvVar(corev1.PodSpec{Containers: []corev1.Container{container}}, agentPoolEnvVar) != nil {
+			agentContainer = i
+			break
+		}
+	}
+	if len(template.Spec.Containers) > agentContainer {
+		hasOnceArg := false
+		for _, arg := range template.Spec.Containers[agentContainer].Args {
+			if arg == "--once" {
+				hasOnceArg = true
+			}
+		}
+		if !hasOnceArg {
+			template.Spec.Containers[agentContainer].Args = append(template.Spec.Containers[agentContainer].Args, "--once")
+		}
+	}
+
+	backoffLimit := int32(0)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: workload.Name + "-",
+			Namespace:    workload.Namespace,
+			Labels: map[string]string{
+				AgentJobLabel: workload.Name,
+			},
+		},
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Job",
+			APIVersion: "batch/v1",
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template:     *template,
+		},
+	}
+}
+
+// IsJobFinished returns whether a Job has completed or failed
+func IsJobFinished(job batchv1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if (condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// IsJobFailed returns whether a Job has failed
+func IsJobFailed(job batchv1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/logging"
+)
+
+var (
+	leaderGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "azp_agent_autoscaler_leader",
+		Help: "Whether this replica is the leader (1) or not (0)",
+	})
+	leaderTransitionsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "azp_agent_autoscaler_leader_transitions_count",
+		Help: "The total number of leadership changes observed by this replica",
+	})
+)
+
+// LeaderLabel is set to true on the pod of the leader, so that a Service can only select the leader
+const LeaderLabel = "azp-agent-autoscaler/leader"
+
+// LeaderElect blocks until leadership of the Lease is acquired, then calls onStartedLeading.
+// onStartedLeading's channel is closed, and onStoppedLeading is called, when leadership is lost.
+// LeaderElect returns once leadership is lost and onStartedLeading has returned.
+func (c ClientImpl) LeaderElect(args args.LeaderElectionArgs, onStartedLeading func(stop <-chan struct{}), onStoppedLeading func()) error {
+	identity, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("Error retrieving the leader election identity: %s", err.Error())
+	}
+
+	// The label may be left over from before a restart
+	if err := c.setLeaderLabel(args, false); err != nil {
+		return err
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      args.Name,
+			Namespace: args.Namespace,
+		},
+		Client: c.client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	started := make(chan struct{})
+	finished := make(chan struct{})
+	leaderElector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: args.LeaseDuration,
+		RenewDeadline: args.RenewDeadline,
+		RetryPeriod:   args.RetryPeriod,
+		Name:          args.Name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				logging.Logger.Infof("%s acquired lease %s in namespace %s", identity, args.Name, args.Namespace)
+				close(started)
+				defer close(finished)
+				leaderGauge.Set(1)
+				if err := c.setLeaderLabel(args, true); err != nil {
+					logging.Logger.Errorf("Error labeling the leader pod: %s", err.Error())
+				}
+				onStartedLeading(ctx.Done())
+			},
+			OnStoppedLeading: func() {
+				logging.Logger.Warnf("%s lost lease %s in namespace %s", identity, args.Name, args.Namespace)
+				leaderGauge.Set(0)
+				if err := c.setLeaderLabel(args, false); err != nil {
+					logging.Logger.Errorf("Error labeling the leader pod: %s", err.Error())
+				}
+				onStoppedLeading()
+			},
+			OnNewLeader: func(leader string) {
+				logging.Logger.Infof("The leader of lease %s in namespace %s is %s", args.Name, args.Namespace, leader)
+				leaderTransitionsCounter.Inc()
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Error initializing leader election: %s", err.Error())
+	}
+
+	leaderElector.Run(context.Background())
+
+	// Wait for the former leader to stop, ex. in the middle of scaling
+	select {
+	case <-started:
+		<-finished
+	default:
+	}
+	return nil
+}
+
+// setLeaderLabel sets the LeaderLabel of this pod, if its name is known
+func (c ClientImpl) setLeaderLabel(args args.LeaderElectionArgs, leader bool) error {
+	if args.PodName == "" {
+		return nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]string{
+				LeaderLabel: fmt.Sprintf("%t", leader),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := c.client.CoreV1().Pods(args.Namespace).Patch(args.PodName, types.MergePatchType, patch); err != nil {
+		return fmt.Errorf("Error labeling pod %s in namespace %s: %s", args.PodName, args.Namespace, err.Error())
+	}
+	return nil
+}
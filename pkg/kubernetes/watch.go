@@ -0,0 +1,252 @@
+package kubernetes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/logging"
+)
+
+// triggerDelay is how long pod changes are collected before triggering an autoscaling iteration,
+// so that the pods created by a scale up only trigger one iteration
+const triggerDelay = 1 * time.Second
+
+// watchCache holds the informer caches of the watched workloads and pods
+type watchCache struct {
+	workloads map[string]*watchedWorkload
+	pods      map[string]*watchedWorkload
+	lock      sync.RWMutex
+}
+
+// watchedWorkload reads a watched workload and its pods from the informer caches
+type watchedWorkload struct {
+	getWorkload func() (*Workload, error)
+	getPods     func() ([]corev1.Pod, error)
+}
+
+func makeWatchCache() *watchCache {
+	return &watchCache{
+		workloads: make(map[string]*watchedWorkload),
+		pods:      make(map[string]*watchedWorkload),
+	}
+}
+
+// workloadKey is the key of a workload in the watch cache
+func workloadKey(kind string, namespace string, name string) string {
+	return fmt.Sprintf("%s/%s/%s", strings.ToLower(kind), namespace, name)
+}
+
+// podsKey is the key of the pods of a label selector in the watch cache
+func podsKey(namespace string, selector *metav1.LabelSelector) string {
+	return fmt.Sprintf("%s/%s", namespace, metav1.FormatLabelSelector(selector))
+}
+
+// getWorkload returns a workload from the cache, and whether it's watched
+func (w *watchCache) getWorkload(kind string, namespace string, name string) (*Workload, bool, error) {
+	w.lock.RLock()
+	watched, exists := w.workloads[workloadKey(kind, namespace, name)]
+	w.lock.RUnlock()
+	if !exists {
+		return nil, false, nil
+	}
+	workload, err := watched.getWorkload()
+	return workload, true, err
+}
+
+// getPods returns the pods of a workload from the cache, and whether they're watched
+func (w *watchCache) getPods(workload *Workload) ([]corev1.Pod, bool, error) {
+	w.lock.RLock()
+	watched, exists := w.pods[podsKey(workload.Namespace, workload.PodSelector)]
+	w.lock.RUnlock()
+	if !exists {
+		return nil, false, nil
+	}
+	pods, err := watched.getPods()
+	return pods, true, err
+}
+
+// WatchWorkload caches the workload and its pods with informers until stopCh is closed,
+// so that GetWorkload and GetPods read the cache instead of calling the API server.
+// With agentJobs, the pods of the workload's agent Jobs are watched instead of the pods of the workload.
+// The returned channel receives when pods are added, removed or change phase, or when the pod template or selector of the workload changes.
+func (c ClientImpl) WatchWorkload(workload *Workload, agentJobs bool, stopCh <-chan struct{}) (<-chan struct{}, error) {
+	changes := make(chan struct{}, 1)
+	trigger := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+
+	podSelector := workload.PodSelector
+	if agentJobs {
+		podSelector = AgentJobSelector(workload)
+	}
+	podFactory := informers.NewSharedInformerFactoryWithOptions(c.client, 0, informers.WithNamespace(workload.Namespace), informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+		options.LabelSelector = metav1.FormatLabelSelector(podSelector)
+	}))
+	podInformer := podFactory.Core().V1().Pods()
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			notify()
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldPod, oldOk := oldObj.(*corev1.Pod)
+			newPod, newOk := newObj.(*corev1.Pod)
+			if oldOk && newOk && podState(oldPod) != podState(newPod) {
+				notify()
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			notify()
+		},
+	})
+
+	workloadFactory := informers.NewSharedInformerFactoryWithOptions(c.client, 0, informers.WithNamespace(workload.Namespace), informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+		options.FieldSelector = fields.OneTermEqualSelector("metadata.name", workload.Name).String()
+	}))
+	var workloadInformer cache.SharedIndexInformer
+	var getWorkload func() (*Workload, error)
+	if strings.EqualFold(workload.Kind, "StatefulSet") {
+		statefulSets := workloadFactory.Apps().V1().StatefulSets()
+		workloadInformer = statefulSets.Informer()
+		getWorkload = func() (*Workload, error) {
+			statefulSet, err := statefulSets.Lister().StatefulSets(workload.Namespace).Get(workload.Name)
+			if err != nil {
+				return nil, err
+			}
+			return GetWorkload(statefulSet)
+		}
+	} else if strings.EqualFold(workload.Kind, "Deployment") {
+		deployments := workloadFactory.Apps().V1().Deployments()
+		workloadInformer = deployments.Informer()
+		getWorkload = func() (*Workload, error) {
+			deployment, err := deployments.Lister().Deployments(workload.Namespace).Get(workload.Name)
+			if err != nil {
+				return nil, err
+			}
+			return GetWorkloadFromDeployment(deployment)
+		}
+	} else {
+		return nil, fmt.Errorf("Resource kind %s is not implemented", workload.Kind)
+	}
+	workloadInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			// Scaling the workload changes its generation, but not its spec hash
+			if workloadSpecHash(oldObj) != workloadSpecHash(newObj) {
+				notify()
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			notify()
+		},
+	})
+
+	podFactory.Start(stopCh)
+	workloadFactory.Start(stopCh)
+	for informerType, synced := range podFactory.WaitForCacheSync(stopCh) {
+		if !synced {
+			return nil, fmt.Errorf("Error syncing the %s cache of %s", informerType.String(), workload.FriendlyName)
+		}
+	}
+	for informerType, synced := range workloadFactory.WaitForCacheSync(stopCh) {
+		if !synced {
+			return nil, fmt.Errorf("Error syncing the %s cache of %s", informerType.String(), workload.FriendlyName)
+		}
+	}
+
+	watched := &watchedWorkload{getWorkload: getWorkload}
+	watched.getPods = func() ([]corev1.Pod, error) {
+		cachedPods, err := podInformer.Lister().Pods(workload.Namespace).List(labels.Everything())
+		if err != nil {
+			return nil, err
+		}
+		pods := make([]corev1.Pod, len(cachedPods))
+		for i, pod := range cachedPods {
+			pods[i] = *pod.DeepCopy()
+		}
+		// The API server lists pods by name
+		sort.Slice(pods, func(i, j int) bool {
+			return pods[i].Name < pods[j].Name
+		})
+		return pods, nil
+	}
+	workloadCacheKey := workloadKey(workload.Kind, workload.Namespace, workload.Name)
+	podsCacheKey := podsKey(workload.Namespace, podSelector)
+	c.watches.lock.Lock()
+	c.watches.workloads[workloadCacheKey] = watched
+	c.watches.pods[podsCacheKey] = watched
+	c.watches.lock.Unlock()
+	logging.Logger.Debugf("Watching %s and its pods", workload.FriendlyName)
+
+	go func() {
+		// A newer watch of the same workload may have replaced this one
+		defer func() {
+			c.watches.lock.Lock()
+			if c.watches.workloads[workloadCacheKey] == watched {
+				delete(c.watches.workloads, workloadCacheKey)
+			}
+			if c.watches.pods[podsCacheKey] == watched {
+				delete(c.watches.pods, podsCacheKey)
+			}
+			c.watches.lock.Unlock()
+		}()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-changes:
+			}
+			select {
+			case <-stopCh:
+				return
+			case <-time.After(triggerDelay):
+			}
+			select {
+			case trigger <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return trigger, nil
+}
+
+// podState returns the parts of a pod's status that change the scaling decisions
+func podState(pod *corev1.Pod) string {
+	allContainersRunning := true
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		if containerStatus.State.Running == nil || containerStatus.State.Terminated != nil {
+			allContainersRunning = false
+		}
+	}
+	unschedulable := false
+	for _, podCondition := range pod.Status.Conditions {
+		if podCondition.Type == corev1.PodScheduled && podCondition.Status == corev1.ConditionFalse {
+			unschedulable = true
+		}
+	}
+	return fmt.Sprintf("%s/%t/%t", pod.Status.Phase, allContainersRunning, unschedulable)
+}
+
+// workloadSpecHash returns the hash of the pod template and the pod selector of a StatefulSet or Deployment
+func workloadSpecHash(obj interface{}) string {
+	switch workload := obj.(type) {
+	case *appsv1.StatefulSet:
+		return specHash(&workload.Spec.Template, workload.Spec.Selector)
+	case *appsv1.Deployment:
+		return specHash(&workload.Spec.Template, workload.Spec.Selector)
+	}
+	return ""
+}
@@ -1,7 +1,9 @@
 package kubernetes
 
 import (
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"strings"
 
 	appsv1 "k8s.io/api/apps/v1"
@@ -44,3 +46,35 @@ func GetWorkload(resource *appsv1.StatefulSet) (*Workload, error) {
 
 	return &copy, err
 }
+
+// GetWorkloadFromDeployment creates a KubernetesWorkload from a Deployment
+func GetWorkloadFromDeployment(resource *appsv1.Deployment) (*Workload, error) {
+	copy := Workload{}
+	err := copier.Copy(&copy, resource)
+
+	// TypeMeta fields don't seem to always be populated
+	copy.Kind = "Deployment"
+	copy.APIVersion = "apps/v1"
+
+	copy.FriendlyName = fmt.Sprintf("%s/%s", strings.ToLower(copy.Kind), copy.Name)
+
+	copy.PodSelector = resource.Spec.Selector
+
+	copy.PodTemplateSpec = &resource.Spec.Template
+
+	return &copy, err
+}
+
+// SpecHash returns a hash of the pod template and the pod selector of the workload.
+// Unlike the generation, it doesn't change when the workload is scaled.
+func (w *Workload) SpecHash() string {
+	return specHash(w.PodTemplateSpec, w.PodSelector)
+}
+
+func specHash(template *corev1.PodTemplateSpec, selector *metav1.LabelSelector) string {
+	templateJSON, _ := json.Marshal(template)
+	hash := fnv.New64a()
+	hash.Write(templateJSON)
+	hash.Write([]byte(metav1.FormatLabelSelector(selector)))
+	return fmt.Sprintf("%x", hash.Sum64())
+}
@@ -0,0 +1,165 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/azuredevops"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/math"
+)
+
+// jitter is the fraction of a backoff that is randomly added or removed, so that pools don't retry in lockstep
+const jitter = 0.2
+
+var (
+	failedIterationsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "azp_agent_autoscaler_failed_iterations_count",
+		Help: "The total number of failed autoscaling iterations",
+	}, []string{"namespace", "workload", "retryable"})
+	consecutiveFailuresGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "azp_agent_autoscaler_consecutive_failures",
+		Help: "The number of autoscaling iterations that failed in a row",
+	}, []string{"namespace", "workload"})
+	restartsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "azp_agent_autoscaler_restarts_count",
+		Help: "The total number of times the autoscaling of a workload was restarted after an error",
+	}, []string{"namespace", "workload"})
+)
+
+// Restarted records that the autoscaling of a workload was restarted after an error
+func Restarted(namespace string, workload string) {
+	restartsCounter.With(prometheus.Labels{"namespace": namespace, "workload": workload}).Inc()
+}
+
+// Policy decides how long to wait between autoscaling iterations, and when to give up after failures
+type Policy struct {
+	rate                time.Duration
+	pollInterval        time.Duration
+	maxBackoff          time.Duration
+	failureThreshold    int
+	consecutiveFailures int
+	labels              prometheus.Labels
+}
+
+// MakePolicy returns a Policy for the autoscaling loop of a workload.
+// Backoffs start at the rate, and successful iterations are followed by the poll interval.
+func MakePolicy(rate time.Duration, pollInterval time.Duration, args args.RetryArgs, namespace string, workload string) *Policy {
+	return &Policy{
+		rate:             rate,
+		pollInterval:     pollInterval,
+		maxBackoff:       math.MaxDuration(rate, args.MaxBackoff),
+		failureThreshold: args.FailureThreshold,
+		labels: prometheus.Labels{
+			"namespace": namespace,
+			"workload":  workload,
+		},
+	}
+}
+
+// Success resets the consecutive failures, and returns the time to wait before the next iteration
+func (p *Policy) Success() time.Duration {
+	p.consecutiveFailures = 0
+	consecutiveFailuresGauge.With(p.labels).Set(0)
+	return p.pollInterval
+}
+
+// Failure records a failed iteration, and returns the time to wait before the next iteration.
+// An error is returned instead if the failure isn't retryable, or there are too many consecutive failures.
+func (p *Policy) Failure(err error) (time.Duration, error) {
+	p.consecutiveFailures = p.consecutiveFailures + 1
+	consecutiveFailuresGauge.With(p.labels).Set(float64(p.consecutiveFailures))
+
+	retryable := IsRetryable(err)
+	failedIterationsCounter.With(prometheus.Labels{
+		"namespace": p.labels["namespace"],
+		"workload":  p.labels["workload"],
+		"retryable": fmt.Sprintf("%t", retryable),
+	}).Inc()
+
+	if !retryable {
+		return 0, err
+	}
+	if p.consecutiveFailures >= p.failureThreshold {
+		return 0, fmt.Errorf("Error - %d consecutive failures, the last was: %w", p.consecutiveFailures, err)
+	}
+
+	backoff := p.Backoff(p.consecutiveFailures)
+	if retryAfter := RetryAfter(err); retryAfter != nil {
+		backoff = math.MaxDuration(backoff, *retryAfter)
+	}
+	return backoff, nil
+}
+
+// Backoff returns the time to wait after some number of consecutive failures.
+// The rate is doubled after each failure up to the max backoff, then jitter is applied.
+func (p *Policy) Backoff(failures int) time.Duration {
+	backoff := p.rate
+	for i := 1; i < failures && backoff < p.maxBackoff; i++ {
+		backoff = backoff * 2
+	}
+	if backoff > p.maxBackoff {
+		backoff = p.maxBackoff
+	}
+	return time.Duration(float64(backoff) * (1 + jitter*(2*rand.Float64()-1)))
+}
+
+// IsRetryable returns whether an error is transient, such as a timeout, throttling, or a server error.
+// Errors that will keep failing, such as a missing resource or missing permissions, are not retryable.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	switch t := err.(type) {
+	case *azuredevops.HTTPError:
+		return isRetryableStatusCode(t.StatusCode)
+	case azuredevops.HTTPError:
+		return isRetryableStatusCode(t.StatusCode)
+	case net.Error:
+		return true
+	}
+
+	if status, ok := err.(apierrors.APIStatus); ok {
+		if apierrors.IsNotFound(err) || apierrors.IsUnauthorized(err) || apierrors.IsForbidden(err) ||
+			apierrors.IsInvalid(err) || apierrors.IsBadRequest(err) || apierrors.IsMethodNotSupported(err) {
+			return false
+		}
+		return status.Status().Code == 0 || isRetryableStatusCode(int(status.Status().Code)) || apierrors.IsConflict(err)
+	}
+
+	// Wrapped errors are classified by the error they wrap
+	if wrapped := errors.Unwrap(err); wrapped != nil {
+		return IsRetryable(wrapped)
+	}
+
+	// Unknown errors are retried, up to the failure threshold
+	return true
+}
+
+// RetryAfter returns the time the server asked to wait before retrying, if any
+func RetryAfter(err error) *time.Duration {
+	switch t := err.(type) {
+	case *azuredevops.HTTPError:
+		return t.RetryAfter
+	case azuredevops.HTTPError:
+		return t.RetryAfter
+	}
+	if seconds, ok := apierrors.SuggestsClientDelay(err); ok {
+		retryAfter := time.Duration(seconds) * time.Second
+		return &retryAfter
+	}
+	return nil
+}
+
+func isRetryableStatusCode(statusCode int) bool {
+	return statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
@@ -2,6 +2,7 @@ package scaling
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -19,47 +20,92 @@ import (
 )
 
 var (
-	lastScaleDown    = time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)
-	scaleDownCounter = promauto.NewCounter(prometheus.CounterOpts{
+	scaleDownCounter = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "azp_agent_autoscaler_scale_down_count",
 		Help: "The total number of scale downs",
-	})
-	scaleUpCounter = promauto.NewCounter(prometheus.CounterOpts{
+	}, poolLabelNames)
+	scaleUpCounter = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "azp_agent_autoscaler_scale_up_count",
 		Help: "The total number of scale ups",
-	})
-	scaleDownLimitedCounter = promauto.NewCounter(prometheus.CounterOpts{
+	}, poolLabelNames)
+	scaleDownLimitedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "azp_agent_autoscaler_scale_down_limited_count",
 		Help: "The total number of scale downs prevented due to limits",
-	})
-	scaleSizeGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	}, poolLabelNames)
+	scaleSizeGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "azp_agent_autoscaler_scale_size",
 		Help: "The size of the agent scaling",
-	})
-	totalAgentsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	}, poolLabelNames)
+	totalAgentsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "azp_agent_autoscaler_total_agents_count",
 		Help: "The total number of agents",
-	})
-	activeAgentsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	}, poolLabelNames)
+	activeAgentsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "azp_agent_autoscaler_active_agents_count",
 		Help: "The number of active agents",
-	})
-	pendingAgentsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	}, poolLabelNames)
+	pendingAgentsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "azp_agent_autoscaler_pending_agents_count",
 		Help: "The number of pending agents",
-	})
-	failedAgentsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	}, poolLabelNames)
+	failedAgentsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "azp_agent_autoscaler_failed_agents_count",
 		Help: "The number of failed agents",
-	})
-	queuedPodsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	}, poolLabelNames)
+	unsatisfiableJobsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "azp_agent_autoscaler_unsatisfiable_jobs_count",
+		Help: "The number of queued jobs with demands that the agents can't satisfy",
+	}, poolLabelNames)
+	queuedPodsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "azp_agent_autoscaler_queued_pods_count",
 		Help: "The number of queued pods",
-	})
+	}, poolLabelNames)
 )
 
+// poolGauges are the gauges of an agent pool, removed when a workload switches agent pools
+var poolGauges = []*prometheus.GaugeVec{
+	scaleSizeGauge,
+	totalAgentsGauge,
+	activeAgentsGauge,
+	pendingAgentsGauge,
+	failedAgentsGauge,
+	unsatisfiableJobsGauge,
+	queuedPodsGauge,
+	actualDemandGauge,
+	forecastDemandGauge,
+	forecastPeakDemandGauge,
+	dryRunDesiredReplicasGauge,
+}
+
 // Autoscale the agent deployment
 func Autoscale(azdClient azuredevops.ClientAsync, agentPoolID int, k8sClient kubernetes.ClientAsync, deployment *kubernetes.Workload, args args.Args) error {
+	labels := poolLabels(agentPoolID, deployment)
+	decision := newDecision(agentPoolID, deployment, args, time.Now())
+	scheduledArgs := applySchedules(args, labels, time.Now())
+	if scheduledArgs.Min != args.Min || scheduledArgs.Max != args.Max {
+		decision.step("scheduleMin", scheduledArgs.Min, "")
+		decision.step("scheduleMax", scheduledArgs.Max, "")
+	}
+
+	var err error
+	if strings.EqualFold(args.Mode, "Jobs") {
+		err = autoscaleJobs(azdClient, agentPoolID, k8sClient, deployment, scheduledArgs, decision)
+	} else {
+		err = autoscaleReplicas(azdClient, agentPoolID, k8sClient, deployment, scheduledArgs, decision)
+	}
+	decision.finish(err)
+	decisionLog.Add(*decision)
+	return err
+}
+
+// autoscaleReplicas scales the replicas of the agent deployment
+func autoscaleReplicas(azdClient azuredevops.ClientAsync, agentPoolID int, k8sClient kubernetes.ClientAsync, deployment *kubernetes.Workload, args args.Args, decision *Decision) error {
+	labels := poolLabels(agentPoolID, deployment)
+	state := getWorkloadState(deployment)
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	state.startIteration()
+
 	agentsChan := make(chan azuredevops.PoolAgentsResponse)
 	jobsChan := make(chan azuredevops.JobRequestsResponse)
 	podsChan := make(chan kubernetes.Pods)
@@ -85,77 +131,123 @@ func Autoscale(azdClient azuredevops.ClientAsync, agentPoolID int, k8sClient kub
 	}
 
 	// Get all pod names and statuses
-	podNames := make(collections.StringSet)
+	podNames, numRunningPods, numPendingPods, numUnschedulablePods := countPods(pods.Pods)
 	numPods := int32(len(pods.Pods))
-	numRunningPods, numPendingPods, numUnschedulablePods := int32(0), int32(0), int32(0)
-	for _, pod := range pods.Pods {
-		podNames.Add(pod.Name)
-		if pod.Status.Phase == corev1.PodRunning {
-			allContainersRunning := true
-			for _, containerStatus := range pod.Status.ContainerStatuses {
-				if containerStatus.State.Running == nil || containerStatus.State.Terminated != nil {
-					allContainersRunning = false
-					break
-				}
-			}
-			if allContainersRunning {
-				numRunningPods = numRunningPods + 1
-			} else {
-				numPendingPods = numPendingPods + 1
-			}
-		} else if pod.Status.Phase == corev1.PodPending {
-			numPendingPods = numPendingPods + 1
-			for _, podCondition := range pod.Status.Conditions {
-				if podCondition.Type == corev1.PodScheduled && podCondition.Status == corev1.ConditionFalse && podCondition.Reason == corev1.PodReasonUnschedulable {
-					numUnschedulablePods = numUnschedulablePods + 1
-				}
-			}
-		}
-	}
 	numFailedPods := numPods - numRunningPods - numPendingPods
 
 	logging.Logger.Tracef("%d pods (%d running, %d pending, %d failed)", numPods, numRunningPods, numPendingPods, numFailedPods)
 
+	// After switching agent pools, the agents still running jobs for the previous agent pool are active
+	previousPoolActiveAgents, err := getPreviousPoolActiveAgents(azdClient, deployment, state, podNames)
+	if err != nil {
+		return err
+	}
+	activeAgents := append(previousPoolActiveAgents, agents.Agents...)
+
 	// Get number of active agents
-	activeAgentNames := getActiveAgentNames(agents.Agents, podNames)
-	activeAgentPodNames := getActiveAgentPodNames(agents.Agents, podNames)
+	activeAgentNames := getActiveAgentNames(activeAgents, podNames)
+	activeAgentPodNames := getActiveAgentPodNames(activeAgents, podNames)
 	numActiveAgents := int32(len(activeAgentNames))
 
+	// Agents marked as idle may have picked up a job since, so unmark them before anything lowers the replicas
+	if !args.DryRun {
+		if err := clearActivePodsDeletionCost(k8sClient, pods.Pods, activeAgentPodNames); err != nil {
+			return err
+		}
+	}
+
 	// Determine the number of jobs that are queued
-	numQueuedJobs := getNumQueuedJobs(jobs.Jobs, activeAgentNames)
+	route := registerWorkloadRoute(agentPoolID, deployment, agents.Agents, podNames, args.PollInterval, time.Now())
+	numQueuedJobs, numUnsatisfiableJobs := getNumQueuedJobs(jobs.Jobs, activeAgentNames, getOnlineAgentNames(agents.Agents), route)
 
 	logging.Logger.Debugf("Found %d active agents out of %d agents in the cluster. There are %d queued jobs.", numActiveAgents, numPods, numQueuedJobs)
 
 	// Apply metrics
-	totalAgentsGauge.Set(float64(numPods))
-	activeAgentsGauge.Set(float64(numActiveAgents))
-	pendingAgentsGauge.Set(float64(numPendingPods))
-	failedAgentsGauge.Set(float64(numFailedPods))
-	queuedPodsGauge.Set(float64(numQueuedJobs))
+	totalAgentsGauge.With(labels).Set(float64(numPods))
+	activeAgentsGauge.With(labels).Set(float64(numActiveAgents))
+	pendingAgentsGauge.With(labels).Set(float64(numPendingPods))
+	failedAgentsGauge.With(labels).Set(float64(numFailedPods))
+	queuedPodsGauge.With(labels).Set(float64(numQueuedJobs))
+	unsatisfiableJobsGauge.With(labels).Set(float64(numUnsatisfiableJobs))
+	state.status = WorkloadStatus{
+		TotalAgents:     numPods,
+		ActiveAgents:    numActiveAgents,
+		QueuedJobs:      numQueuedJobs,
+		DesiredReplicas: numPods,
+		LastScaleTime:   state.status.LastScaleTime,
+	}
+	decision.From = numPods
+	decision.Inputs = DecisionInputs{
+		Pods:              numPods,
+		RunningPods:       numRunningPods,
+		PendingPods:       numPendingPods,
+		UnschedulablePods: numUnschedulablePods,
+		FailedPods:        numFailedPods,
+		ActiveAgents:      numActiveAgents,
+		QueuedJobs:        numQueuedJobs,
+		UnsatisfiableJobs: numUnsatisfiableJobs,
+		Min:               args.Min,
+		Max:               args.Max,
+	}
+
+	// Pre-scale for the forecasted demand
+	if min := getMinForForecast(deployment, args.Min, numActiveAgents, numQueuedJobs, labels, time.Now()); min != args.Min {
+		decision.step("forecastMin", min, "")
+		args.Min = min
+	}
 
 	if numRunningPods != numPods {
 		if !(numUnschedulablePods == numPendingPods && numFailedPods == 0) {
 			logging.Logger.Infof("Not scaling - there are %d pending pods and %d failed pods.", numPendingPods, numFailedPods)
-			scaleSizeGauge.Set(0)
+			decision.decide(kubernetes.EventReasonPendingPods, numPods, fmt.Sprintf("there are %d pending pods and %d failed pods", numPendingPods, numFailedPods))
+			eventType := corev1.EventTypeNormal
+			if numFailedPods > 0 {
+				eventType = corev1.EventTypeWarning
+			}
+			recordCondition(k8sClient, deployment, state, eventType, kubernetes.EventReasonPendingPods, fmt.Sprintf("Not scaling - there are %d pending pods and %d failed pods", numPendingPods, numFailedPods))
+			scaleSizeGauge.With(labels).Set(0)
 			return nil
 		}
 	}
 
+	// Keep an agent until the workload has been idle for the scale to zero delay
+	if min := getMinAfterIdle(state, numActiveAgents+numQueuedJobs > 0, args, time.Now()); min != args.Min {
+		decision.step("scaleToZeroMin", min, "")
+		args.Min = min
+	}
+
 	// Determine delta for how much to scale by
-	scale := int32(0)
-	if numActiveAgents+numQueuedJobs+args.Min > numPods {
-		// Scale up
-		scale = numActiveAgents + numQueuedJobs + args.Min - numPods
-	} else if numActiveAgents+args.Min+numQueuedJobs < numPods {
-		// Scale down
-		scale = -numPods + numActiveAgents + args.Min + numQueuedJobs
+	policy, err := MakeScalingPolicy(args.Policy)
+	if err != nil {
+		return err
 	}
+	desiredReplicas, reason := policy.DesiredReplicas(Observation{
+		Pods:              numPods,
+		RunningPods:       numRunningPods,
+		PendingPods:       numPendingPods,
+		UnschedulablePods: numUnschedulablePods,
+		FailedPods:        numFailedPods,
+		ActiveAgents:      numActiveAgents,
+		QueuedJobs:        numQueuedJobs,
+		Min:               args.Min,
+		Max:               args.Max,
+		Time:              time.Now(),
+	})
+	logging.Logger.Tracef("The %s policy wants %d pods for %s", args.Policy.Type, desiredReplicas, reason)
+	decision.step("policy", desiredReplicas, reason)
+	if behaviorReplicas := applyBehavior(state, args.Behavior, numPods, desiredReplicas, labels, time.Now()); behaviorReplicas != desiredReplicas {
+		decision.clamp("behavior", desiredReplicas, behaviorReplicas)
+		desiredReplicas = behaviorReplicas
+	}
+	scale := desiredReplicas - numPods
 
 	// Allow scaling down if there are unschedulable pods
 	// This way node(s) don't have to be allocated and all of the pods launched before a scale down is allowed
 	if scale > 0 && numUnschedulablePods > 0 {
 		logging.Logger.Infof("Not scaling up - there are %d unschedulable pods.", numUnschedulablePods)
-		scaleSizeGauge.Set(0)
+		decision.decide(kubernetes.EventReasonUnschedulablePods, numPods, fmt.Sprintf("not scaling up to %d agents - there are %d unschedulable pods", desiredReplicas, numUnschedulablePods))
+		recordCondition(k8sClient, deployment, state, corev1.EventTypeWarning, kubernetes.EventReasonUnschedulablePods, fmt.Sprintf("Not scaling up to %d agents - there are %d unschedulable pods", desiredReplicas, numUnschedulablePods))
+		scaleSizeGauge.With(labels).Set(0)
 		return nil
 	}
 
@@ -170,79 +262,185 @@ func Autoscale(azdClient azuredevops.ClientAsync, agentPoolID int, k8sClient kub
 			}
 		}
 		if maxActivePod > 0 {
+			decision.clamp("lastAgentActive", numPods+scale, numPods+math.MaxInt32(0-numPods+1+maxActivePod, scale))
 			scale = math.MaxInt32(0-numPods+1+maxActivePod, scale)
 			if scale == 0 {
 				logging.Logger.Debugf("Not scaling down - the last agent pod is active")
-				scaleSizeGauge.Set(0)
+				decision.decide(kubernetes.EventReasonScaleDownBlocked, numPods, fmt.Sprintf("the last agent pod %s-%d is active", deployment.Name, maxActivePod))
+				recordCondition(k8sClient, deployment, state, corev1.EventTypeNormal, kubernetes.EventReasonScaleDownBlocked, fmt.Sprintf("Not scaling down - the last agent pod %s-%d is active", deployment.Name, maxActivePod))
+				scaleSizeGauge.With(labels).Set(0)
 				return nil
 			}
 		}
 	}
 
+	// Deployment pod names are random, but the ReplicaSet controller prefers removing the newest pods
+	// If the newest pods are active, then only scale down by the number of free pods newer than them
+	if scale < 0 && numActiveAgents > 0 && strings.EqualFold(deployment.Kind, "Deployment") && isReplicasStrategy(args.ScaleDown.Strategy) {
+		newestPods := make([]corev1.Pod, len(pods.Pods))
+		copy(newestPods, pods.Pods)
+		sort.SliceStable(newestPods, func(i, j int) bool {
+			return newestPods[j].CreationTimestamp.Before(&newestPods[i].CreationTimestamp)
+		})
+		numNewestFreePods := int32(0)
+		for _, pod := range newestPods {
+			if activeAgentPodNames.Contains(pod.Name) {
+				break
+			}
+			numNewestFreePods = numNewestFreePods + 1
+		}
+		decision.clamp("newestAgentActive", numPods+scale, numPods+math.MaxInt32(-numNewestFreePods, scale))
+		scale = math.MaxInt32(-numNewestFreePods, scale)
+		if scale == 0 {
+			logging.Logger.Debugf("Not scaling down - the newest agent pod is active")
+			decision.decide(kubernetes.EventReasonScaleDownBlocked, numPods, "the newest agent pod is active")
+			recordCondition(k8sClient, deployment, state, corev1.EventTypeNormal, kubernetes.EventReasonScaleDownBlocked, "Not scaling down - the newest agent pod is active")
+			scaleSizeGauge.With(labels).Set(0)
+			return nil
+		}
+	}
+
 	// Apply scaling limits and scale down limits
 	podsToScaleTo := numPods
 	if scale > 0 {
 		// Scale up
 		podsToScaleTo = math.MaxInt32(numActiveAgents, math.MinInt32(args.Max, numPods+scale), numPods-args.ScaleDown.Max)
+		decision.clamp("max", numPods+scale, podsToScaleTo)
 	} else if scale < 0 {
 		// Scale down, don't kill active agents
-		podsToScaleTo = math.MaxInt32(numActiveAgents, math.MinInt32(args.Max, math.MaxInt32(args.Min, numPods+scale)))
+		minReplicas := math.MaxInt32(args.Min, numPods+scale)
+		decision.clamp("min", numPods+scale, minReplicas)
+		maxReplicas := math.MinInt32(args.Max, minReplicas)
+		decision.clamp("max", minReplicas, maxReplicas)
+		podsToScaleTo = math.MaxInt32(numActiveAgents, maxReplicas)
+		decision.clamp("activeAgents", maxReplicas, podsToScaleTo)
 	} else if podsToScaleTo > args.Max {
 		// If there happens to be more pods than the max arg
 		if numActiveAgents > args.Max {
 			podsToScaleTo = numActiveAgents
+			decision.clamp("activeAgents", args.Max, podsToScaleTo)
 			logging.Logger.Warningf("There are %d pods over the max of %d - limiting the scale down to %d active agents", numPods, args.Max, numActiveAgents)
 		} else {
 			podsToScaleTo = math.MaxInt32(args.Max, numPods-args.ScaleDown.Max)
+			decision.clamp("scaleDownMax", args.Max, podsToScaleTo)
 			logging.Logger.Warningf("There are %d pods over the max of %d - scaling down to meet the max", numPods, args.Max)
 		}
 	} else {
 		logging.Logger.Tracef("Not scaling %s from %d pods", deployment.FriendlyName, numPods)
-		scaleSizeGauge.Set(0)
+		scaleSizeGauge.With(labels).Set(0)
 		return nil
 	}
 
 	// Apply scale-down limits
 	if podsToScaleTo < numPods {
 		now := time.Now()
-		nextAllowedScaleDown := lastScaleDown.Add(args.ScaleDown.Delay)
+		nextAllowedScaleDown := state.lastScaleDown.Add(args.ScaleDown.Delay)
 		if now.Before(nextAllowedScaleDown) {
 			logging.Logger.Debugf("Not scaling down %s from %d to %d pods - cannot scale down until %s", deployment.FriendlyName, numPods, podsToScaleTo, nextAllowedScaleDown.String())
-			scaleDownLimitedCounter.Inc()
-			scaleSizeGauge.Set(0)
+			decision.decide(kubernetes.EventReasonScaleDownDelayed, numPods, fmt.Sprintf("not scaling down to %d agents until %s", podsToScaleTo, nextAllowedScaleDown.UTC().Format(time.RFC3339)))
+			recordCondition(k8sClient, deployment, state, corev1.EventTypeNormal, kubernetes.EventReasonScaleDownDelayed, fmt.Sprintf("Not scaling down from %d to %d agents until %s", numPods, podsToScaleTo, nextAllowedScaleDown.UTC().Format(time.RFC3339)))
+			scaleDownLimitedCounter.With(labels).Inc()
+			scaleSizeGauge.With(labels).Set(0)
 			return nil
 		}
 
 		podsToScaleToMin := numPods - args.ScaleDown.Max
 		if podsToScaleTo < podsToScaleToMin {
 			logging.Logger.Debugf("Capping the scale down from %d to %d pods", podsToScaleTo, podsToScaleToMin)
+			decision.clamp("scaleDownMax", podsToScaleTo, podsToScaleToMin)
 			podsToScaleTo = podsToScaleToMin
 		}
 	}
 
+	// Only remove idle agents, instead of letting Kubernetes pick the pods to remove
+	if podsToScaleTo < numPods && !isReplicasStrategy(args.ScaleDown.Strategy) {
+		idleAgentPodNames := getIdleAgentPodNames(agents.Agents, podNames)
+		var numRemoved int32
+		if args.DryRun {
+			numRemoved = math.MinInt32(int32(len(idleAgentPodNames)), numPods-podsToScaleTo)
+		} else if numRemoved, err = removeIdlePods(k8sClient, pods.Pods, idleAgentPodNames, numPods-podsToScaleTo, args.ScaleDown.Strategy, labels); err != nil {
+			return err
+		}
+		if numRemoved == 0 {
+			logging.Logger.Debugf("Not scaling down %s from %d pods - there are no idle agents", deployment.FriendlyName, numPods)
+			decision.decide(kubernetes.EventReasonScaleDownBlocked, numPods, "there are no idle agents")
+			recordCondition(k8sClient, deployment, state, corev1.EventTypeNormal, kubernetes.EventReasonScaleDownBlocked, "Not scaling down - there are no idle agents")
+			scaleSizeGauge.With(labels).Set(0)
+			return nil
+		}
+		decision.clamp("idleAgents", podsToScaleTo, numPods-numRemoved)
+		podsToScaleTo = numPods - numRemoved
+	}
+
+	if numPods != podsToScaleTo && args.DryRun {
+		recordDryRun(k8sClient, deployment, state, numPods, podsToScaleTo, reason, labels)
+		decision.decide(kubernetes.EventReasonDryRun, podsToScaleTo, reason)
+		return nil
+	}
+
 	if numPods != podsToScaleTo {
 		// Apply metrics
 		if podsToScaleTo < numPods {
-			scaleDownCounter.Inc()
+			scaleDownCounter.With(labels).Inc()
 		} else {
-			scaleUpCounter.Inc()
+			scaleUpCounter.With(labels).Inc()
 		}
-		scaleSizeGauge.Set(float64(podsToScaleTo - numPods))
+		scaleSizeGauge.With(labels).Set(float64(podsToScaleTo - numPods))
 
 		logging.Logger.Infof("Scaling %s from %d to %d pods", deployment.FriendlyName, numPods, podsToScaleTo)
 		err := k8sClient.Sync().Scale(deployment, podsToScaleTo)
-		if err == nil && scale < 0 {
-			lastScaleDown = time.Now()
+		if err != nil {
+			k8sClient.Sync().RecordEvent(deployment, corev1.EventTypeWarning, kubernetes.EventReasonFailedScale, fmt.Sprintf("Error scaling from %d to %d agents: %s", numPods, podsToScaleTo, err.Error()))
+			return err
 		}
-		return err
+		eventReason := kubernetes.EventReasonScaledUp
+		if podsToScaleTo < numPods {
+			eventReason = kubernetes.EventReasonScaledDown
+		}
+		k8sClient.Sync().RecordEvent(deployment, corev1.EventTypeNormal, eventReason, fmt.Sprintf("Scaled from %d to %d agents for %s", numPods, podsToScaleTo, reason))
+		decision.decide(eventReason, podsToScaleTo, reason)
+		state.status.DesiredReplicas = podsToScaleTo
+		recordScale(k8sClient, deployment, state, podsToScaleTo-numPods, time.Now())
+		return nil
 	}
 
-	scaleSizeGauge.Set(0)
+	scaleSizeGauge.With(labels).Set(0)
 
 	logging.Logger.Debugf("Not scaling from %d pods", numPods)
 	return nil
 }
 
+// countPods returns the pod names and the number of running, pending, and unschedulable pods
+func countPods(pods []corev1.Pod) (collections.StringSet, int32, int32, int32) {
+	podNames := make(collections.StringSet)
+	numRunningPods, numPendingPods, numUnschedulablePods := int32(0), int32(0), int32(0)
+	for _, pod := range pods {
+		podNames.Add(pod.Name)
+		if pod.Status.Phase == corev1.PodRunning {
+			allContainersRunning := true
+			for _, containerStatus := range pod.Status.ContainerStatuses {
+				if containerStatus.State.Running == nil || containerStatus.State.Terminated != nil {
+					allContainersRunning = false
+					break
+				}
+			}
+			if allContainersRunning {
+				numRunningPods = numRunningPods + 1
+			} else {
+				numPendingPods = numPendingPods + 1
+			}
+		} else if pod.Status.Phase == corev1.PodPending {
+			numPendingPods = numPendingPods + 1
+			for _, podCondition := range pod.Status.Conditions {
+				if podCondition.Type == corev1.PodScheduled && podCondition.Status == corev1.ConditionFalse && podCondition.Reason == corev1.PodReasonUnschedulable {
+					numUnschedulablePods = numUnschedulablePods + 1
+				}
+			}
+		}
+	}
+	return podNames, numRunningPods, numPendingPods, numUnschedulablePods
+}
+
 func getActiveAgentNames(agents []azuredevops.AgentDetails, podNames collections.StringSet) collections.StringSet {
 	activeAgentNames := make(collections.StringSet)
 	for _, agent := range agents {
@@ -269,21 +467,63 @@ func getActiveAgentPodNames(agents []azuredevops.AgentDetails, podNames collecti
 	return activeAgentPodNames
 }
 
-func getNumQueuedJobs(jobs []azuredevops.JobRequest, activeAgentNames collections.StringSet) int32 {
-	numQueuedJobs := int32(0)
+// getOnlineAgentNames returns the names of the online agents of the agent pool, including the agents
+// running outside of the workload, such as in other workloads or on VMs
+func getOnlineAgentNames(agents []azuredevops.AgentDetails) collections.StringSet {
+	onlineAgentNames := make(collections.StringSet)
+	for _, agent := range agents {
+		if strings.EqualFold(agent.Status, "online") {
+			onlineAgentNames.Add(agent.Name)
+		}
+	}
+	return onlineAgentNames
+}
+
+// getNumQueuedJobs returns the number of jobs waiting for an agent, and the number of queued jobs no agents can run.
+// Jobs matched to a free online agent will be picked up by it, so they aren't counted. Jobs that any agent can run,
+// and jobs that aren't matched to any online agent, such as when no agents are online, are counted if they are routed
+// to the workload by their demands, so that only one workload of the agent pool scales for them.
+// They aren't routed until every autoscaled workload has registered its route, since they may be routed to it.
+func getNumQueuedJobs(jobs []azuredevops.JobRequest, activeAgentNames collections.StringSet, onlineAgentNames collections.StringSet, route workloadRoute) (int32, int32) {
+	numQueuedJobs, numUnsatisfiableJobs := int32(0), int32(0)
 	for _, job := range jobs {
-		if job.IsQueuedOrRunning() && job.ReservedAgent == nil {
-			if job.MatchesAllAgentsInPool {
-				numQueuedJobs = numQueuedJobs + 1
-			} else if len(job.MatchedAgents) > 0 {
-				for _, agent := range job.MatchedAgents {
-					if activeAgentNames.Contains(agent.Name) {
-						numQueuedJobs = numQueuedJobs + 1
-						break
-					}
+		if !job.IsQueuedOrRunning() || job.ReservedAgent != nil {
+			continue
+		}
+
+		if !job.MatchesAllAgentsInPool {
+			matchesActiveAgent, matchesOnlineAgent := false, false
+			for _, agent := range job.MatchedAgents {
+				if activeAgentNames.Contains(agent.Name) {
+					matchesActiveAgent = true
+				}
+				if onlineAgentNames.Contains(agent.Name) {
+					matchesOnlineAgent = true
 				}
 			}
+
+			if matchesActiveAgent {
+				numQueuedJobs = numQueuedJobs + 1
+				continue
+			} else if matchesOnlineAgent {
+				// The job only matches free agents, one of them will pick it up
+				continue
+			}
+		}
+
+		if !route.complete {
+			logging.Logger.Tracef("Job %s isn't routed until every workload has been autoscaled", job.JobID)
+			continue
+		}
+		accepted, satisfiable := route.accepts(job.Demands)
+		if accepted {
+			numQueuedJobs = numQueuedJobs + 1
+		} else if satisfiable {
+			logging.Logger.Tracef("Job %s is routed to another workload of the agent pool", job.JobID)
+		} else {
+			logging.Logger.Tracef("Job %s has demands the agents can't satisfy: %s", job.JobID, strings.Join(job.Demands, ", "))
+			numUnsatisfiableJobs = numUnsatisfiableJobs + 1
 		}
 	}
-	return numQueuedJobs
+	return numQueuedJobs, numUnsatisfiableJobs
 }
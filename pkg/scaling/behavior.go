@@ -0,0 +1,161 @@
+package scaling
+
+import (
+	gomath "math"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/logging"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/math"
+)
+
+var (
+	scaleUpLimitedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "azp_agent_autoscaler_scale_up_limited_count",
+		Help: "The total number of scale ups limited by the stabilization window or the scale up policies",
+	}, poolLabelNames)
+)
+
+// recommendation is the desired number of replicas computed by an autoscaling iteration
+type recommendation struct {
+	replicas int32
+	time     time.Time
+}
+
+// scaleEvent is a change of the number of replicas
+type scaleEvent struct {
+	change int32
+	time   time.Time
+}
+
+// applyBehavior returns the desired number of replicas stabilized over the stabilization windows,
+// and limited by the behavior policies, like the HorizontalPodAutoscaler
+func applyBehavior(state *workloadState, behavior args.BehaviorArgs, currentReplicas int32, desiredReplicas int32, labels prometheus.Labels, now time.Time) int32 {
+	// Keep the recommendations of the longest stabilization window
+	state.recommendations = append(state.recommendations, recommendation{desiredReplicas, now})
+	longestWindow := behavior.ScaleUp.StabilizationWindow
+	if behavior.ScaleDown.StabilizationWindow > longestWindow {
+		longestWindow = behavior.ScaleDown.StabilizationWindow
+	}
+	for len(state.recommendations) > 0 && state.recommendations[0].time.Before(now.Add(-longestWindow)) {
+		state.recommendations = state.recommendations[1:]
+	}
+
+	// Scale up to the lowest recommendation over the window, and down to the highest
+	upRecommendation, downRecommendation := desiredReplicas, desiredReplicas
+	for _, r := range state.recommendations {
+		if !r.time.Before(now.Add(-behavior.ScaleUp.StabilizationWindow)) {
+			upRecommendation = math.MinInt32(upRecommendation, r.replicas)
+		}
+		if !r.time.Before(now.Add(-behavior.ScaleDown.StabilizationWindow)) {
+			downRecommendation = math.MaxInt32(downRecommendation, r.replicas)
+		}
+	}
+	stabilizedReplicas := currentReplicas
+	if stabilizedReplicas < upRecommendation {
+		stabilizedReplicas = upRecommendation
+	}
+	if stabilizedReplicas > downRecommendation {
+		stabilizedReplicas = downRecommendation
+	}
+	if stabilizedReplicas != desiredReplicas {
+		logging.Logger.Debugf("Stabilized the desired replicas from %d to %d", desiredReplicas, stabilizedReplicas)
+	}
+
+	// Apply the behavior policies, keeping the scale events of the longest policy period
+	for len(state.scaleEvents) > 0 && state.scaleEvents[0].time.Before(now.Add(-30*time.Minute)) {
+		state.scaleEvents = state.scaleEvents[1:]
+	}
+	if stabilizedReplicas > currentReplicas {
+		limit := getScaleUpLimit(state.scaleEvents, behavior.ScaleUp, currentReplicas, now)
+		if stabilizedReplicas > limit {
+			logging.Logger.Debugf("Limiting the scale up from %d to %d pods by the scale up policies", stabilizedReplicas, limit)
+			stabilizedReplicas = limit
+		}
+	} else if stabilizedReplicas < currentReplicas {
+		limit := getScaleDownLimit(state.scaleEvents, behavior.ScaleDown, currentReplicas, now)
+		if stabilizedReplicas < limit {
+			logging.Logger.Debugf("Limiting the scale down from %d to %d pods by the scale down policies", stabilizedReplicas, limit)
+			stabilizedReplicas = limit
+		}
+	}
+
+	if desiredReplicas > currentReplicas && stabilizedReplicas < desiredReplicas {
+		scaleUpLimitedCounter.With(labels).Inc()
+	} else if desiredReplicas < currentReplicas && stabilizedReplicas > desiredReplicas {
+		scaleDownLimitedCounter.With(labels).Inc()
+	}
+	return stabilizedReplicas
+}
+
+// getScaleUpLimit returns the most replicas the scale up policies allow
+func getScaleUpLimit(events []scaleEvent, rules args.ScalingRulesArgs, currentReplicas int32, now time.Time) int32 {
+	if strings.EqualFold(rules.SelectPolicy, "Disabled") {
+		return currentReplicas
+	}
+	if len(rules.Policies) == 0 {
+		return gomath.MaxInt32
+	}
+
+	var limit int32
+	for i, policy := range rules.Policies {
+		periodStartReplicas := currentReplicas - getReplicasChanged(events, policy.Period, true, now)
+		var policyLimit int32
+		if strings.EqualFold(policy.Type, "Percent") {
+			policyLimit = int32(gomath.Ceil(float64(periodStartReplicas) * (1 + float64(policy.Value)/100)))
+			// A percentage of 0 replicas would never scale up
+			policyLimit = math.MaxInt32(policyLimit, periodStartReplicas+1)
+		} else {
+			policyLimit = periodStartReplicas + policy.Value
+		}
+		if i == 0 || (strings.EqualFold(rules.SelectPolicy, "Min") && policyLimit < limit) || (!strings.EqualFold(rules.SelectPolicy, "Min") && policyLimit > limit) {
+			limit = policyLimit
+		}
+	}
+	return math.MaxInt32(limit, currentReplicas)
+}
+
+// getScaleDownLimit returns the fewest replicas the scale down policies allow
+func getScaleDownLimit(events []scaleEvent, rules args.ScalingRulesArgs, currentReplicas int32, now time.Time) int32 {
+	if strings.EqualFold(rules.SelectPolicy, "Disabled") {
+		return currentReplicas
+	}
+	if len(rules.Policies) == 0 {
+		return 0
+	}
+
+	var limit int32
+	for i, policy := range rules.Policies {
+		periodStartReplicas := currentReplicas + getReplicasChanged(events, policy.Period, false, now)
+		var policyLimit int32
+		if strings.EqualFold(policy.Type, "Percent") {
+			policyLimit = int32(gomath.Floor(float64(periodStartReplicas) * (1 - float64(policy.Value)/100)))
+		} else {
+			policyLimit = periodStartReplicas - policy.Value
+		}
+		if i == 0 || (strings.EqualFold(rules.SelectPolicy, "Min") && policyLimit > limit) || (!strings.EqualFold(rules.SelectPolicy, "Min") && policyLimit < limit) {
+			limit = policyLimit
+		}
+	}
+	return math.MinInt32(math.MaxInt32(limit, 0), currentReplicas)
+}
+
+// getReplicasChanged returns the number of replicas added or removed over the period
+func getReplicasChanged(events []scaleEvent, period time.Duration, added bool, now time.Time) int32 {
+	changed := int32(0)
+	for _, event := range events {
+		if event.time.Before(now.Add(-period)) {
+			continue
+		}
+		if added && event.change > 0 {
+			changed = changed + event.change
+		} else if !added && event.change < 0 {
+			changed = changed - event.change
+		}
+	}
+	return changed
+}
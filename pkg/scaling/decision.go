@@ -0,0 +1,199 @@
+package scaling
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/logging"
+)
+
+// DefaultDecisionLogSize is the number of decisions kept by default
+const DefaultDecisionLogSize = 100
+
+// The reason codes of decisions that don't match an Event reason
+const (
+	DecisionReasonNoChange = "NoChange"
+	DecisionReasonError    = "Error"
+)
+
+// Decision is the record of one autoscaling iteration of a workload
+type Decision struct {
+	Time      time.Time `json:"time"`
+	Namespace string    `json:"namespace"`
+	Workload  string    `json:"workload"`
+	PoolID    int       `json:"poolId"`
+	Mode      string    `json:"mode"`
+	DryRun    bool      `json:"dryRun"`
+
+	Inputs DecisionInputs  `json:"inputs"`
+	Steps  []DecisionStep  `json:"steps"`
+	Clamps []DecisionClamp `json:"clamps"`
+
+	// The final decision. Reason is a code, ex. ScaledUp or ScaleDownDelayed.
+	From    int32  `json:"from"`
+	To      int32  `json:"to"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+	Error   string `json:"error,omitempty"`
+}
+
+// DecisionInputs is the state observed by an autoscaling iteration
+type DecisionInputs struct {
+	Pods              int32 `json:"pods"`
+	RunningPods       int32 `json:"runningPods"`
+	PendingPods       int32 `json:"pendingPods"`
+	UnschedulablePods int32 `json:"unschedulablePods"`
+	FailedPods        int32 `json:"failedPods"`
+	ActiveAgents      int32 `json:"activeAgents"`
+	QueuedJobs        int32 `json:"queuedJobs"`
+	UnsatisfiableJobs int32 `json:"unsatisfiableJobs"`
+	Min               int32 `json:"min"`
+	Max               int32 `json:"max"`
+}
+
+// DecisionStep is a value computed by an autoscaling iteration, ex. the replicas wanted by the scaling policy
+type DecisionStep struct {
+	Name   string `json:"name"`
+	Value  int32  `json:"value"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// DecisionClamp is a limit that changed the number of replicas to scale to
+type DecisionClamp struct {
+	Name string `json:"name"`
+	From int32  `json:"from"`
+	To   int32  `json:"to"`
+}
+
+// newDecision returns the decision of an autoscaling iteration that is starting
+func newDecision(agentPoolID int, workload *kubernetes.Workload, args args.Args, now time.Time) *Decision {
+	mode := args.Mode
+	if mode == "" {
+		mode = "Replicas"
+	}
+	return &Decision{
+		Time:      now,
+		Namespace: workload.Namespace,
+		Workload:  workload.FriendlyName,
+		PoolID:    agentPoolID,
+		Mode:      mode,
+		DryRun:    args.DryRun,
+		Steps:     []DecisionStep{},
+		Clamps:    []DecisionClamp{},
+	}
+}
+
+// step records a value computed by the iteration
+func (d *Decision) step(name string, value int32, detail string) {
+	d.Steps = append(d.Steps, DecisionStep{name, value, detail})
+}
+
+// clamp records a limit if it changed the number of replicas
+func (d *Decision) clamp(name string, from int32, to int32) {
+	if from != to {
+		d.Clamps = append(d.Clamps, DecisionClamp{name, from, to})
+	}
+}
+
+// decide records the final decision of the iteration
+func (d *Decision) decide(reason string, to int32, message string) {
+	d.To = to
+	d.Reason = reason
+	d.Message = message
+}
+
+// finish records the error of the iteration, if any
+func (d *Decision) finish(err error) {
+	if err != nil {
+		d.To = d.From
+		d.Reason = DecisionReasonError
+		d.Error = err.Error()
+	} else if d.Reason == "" {
+		d.decide(DecisionReasonNoChange, d.From, "")
+	}
+}
+
+// DecisionLog is a ring buffer of the most recent decisions of every workload
+type DecisionLog struct {
+	decisions []Decision
+	next      int
+	full      bool
+	lock      sync.Mutex
+}
+
+// MakeDecisionLog returns a DecisionLog keeping up to size decisions
+func MakeDecisionLog(size int) *DecisionLog {
+	return &DecisionLog{decisions: make([]Decision, size)}
+}
+
+// Add adds a decision, replacing the oldest decision if the log is full
+func (l *DecisionLog) Add(decision Decision) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.decisions) == 0 {
+		return
+	}
+	l.decisions[l.next] = decision
+	l.next = (l.next + 1) % len(l.decisions)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// List returns the decisions from oldest to newest
+func (l *DecisionLog) List() []Decision {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if !l.full {
+		return append([]Decision{}, l.decisions[:l.next]...)
+	}
+	return append(append([]Decision{}, l.decisions[l.next:]...), l.decisions[:l.next]...)
+}
+
+// ServeHTTP writes the decisions as JSON, from oldest to newest.
+// The namespace, workload and poolId query parameters filter the decisions.
+func (l *DecisionLog) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	logging.Logger.Trace("Listing decisions")
+
+	query := request.URL.Query()
+	decisions := []Decision{}
+	for _, decision := range l.List() {
+		if namespace := query.Get("namespace"); namespace != "" && namespace != decision.Namespace {
+			continue
+		}
+		if workload := query.Get("workload"); workload != "" && workload != decision.Workload {
+			continue
+		}
+		if poolID := query.Get("poolId"); poolID != "" && poolID != strconv.Itoa(decision.PoolID) {
+			continue
+		}
+		decisions = append(decisions, decision)
+	}
+
+	body, err := json.Marshal(decisions)
+	if err != nil {
+		logging.Logger.Errorf("Error serializing the decisions: %s", err.Error())
+		writer.WriteHeader(500)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(200)
+	writer.Write(body)
+}
+
+var decisionLog = MakeDecisionLog(DefaultDecisionLogSize)
+
+// SetDecisionLog replaces the log the decisions of every workload are added to
+func SetDecisionLog(log *DecisionLog) {
+	decisionLog = log
+}
+
+// GetDecisionLog returns the log the decisions of every workload are added to
+func GetDecisionLog() *DecisionLog {
+	return decisionLog
+}
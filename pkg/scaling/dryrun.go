@@ -0,0 +1,44 @@
+package scaling
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/logging"
+)
+
+var (
+	dryRunScaleUpCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "azp_agent_autoscaler_dry_run_scale_up_count",
+		Help: "The total number of scale ups that would have happened without the dry run",
+	}, poolLabelNames)
+	dryRunScaleDownCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "azp_agent_autoscaler_dry_run_scale_down_count",
+		Help: "The total number of scale downs that would have happened without the dry run",
+	}, poolLabelNames)
+	dryRunDesiredReplicasGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "azp_agent_autoscaler_dry_run_desired_replicas",
+		Help: "The number of agents the last iteration would have scaled to without the dry run",
+	}, poolLabelNames)
+)
+
+// recordDryRun logs and exports a scaling decision instead of applying it.
+// The scaling state isn't changed, since the agents weren't scaled.
+func recordDryRun(k8sClient kubernetes.ClientAsync, workload *kubernetes.Workload, state *workloadState, from int32, to int32, reason string, labels prometheus.Labels) {
+	dryRunDesiredReplicasGauge.With(labels).Set(float64(to))
+	if to > from {
+		dryRunScaleUpCounter.With(labels).Inc()
+	} else if to < from {
+		dryRunScaleDownCounter.With(labels).Inc()
+	}
+	scaleSizeGauge.With(labels).Set(0)
+	state.status.DesiredReplicas = to
+
+	logging.Logger.Infof("Dry run - would scale %s from %d to %d agents for %s", workload.FriendlyName, from, to, reason)
+	k8sClient.Sync().RecordEvent(workload, corev1.EventTypeNormal, kubernetes.EventReasonDryRun, fmt.Sprintf("Dry run - would scale from %d to %d agents for %s", from, to, reason))
+}
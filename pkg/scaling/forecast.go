@@ -0,0 +1,59 @@
+package scaling
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/forecast"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/logging"
+)
+
+var (
+	actualDemandGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "azp_agent_autoscaler_actual_demand_count",
+		Help: "The number of active agents and queued jobs",
+	}, poolLabelNames)
+	forecastDemandGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "azp_agent_autoscaler_forecast_demand_count",
+		Help: "The learned number of active agents and queued jobs at this time",
+	}, poolLabelNames)
+	forecastPeakDemandGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "azp_agent_autoscaler_forecast_peak_demand_count",
+		Help: "The highest learned number of active agents and queued jobs within the forecast lead time",
+	}, poolLabelNames)
+)
+
+var forecaster *forecast.Forecaster
+
+// SetForecaster enables predictive scaling with the forecaster, or disables it if nil
+func SetForecaster(f *forecast.Forecaster) {
+	forecaster = f
+}
+
+// getMinForForecast records the demand of a workload, and returns the min number of free agents
+// raised so that there are enough agents for the forecasted demand
+func getMinForForecast(workload *kubernetes.Workload, min int32, numActiveAgents int32, numQueuedJobs int32, labels prometheus.Labels, now time.Time) int32 {
+	if forecaster == nil {
+		return min
+	}
+
+	demand := numActiveAgents + numQueuedJobs
+	prediction, err := forecaster.Observe(workload.Namespace+"/"+workload.FriendlyName, now, demand)
+	if err != nil {
+		// The forecast still works from the history in memory
+		logging.Logger.Warnf("Error saving the forecast: %s", err.Error())
+	}
+
+	actualDemandGauge.With(labels).Set(float64(demand))
+	forecastDemandGauge.With(labels).Set(prediction.Current)
+	forecastPeakDemandGauge.With(labels).Set(float64(prediction.Peak))
+
+	if prediction.Peak > demand+min {
+		logging.Logger.Debugf("Pre-scaling %s for a forecasted demand of %d agents", workload.FriendlyName, prediction.Peak)
+		return prediction.Peak - demand
+	}
+	return min
+}
@@ -0,0 +1,117 @@
+package scaling
+
+import (
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/azuredevops"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/collections"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/logging"
+)
+
+// podDeletionCostAnnotation is read by the ReplicaSet controller to pick which pods to remove first
+// Ref: https://kubernetes.io/docs/concepts/workloads/controllers/replicaset/#pod-deletion-cost
+const podDeletionCostAnnotation = "controller.kubernetes.io/pod-deletion-cost"
+
+// idlePodDeletionCost is the deletion cost given to idle agents, lower than the default of 0
+const idlePodDeletionCost = "-1000"
+
+var (
+	idlePodsRemovedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "azp_agent_autoscaler_idle_pods_removed_count",
+		Help: "The total number of idle agent pods selected for removal",
+	}, poolLabelNames)
+)
+
+// isReplicasStrategy returns whether scale downs only lower the replicas, letting Kubernetes pick the pods to remove
+func isReplicasStrategy(strategy string) bool {
+	return strategy == "" || strings.EqualFold(strategy, "Replicas")
+}
+
+func getIdleAgentPodNames(agents []azuredevops.AgentDetails, podNames collections.StringSet) collections.StringSet {
+	idleAgentPodNames := make(collections.StringSet)
+	for _, agent := range agents {
+		if strings.EqualFold(agent.Status, "online") {
+			podName := agent.SystemCapabilities["HOSTNAME"]
+			if podNames.Contains(podName) && agent.AssignedRequest == nil {
+				idleAgentPodNames.Add(podName)
+			}
+		}
+	}
+	return idleAgentPodNames
+}
+
+// removeIdlePods marks (DeletionCost) or deletes (Delete) up to numToRemove idle agent pods, newest first,
+// so that the following scale down only removes idle agents. It returns the number of pods selected.
+//
+// With the Delete strategy the ReplicaSet controller may create replacements for the deleted pods
+// before the replicas are lowered. Those replacements aren't ready yet, so they are removed first by the scale down.
+func removeIdlePods(k8sClient kubernetes.ClientAsync, pods []corev1.Pod, idleAgentPodNames collections.StringSet, numToRemove int32, strategy string, labels prometheus.Labels) (int32, error) {
+	newestPods := make([]corev1.Pod, len(pods))
+	copy(newestPods, pods)
+	sort.SliceStable(newestPods, func(i, j int) bool {
+		return newestPods[j].CreationTimestamp.Before(&newestPods[i].CreationTimestamp)
+	})
+
+	deleteCost := idlePodDeletionCost
+	errChan := make(chan error)
+	numCalls := 0
+	numSelected := int32(0)
+	for i := range newestPods {
+		pod := &newestPods[i]
+		if numSelected < numToRemove && idleAgentPodNames.Contains(pod.Name) {
+			numSelected = numSelected + 1
+			if strings.EqualFold(strategy, "Delete") {
+				logging.Logger.Debugf("Deleting idle agent pod %s", pod.Name)
+				go k8sClient.DeletePodAsync(errChan, pod)
+			} else {
+				logging.Logger.Debugf("Setting the deletion cost of idle agent pod %s to %s", pod.Name, deleteCost)
+				go k8sClient.AnnotatePodAsync(errChan, pod, map[string]*string{podDeletionCostAnnotation: &deleteCost})
+			}
+			numCalls = numCalls + 1
+		}
+	}
+
+	var err error
+	for i := 0; i < numCalls; i++ {
+		if callErr := <-errChan; callErr != nil && err == nil {
+			err = callErr
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	idlePodsRemovedCounter.With(labels).Add(float64(numSelected))
+	return numSelected, nil
+}
+
+// clearActivePodsDeletionCost removes the deletion cost of agent pods that were marked as idle by a previous scale down
+// that didn't happen, and have since picked up a job. It must run before the replicas are lowered,
+// so that the ReplicaSet controller doesn't pick the active agents.
+func clearActivePodsDeletionCost(k8sClient kubernetes.ClientAsync, pods []corev1.Pod, activeAgentPodNames collections.StringSet) error {
+	errChan := make(chan error)
+	numCalls := 0
+	for i := range pods {
+		pod := &pods[i]
+		if activeAgentPodNames.Contains(pod.Name) && pod.Annotations[podDeletionCostAnnotation] == idlePodDeletionCost {
+			logging.Logger.Debugf("Removing the deletion cost of active agent pod %s", pod.Name)
+			go k8sClient.AnnotatePodAsync(errChan, pod, map[string]*string{podDeletionCostAnnotation: nil})
+			numCalls = numCalls + 1
+		}
+	}
+
+	var err error
+	for i := 0; i < numCalls; i++ {
+		if callErr := <-errChan; callErr != nil && err == nil {
+			err = callErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,205 @@
+package scaling
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/azuredevops"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/logging"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/math"
+)
+
+// autoscaleJobs creates a one-shot agent Job from the workload's pod template for each queued job
+func autoscaleJobs(azdClient azuredevops.ClientAsync, agentPoolID int, k8sClient kubernetes.ClientAsync, workload *kubernetes.Workload, args args.Args, decision *Decision) error {
+	agentsChan := make(chan azuredevops.PoolAgentsResponse)
+	jobsChan := make(chan azuredevops.JobRequestsResponse)
+	agentJobsChan := make(chan kubernetes.Jobs)
+	podsChan := make(chan kubernetes.Pods)
+	labels := poolLabels(agentPoolID, workload)
+	state := getWorkloadState(workload)
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	state.startIteration()
+
+	// The pods of the agent Jobs don't match the workload's selector
+	jobPodsWorkload := *workload
+	jobPodsWorkload.PodSelector = kubernetes.AgentJobSelector(workload)
+
+	// Get all active agents
+	go azdClient.ListPoolAgentsAsync(agentsChan, agentPoolID)
+	// Get all queued jobs
+	go azdClient.ListJobRequestsAsync(jobsChan, agentPoolID)
+	// Get all agent Jobs
+	go k8sClient.ListJobsAsync(agentJobsChan, workload)
+	// Get all agent Job pods
+	go k8sClient.GetPodsAsync(podsChan, &jobPodsWorkload)
+
+	agents := <-agentsChan
+	if agents.Err != nil {
+		return agents.Err
+	}
+	jobs := <-jobsChan
+	if jobs.Err != nil {
+		return jobs.Err
+	}
+	agentJobs := <-agentJobsChan
+	if agentJobs.Err != nil {
+		return agentJobs.Err
+	}
+	pods := <-podsChan
+	if pods.Err != nil {
+		return pods.Err
+	}
+
+	// Clean up finished Jobs
+	errChan := make(chan error)
+	numJobs, numFailedJobs, numFinishedJobs := int32(0), int32(0), 0
+	for i := range agentJobs.Jobs {
+		agentJob := &agentJobs.Jobs[i]
+		if kubernetes.IsJobFinished(*agentJob) {
+			if kubernetes.IsJobFailed(*agentJob) {
+				numFailedJobs = numFailedJobs + 1
+			}
+			if args.DryRun {
+				logging.Logger.Debugf("Dry run - would delete finished agent job %s", agentJob.Name)
+				continue
+			}
+			logging.Logger.Debugf("Deleting finished agent job %s", agentJob.Name)
+			go k8sClient.DeleteJobAsync(errChan, agentJob)
+			numFinishedJobs = numFinishedJobs + 1
+		} else {
+			numJobs = numJobs + 1
+		}
+	}
+	var err error
+	for i := 0; i < numFinishedJobs; i++ {
+		if deleteErr := <-errChan; deleteErr != nil && err == nil {
+			err = deleteErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	podNames, numRunningPods, numPendingPods, numUnschedulablePods := countPods(pods.Pods)
+
+	logging.Logger.Tracef("%d agent jobs (%d running pods, %d pending pods, %d failed jobs)", numJobs, numRunningPods, numPendingPods, numFailedJobs)
+
+	// Get number of active agents
+	activeAgentNames := getActiveAgentNames(agents.Agents, podNames)
+	numActiveAgents := int32(len(activeAgentNames))
+
+	// Determine the number of jobs that are queued
+	route := registerWorkloadRoute(agentPoolID, workload, agents.Agents, podNames, args.PollInterval, time.Now())
+	numQueuedJobs, numUnsatisfiableJobs := getNumQueuedJobs(jobs.Jobs, activeAgentNames, getOnlineAgentNames(agents.Agents), route)
+
+	logging.Logger.Debugf("Found %d active agents out of %d agent jobs. There are %d queued jobs.", numActiveAgents, numJobs, numQueuedJobs)
+
+	// Apply metrics
+	totalAgentsGauge.With(labels).Set(float64(numJobs))
+	activeAgentsGauge.With(labels).Set(float64(numActiveAgents))
+	pendingAgentsGauge.With(labels).Set(float64(numPendingPods))
+	failedAgentsGauge.With(labels).Set(float64(numFailedJobs))
+	queuedPodsGauge.With(labels).Set(float64(numQueuedJobs))
+	unsatisfiableJobsGauge.With(labels).Set(float64(numUnsatisfiableJobs))
+	state.status = WorkloadStatus{
+		TotalAgents:     numJobs,
+		ActiveAgents:    numActiveAgents,
+		QueuedJobs:      numQueuedJobs,
+		DesiredReplicas: numJobs,
+		LastScaleTime:   state.status.LastScaleTime,
+	}
+	decision.From = numJobs
+	decision.Inputs = DecisionInputs{
+		Pods:              numJobs,
+		RunningPods:       numRunningPods,
+		PendingPods:       numPendingPods,
+		UnschedulablePods: numUnschedulablePods,
+		FailedPods:        numFailedJobs,
+		ActiveAgents:      numActiveAgents,
+		QueuedJobs:        numQueuedJobs,
+		UnsatisfiableJobs: numUnsatisfiableJobs,
+		Min:               args.Min,
+		Max:               args.Max,
+	}
+
+	// Pre-create agent jobs for the forecasted demand
+	if min := getMinForForecast(workload, args.Min, numActiveAgents, numQueuedJobs, labels, time.Now()); min != args.Min {
+		decision.step("forecastMin", min, "")
+		args.Min = min
+	}
+
+	policy, err := MakeScalingPolicy(args.Policy)
+	if err != nil {
+		return err
+	}
+	desiredJobs, reason := policy.DesiredReplicas(Observation{
+		Pods:              numJobs,
+		RunningPods:       numRunningPods,
+		PendingPods:       numPendingPods,
+		UnschedulablePods: numUnschedulablePods,
+		FailedPods:        numFailedJobs,
+		ActiveAgents:      numActiveAgents,
+		QueuedJobs:        numQueuedJobs,
+		Min:               args.Min,
+		Max:               args.Max,
+		Time:              time.Now(),
+	})
+	logging.Logger.Tracef("The %s policy wants %d agent jobs for %s", args.Policy.Type, desiredJobs, reason)
+	decision.step("policy", desiredJobs, reason)
+	if behaviorJobs := applyBehavior(state, args.Behavior, math.MaxInt32(numJobs, numActiveAgents), desiredJobs, labels, time.Now()); behaviorJobs != desiredJobs {
+		decision.clamp("behavior", desiredJobs, behaviorJobs)
+		desiredJobs = behaviorJobs
+	}
+
+	// Agent Jobs that are starting or waiting for a job
+	numFreeJobs := math.MaxInt32(0, numJobs-numActiveAgents)
+	numToCreate := math.MinInt32(desiredJobs-numActiveAgents-numFreeJobs, args.Max-numJobs)
+	decision.clamp("max", numJobs+desiredJobs-numActiveAgents-numFreeJobs, numJobs+numToCreate)
+	if numToCreate <= 0 {
+		logging.Logger.Tracef("Not creating agent jobs for %s - there are %d free agent jobs", workload.FriendlyName, numFreeJobs)
+		scaleSizeGauge.With(labels).Set(0)
+		return nil
+	}
+
+	if numUnschedulablePods > 0 {
+		logging.Logger.Infof("Not creating agent jobs - there are %d unschedulable pods.", numUnschedulablePods)
+		decision.decide(kubernetes.EventReasonUnschedulablePods, numJobs, fmt.Sprintf("not creating %d agent jobs - there are %d unschedulable pods", numToCreate, numUnschedulablePods))
+		recordCondition(k8sClient, workload, state, corev1.EventTypeWarning, kubernetes.EventReasonUnschedulablePods, fmt.Sprintf("Not creating %d agent jobs - there are %d unschedulable pods", numToCreate, numUnschedulablePods))
+		scaleSizeGauge.With(labels).Set(0)
+		return nil
+	}
+
+	if args.DryRun {
+		recordDryRun(k8sClient, workload, state, numJobs, numJobs+numToCreate, reason, labels)
+		decision.decide(kubernetes.EventReasonDryRun, numJobs+numToCreate, reason)
+		return nil
+	}
+
+	logging.Logger.Infof("Creating %d agent jobs from %s", numToCreate, workload.FriendlyName)
+	for i := int32(0); i < numToCreate; i++ {
+		go k8sClient.CreateJobAsync(errChan, kubernetes.MakeAgentJob(workload))
+	}
+	for i := int32(0); i < numToCreate; i++ {
+		if createErr := <-errChan; createErr != nil && err == nil {
+			err = createErr
+		}
+	}
+	if err != nil {
+		k8sClient.Sync().RecordEvent(workload, corev1.EventTypeWarning, kubernetes.EventReasonFailedCreateJobs, fmt.Sprintf("Error creating %d agent jobs: %s", numToCreate, err.Error()))
+		return err
+	}
+	k8sClient.Sync().RecordEvent(workload, corev1.EventTypeNormal, kubernetes.EventReasonCreatedAgentJobs, fmt.Sprintf("Created %d agent jobs for %s", numToCreate, reason))
+	decision.decide(kubernetes.EventReasonCreatedAgentJobs, numJobs+numToCreate, reason)
+
+	state.status.DesiredReplicas = numJobs + numToCreate
+	recordScale(k8sClient, workload, state, numToCreate, time.Now())
+
+	scaleUpCounter.With(labels).Inc()
+	scaleSizeGauge.With(labels).Set(float64(numToCreate))
+	return nil
+}
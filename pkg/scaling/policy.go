@@ -0,0 +1,118 @@
+package scaling
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/math"
+)
+
+// Observation is the state of an agent pool workload observed by an autoscaling iteration
+type Observation struct {
+	Pods              int32
+	RunningPods       int32
+	PendingPods       int32
+	UnschedulablePods int32
+	FailedPods        int32
+	ActiveAgents      int32
+	QueuedJobs        int32
+
+	// The min number of free agents, after the schedules, the forecast and the scale to zero delay
+	Min  int32
+	Max  int32
+	Time time.Time
+}
+
+// ScalingPolicy computes the desired number of replicas of an agent pool workload, and the reason for it.
+// The desired replicas are then limited by the max, the scale down limits, and the active agents.
+type ScalingPolicy interface {
+	DesiredReplicas(observation Observation) (int32, string)
+}
+
+// MakeScalingPolicy returns the scaling policy selected by the args
+func MakeScalingPolicy(policy args.PolicyArgs) (ScalingPolicy, error) {
+	if policy.Type == "" || strings.EqualFold(policy.Type, "Default") {
+		return DefaultPolicy{}, nil
+	} else if strings.EqualFold(policy.Type, "FixedBuffer") {
+		return FixedBufferPolicy{Buffer: policy.Buffer}, nil
+	} else if strings.EqualFold(policy.Type, "Step") {
+		return StepPolicy{Step: policy.Step}, nil
+	} else if strings.EqualFold(policy.Type, "PercentageBuffer") {
+		return PercentageBufferPolicy{Percent: policy.Percent, Floor: policy.Floor, Ceiling: policy.Ceiling}, nil
+	}
+	return nil, fmt.Errorf("Error - unknown scaling policy %s", policy.Type)
+}
+
+// DefaultPolicy keeps an agent for each active agent and queued job, plus the min number of free agents
+type DefaultPolicy struct{}
+
+// DesiredReplicas returns the active agents, queued jobs and min free agents
+func (p DefaultPolicy) DesiredReplicas(observation Observation) (int32, string) {
+	return observation.ActiveAgents + observation.QueuedJobs + observation.Min,
+		fmt.Sprintf("%d active agents, %d queued jobs and %d free agents", observation.ActiveAgents, observation.QueuedJobs, observation.Min)
+}
+
+// FixedBufferPolicy keeps a buffer of free agents while there are active agents or queued jobs,
+// so that bursts of jobs don't wait for pods. The min number of free agents is kept while idle.
+type FixedBufferPolicy struct {
+	Buffer int32
+}
+
+// DesiredReplicas returns the active agents and queued jobs, plus the buffer if they aren't 0
+func (p FixedBufferPolicy) DesiredReplicas(observation Observation) (int32, string) {
+	demand := observation.ActiveAgents + observation.QueuedJobs
+	if demand == 0 {
+		return observation.Min, fmt.Sprintf("no active agents or queued jobs, and %d free agents", observation.Min)
+	}
+	free := math.MaxInt32(observation.Min, p.Buffer)
+	return demand + free, fmt.Sprintf("%d active agents, %d queued jobs and a buffer of %d free agents", observation.ActiveAgents, observation.QueuedJobs, free)
+}
+
+// StepPolicy scales in steps of replicas, ex. to match the number of agents that fit on a node
+type StepPolicy struct {
+	Step int32
+}
+
+// DesiredReplicas returns the active agents, queued jobs and min free agents, rounded up to the step
+func (p StepPolicy) DesiredReplicas(observation Observation) (int32, string) {
+	desired, reason := DefaultPolicy{}.DesiredReplicas(observation)
+	if p.Step <= 1 || desired%p.Step == 0 {
+		return desired, reason
+	}
+	return (desired/p.Step + 1) * p.Step, fmt.Sprintf("%s, rounded up to a step of %d", reason, p.Step)
+}
+
+// PercentageBufferPolicy keeps a percentage of the active agents as free agents, within a floor and a ceiling,
+// so that the spare capacity grows with the load. The min number of free agents is kept while idle.
+type PercentageBufferPolicy struct {
+	Percent int32
+	Floor   int32
+	Ceiling int32
+}
+
+// DesiredReplicas returns the active agents and queued jobs, plus the percentage of the active agents if they aren't 0
+func (p PercentageBufferPolicy) DesiredReplicas(observation Observation) (int32, string) {
+	demand := observation.ActiveAgents + observation.QueuedJobs
+	if demand == 0 {
+		return observation.Min, fmt.Sprintf("no active agents or queued jobs, and %d free agents", observation.Min)
+	}
+	free := p.Buffer(observation.ActiveAgents)
+	if free < observation.Min {
+		free = observation.Min
+	}
+	return demand + free, fmt.Sprintf("%d active agents, %d queued jobs and a buffer of %d free agents (%d%%)", observation.ActiveAgents, observation.QueuedJobs, free, p.Percent)
+}
+
+// Buffer returns the percentage of the active agents, rounded up, within the floor and the ceiling
+func (p PercentageBufferPolicy) Buffer(activeAgents int32) int32 {
+	buffer := (activeAgents*p.Percent + 99) / 100
+	if buffer < p.Floor {
+		buffer = p.Floor
+	}
+	if p.Ceiling > 0 && buffer > p.Ceiling {
+		buffer = p.Ceiling
+	}
+	return buffer
+}
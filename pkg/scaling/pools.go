@@ -0,0 +1,39 @@
+package scaling
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/azuredevops"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/logging"
+)
+
+// PoolNameEnvVar is the environment variable of the agents that has the agent pool name
+const PoolNameEnvVar = "AZP_POOL"
+
+// GetAgentPoolID finds the agent pool of a workload, returning its ID and name.
+// If poolName is empty, the pool name is taken from the AZP_POOL environment variable of the workload.
+func GetAgentPoolID(k8sClient kubernetes.ClientAsync, agentPools []azuredevops.PoolDetails, workload *kubernetes.Workload, poolName string) (int, string, error) {
+	if poolName == "" {
+		var err error
+		poolName, err = k8sClient.Sync().GetEnvValue(workload.PodTemplateSpec.Spec, workload.Namespace, PoolNameEnvVar)
+		if err != nil {
+			err = fmt.Errorf("Could not retrieve environment variable %s from %s: %s", PoolNameEnvVar, workload.FriendlyName, err.Error())
+			k8sClient.Sync().RecordEvent(workload, corev1.EventTypeWarning, kubernetes.EventReasonFailedGetAgentPool, err.Error())
+			return 0, "", err
+		}
+		logging.Logger.Debugf("Found agent pool %s from %s", poolName, workload.FriendlyName)
+	}
+
+	for _, agentPool := range agentPools {
+		if !agentPool.IsHosted && agentPool.Name == poolName {
+			logging.Logger.Debugf("Agent pool %s has ID %d", poolName, agentPool.ID)
+			return agentPool.ID, poolName, nil
+		}
+	}
+	err := fmt.Errorf("Error - could not find an agent pool with name %s", poolName)
+	k8sClient.Sync().RecordEvent(workload, corev1.EventTypeWarning, kubernetes.EventReasonFailedGetAgentPool, err.Error())
+	return 0, poolName, err
+}
@@ -0,0 +1,164 @@
+package scaling
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/azuredevops"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/collections"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/logging"
+)
+
+// WorkloadRefresher keeps the spec and the agent pool of a workload up to date while it's autoscaled.
+// The workload is watched, so re-reading it doesn't call the API server.
+type WorkloadRefresher struct {
+	azdClient azuredevops.ClientAsync
+	k8sClient kubernetes.ClientAsync
+	args      args.Args
+	stop      <-chan struct{}
+
+	// Workload is the latest spec of the workload
+	Workload *kubernetes.Workload
+	// AgentPoolID is the ID of the agent pool of the workload
+	AgentPoolID int
+	// PodsChanged receives when the pods of the workload change
+	PodsChanged <-chan struct{}
+
+	lastRefresh  time.Time
+	restartWatch chan struct{}
+}
+
+// MakeWorkloadRefresher starts watching a workload until stop is closed
+func MakeWorkloadRefresher(azdClient azuredevops.ClientAsync, k8sClient kubernetes.ClientAsync, args args.Args, workload *kubernetes.Workload, agentPoolID int, stop <-chan struct{}) (*WorkloadRefresher, error) {
+	r := &WorkloadRefresher{
+		azdClient:   azdClient,
+		k8sClient:   k8sClient,
+		args:        args,
+		stop:        stop,
+		Workload:    workload,
+		AgentPoolID: agentPoolID,
+		lastRefresh: time.Now(),
+	}
+	if err := r.watch(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// watch watches the workload and its pods until stop is closed or the watch is restarted
+func (r *WorkloadRefresher) watch() error {
+	restart := make(chan struct{})
+	watchStop := make(chan struct{})
+	go func() {
+		select {
+		case <-r.stop:
+		case <-restart:
+		}
+		close(watchStop)
+	}()
+	r.restartWatch = restart
+
+	podsChanged, err := r.k8sClient.Sync().WatchWorkload(r.Workload, strings.EqualFold(r.args.Mode, "Jobs"), watchStop)
+	if err != nil {
+		return fmt.Errorf("Error watching %s: %w", r.Workload.FriendlyName, err)
+	}
+	r.PodsChanged = podsChanged
+	return nil
+}
+
+// Refresh re-reads the workload. The agent pool is re-resolved when the pod template or selector of the workload changed,
+// or every refresh interval, since the agent pool name may come from a ConfigMap or Secret.
+func (r *WorkloadRefresher) Refresh(now time.Time) error {
+	workload, err := r.k8sClient.Sync().GetWorkload(r.args.Kubernetes)
+	if err != nil {
+		return fmt.Errorf("Error retrieving %s in namespace %s: %w", r.args.Kubernetes.FriendlyName(), r.args.Kubernetes.Namespace, err)
+	}
+	previous := r.Workload
+	r.Workload = workload
+
+	// The pods are watched by their label selector
+	if metav1.FormatLabelSelector(workload.PodSelector) != metav1.FormatLabelSelector(previous.PodSelector) && !strings.EqualFold(r.args.Mode, "Jobs") {
+		logging.Logger.Infof("The pod selector of %s changed, restarting the watch", workload.FriendlyName)
+		close(r.restartWatch)
+		if err := r.watch(); err != nil {
+			return err
+		}
+	}
+
+	// Scaling the workload changes its generation, so compare its pod template and selector instead
+	specChanged := workload.SpecHash() != previous.SpecHash()
+	if !specChanged && (r.args.RefreshInterval == 0 || now.Sub(r.lastRefresh) < r.args.RefreshInterval) {
+		return nil
+	}
+	r.lastRefresh = now
+
+	agentPoolsChan := make(chan azuredevops.PoolDetailsResponse)
+	go r.azdClient.ListPoolsAsync(agentPoolsChan)
+	agentPools := <-agentPoolsChan
+	if agentPools.Err != nil {
+		return fmt.Errorf("Error retrieving agent pools: %w", agentPools.Err)
+	}
+
+	// Keep scaling the current agent pool until the agent pool can be resolved again
+	agentPoolID, poolName, err := GetAgentPoolID(r.k8sClient, agentPools.Pools, workload, r.args.PoolName)
+	if err != nil {
+		logging.Logger.Warnf("Error re-resolving the agent pool of %s, keeping agent pool %d: %s", workload.FriendlyName, r.AgentPoolID, err.Error())
+		return nil
+	}
+	if agentPoolID != r.AgentPoolID {
+		switchAgentPool(r.k8sClient, workload, r.AgentPoolID, agentPoolID, poolName)
+		r.AgentPoolID = agentPoolID
+	}
+	return nil
+}
+
+// switchAgentPool switches the agent pool of a workload between iterations.
+// The agents of the previous agent pool are kept while they run jobs, and the metrics of the previous agent pool are removed.
+func switchAgentPool(k8sClient kubernetes.ClientAsync, workload *kubernetes.Workload, from int, to int, poolName string) {
+	message := fmt.Sprintf("Switched from agent pool %d to agent pool %s (%d)", from, poolName, to)
+	logging.Logger.Infof("%s for %s", message, workload.FriendlyName)
+	k8sClient.Sync().RecordEvent(workload, corev1.EventTypeNormal, kubernetes.EventReasonAgentPoolChanged, message)
+
+	state := getWorkloadState(workload)
+	state.lock.Lock()
+	state.previousPoolID = from
+	state.lock.Unlock()
+
+	labels := poolLabels(from, workload)
+	for _, gauge := range poolGauges {
+		gauge.Delete(labels)
+	}
+}
+
+// getPreviousPoolActiveAgents returns the agents of the previous agent pool of a workload that are still running jobs.
+// The previous agent pool is forgotten once none of its agents are running jobs.
+func getPreviousPoolActiveAgents(azdClient azuredevops.ClientAsync, workload *kubernetes.Workload, state *workloadState, podNames collections.StringSet) ([]azuredevops.AgentDetails, error) {
+	if state.previousPoolID == 0 {
+		return nil, nil
+	}
+
+	agentsChan := make(chan azuredevops.PoolAgentsResponse)
+	go azdClient.ListPoolAgentsAsync(agentsChan, state.previousPoolID)
+	agents := <-agentsChan
+	if agents.Err != nil {
+		return nil, agents.Err
+	}
+
+	activeAgents := []azuredevops.AgentDetails{}
+	for _, agent := range agents.Agents {
+		if strings.EqualFold(agent.Status, "online") && agent.AssignedRequest != nil && podNames.Contains(agent.SystemCapabilities["HOSTNAME"]) {
+			activeAgents = append(activeAgents, agent)
+		}
+	}
+	if len(activeAgents) == 0 {
+		logging.Logger.Infof("No agents of %s are running jobs for agent pool %d anymore", workload.FriendlyName, state.previousPoolID)
+		state.previousPoolID = 0
+	}
+	return activeAgents, nil
+}
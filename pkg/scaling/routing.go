@@ -0,0 +1,129 @@
+package scaling
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/azuredevops"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/collections"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
+)
+
+// routeTTLRates is the number of autoscaling iterations a workload stays routable without being autoscaled
+const routeTTLRates = 3
+
+// workloadRoute is the capabilities of a workload scaling an agent pool, used to route queued jobs between
+// the workloads of the same agent pool
+type workloadRoute struct {
+	agentPoolID  int
+	key          string
+	capabilities azuredevops.Capabilities
+	// Whether the workload has online agents, whose system capabilities complete the capabilities of the pod template
+	agentsOnline bool
+	expires      time.Time
+	// Whether every autoscaled workload had registered its route, so that the routes of the agent pool are complete
+	complete bool
+}
+
+var (
+	// The routes of each agent pool, by agent pool ID and workload key
+	workloadRoutes = make(map[int]map[string]workloadRoute)
+	// The workloads that are autoscaled but haven't registered their route yet, by workload key, until they expire
+	pendingWorkloadRoutes = make(map[string]time.Time)
+	workloadRoutesLock    sync.Mutex
+)
+
+// ExpectWorkloadRoute records that a workload is autoscaled, before its first iteration. Its agent pool isn't known
+// until then, so the queued jobs of every agent pool aren't routed until it registers its route, or it has been
+// pending for 3 times its polling period.
+func ExpectWorkloadRoute(namespace string, friendlyName string, pollInterval time.Duration, now time.Time) {
+	workloadRoutesLock.Lock()
+	defer workloadRoutesLock.Unlock()
+	pendingWorkloadRoutes[namespace+"/"+friendlyName] = now.Add(routeTTLRates * pollInterval)
+}
+
+// ForgetWorkloadRoute stops waiting for the route of a workload that is no longer autoscaled
+func ForgetWorkloadRoute(namespace string, friendlyName string) {
+	workloadRoutesLock.Lock()
+	defer workloadRoutesLock.Unlock()
+	delete(pendingWorkloadRoutes, namespace+"/"+friendlyName)
+}
+
+// registerWorkloadRoute records the capabilities of a workload for an iteration, and returns its route.
+// The capabilities of the pod template are completed by the system capabilities of its online agents.
+func registerWorkloadRoute(agentPoolID int, workload *kubernetes.Workload, agents []azuredevops.AgentDetails, podNames collections.StringSet, pollInterval time.Duration, now time.Time) workloadRoute {
+	capabilities := kubernetes.GetCapabilities(workload.PodTemplateSpec)
+	agentsOnline := false
+	for _, agent := range agents {
+		if strings.EqualFold(agent.Status, "online") && podNames.Contains(agent.SystemCapabilities["HOSTNAME"]) {
+			agentsOnline = true
+			for name, value := range agent.SystemCapabilities {
+				if _, exists := capabilities[name]; !exists || capabilities[name] == nil {
+					agentValue := value
+					capabilities[name] = &agentValue
+				}
+			}
+		}
+	}
+
+	route := workloadRoute{
+		agentPoolID:  agentPoolID,
+		key:          workload.Namespace + "/" + workload.FriendlyName,
+		capabilities: capabilities,
+		agentsOnline: agentsOnline,
+		expires:      now.Add(routeTTLRates * pollInterval),
+	}
+
+	workloadRoutesLock.Lock()
+	defer workloadRoutesLock.Unlock()
+	routes, exists := workloadRoutes[agentPoolID]
+	if !exists {
+		routes = make(map[string]workloadRoute)
+		workloadRoutes[agentPoolID] = routes
+	}
+	for key, other := range routes {
+		if now.After(other.expires) {
+			delete(routes, key)
+		}
+	}
+	routes[route.key] = route
+
+	delete(pendingWorkloadRoutes, route.key)
+	for key, expires := range pendingWorkloadRoutes {
+		if now.After(expires) {
+			delete(pendingWorkloadRoutes, key)
+		}
+	}
+	route.complete = len(pendingWorkloadRoutes) == 0
+	return route
+}
+
+// accepts returns whether the workload should scale for a queued job with the demands, and whether any
+// workload of the agent pool can run the job. If multiple workloads can run the job, the first one by
+// namespace and name does. Workloads without online agents don't know the capabilities their agents will
+// detect, so if no workload is known to satisfy the demands, the first one that may satisfy them does.
+func (r workloadRoute) accepts(demands []string) (bool, bool) {
+	workloadRoutesLock.Lock()
+	defer workloadRoutesLock.Unlock()
+
+	var keys []string
+	for key := range workloadRoutes[r.agentPoolID] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if azuredevops.DemandsSatisfied(demands, workloadRoutes[r.agentPoolID][key].capabilities) {
+			return key == r.key, true
+		}
+	}
+	for _, key := range keys {
+		route := workloadRoutes[r.agentPoolID][key]
+		if !route.agentsOnline && azuredevops.DemandsMaySatisfy(demands, route.capabilities) {
+			return key == r.key, true
+		}
+	}
+	return false, false
+}
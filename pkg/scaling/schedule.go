@@ -0,0 +1,58 @@
+package scaling
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/cron"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/logging"
+)
+
+var (
+	activeScheduleGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "azp_agent_autoscaler_active_schedule",
+		Help: "Whether a schedule rule is the one overriding the min and max (1) or not (0)",
+	}, append([]string{"schedule"}, poolLabelNames...))
+)
+
+// applySchedules returns the args with the min and max overridden by the first schedule rule matching the time
+func applySchedules(args args.Args, labels prometheus.Labels, now time.Time) args.Args {
+	active := false
+	for _, schedule := range args.Schedules {
+		scheduleLabels := prometheus.Labels{"schedule": schedule.Name}
+		for key, value := range labels {
+			scheduleLabels[key] = value
+		}
+
+		if !active && scheduleMatches(schedule, now) {
+			active = true
+			activeScheduleGauge.With(scheduleLabels).Set(1)
+			min, max := schedule.Apply(args.Min, args.Max)
+			logging.Logger.Tracef("Schedule %s is active, using a min of %d and a max of %d", schedule.Name, min, max)
+			args.Min = min
+			args.Max = max
+		} else {
+			activeScheduleGauge.With(scheduleLabels).Set(0)
+		}
+	}
+	return args
+}
+
+// scheduleMatches returns whether a schedule rule's cron expression matches the time in its timezone
+func scheduleMatches(schedule args.ScheduleArgs, now time.Time) bool {
+	// Schedules are validated in args.ValidatePoolArgs()
+	cronSchedule, err := cron.Parse(schedule.Cron)
+	if err != nil {
+		logging.Logger.Warnf("Ignoring schedule %s: %s", schedule.Name, err.Error())
+		return false
+	}
+	location, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		logging.Logger.Warnf("Ignoring schedule %s: %s", schedule.Name, err.Error())
+		return false
+	}
+	return cronSchedule.Matches(now.In(location))
+}
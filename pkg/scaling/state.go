@@ -0,0 +1,204 @@
+package scaling
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/logging"
+)
+
+// The workload annotations that persist the scaling state across restarts and leader changes
+const (
+	lastScaleUpAnnotation    = "azp-agent-autoscaler/last-scale-up"
+	lastScaleDownAnnotation  = "azp-agent-autoscaler/last-scale-down"
+	scaleUpCountAnnotation   = "azp-agent-autoscaler/scale-up-count"
+	scaleDownCountAnnotation = "azp-agent-autoscaler/scale-down-count"
+)
+
+// poolLabelNames are the labels added to the scaling metrics, to tell apart each agent pool workload
+var poolLabelNames = []string{"namespace", "workload", "pool_id"}
+
+// workloadState holds the scaling state of a workload between iterations.
+// The lock is held for a whole iteration, and by everything else reading or changing the state.
+type workloadState struct {
+	lock sync.Mutex
+
+	// The last time the workload had active agents or queued jobs
+	lastBusy time.Time
+
+	lastScaleUp    time.Time
+	lastScaleDown  time.Time
+	scaleUpCount   int64
+	scaleDownCount int64
+	status         WorkloadStatus
+
+	// The history of the scaling behavior
+	recommendations []recommendation
+	scaleEvents     []scaleEvent
+
+	// The agent pool the workload used before its agent pool changed, until its agents finish their jobs
+	previousPoolID int
+
+	// The reason of the condition stopping the workload from scaling, in the current and the previous iteration
+	conditionReason         string
+	previousConditionReason string
+}
+
+// WorkloadStatus is the state observed by the last scaling iteration of a workload
+type WorkloadStatus struct {
+	TotalAgents     int32
+	ActiveAgents    int32
+	QueuedJobs      int32
+	DesiredReplicas int32
+	LastScaleTime   *time.Time
+}
+
+var (
+	workloadStates     = make(map[string]*workloadState)
+	workloadStatesLock sync.Mutex
+)
+
+// getWorkloadState returns the scaling state of a workload.
+// The first time a workload is seen, the state is loaded from its annotations.
+func getWorkloadState(workload *kubernetes.Workload) *workloadState {
+	workloadStatesLock.Lock()
+	defer workloadStatesLock.Unlock()
+
+	key := workload.Namespace + "/" + workload.FriendlyName
+	state, exists := workloadStates[key]
+	if !exists {
+		state = loadWorkloadState(workload)
+		workloadStates[key] = state
+	}
+	return state
+}
+
+// loadWorkloadState reads the scaling state persisted in the annotations of a workload
+func loadWorkloadState(workload *kubernetes.Workload) *workloadState {
+	state := &workloadState{
+		// Wait for the scale to zero delay after starting
+		lastBusy:      time.Now(),
+		lastScaleUp:   time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC),
+		lastScaleDown: time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC),
+	}
+	annotations := workload.GetAnnotations()
+	if value, exists := annotations[lastScaleUpAnnotation]; exists {
+		if lastScaleUp, err := time.Parse(time.RFC3339, value); err != nil {
+			logging.Logger.Warnf("Ignoring annotation %s of %s: %s", lastScaleUpAnnotation, workload.FriendlyName, err.Error())
+		} else {
+			state.lastScaleUp = lastScaleUp
+		}
+	}
+	if value, exists := annotations[lastScaleDownAnnotation]; exists {
+		if lastScaleDown, err := time.Parse(time.RFC3339, value); err != nil {
+			logging.Logger.Warnf("Ignoring annotation %s of %s: %s", lastScaleDownAnnotation, workload.FriendlyName, err.Error())
+		} else {
+			state.lastScaleDown = lastScaleDown
+		}
+	}
+	if value, exists := annotations[scaleUpCountAnnotation]; exists {
+		if count, err := strconv.ParseInt(value, 10, 64); err != nil {
+			logging.Logger.Warnf("Ignoring annotation %s of %s: %s", scaleUpCountAnnotation, workload.FriendlyName, err.Error())
+		} else {
+			state.scaleUpCount = count
+		}
+	}
+	if value, exists := annotations[scaleDownCountAnnotation]; exists {
+		if count, err := strconv.ParseInt(value, 10, 64); err != nil {
+			logging.Logger.Warnf("Ignoring annotation %s of %s: %s", scaleDownCountAnnotation, workload.FriendlyName, err.Error())
+		} else {
+			state.scaleDownCount = count
+		}
+	}
+
+	var lastScaleTime time.Time
+	if state.lastScaleUp.After(state.lastScaleDown) {
+		lastScaleTime = state.lastScaleUp
+	} else {
+		lastScaleTime = state.lastScaleDown
+	}
+	if lastScaleTime.Year() > 1970 {
+		state.status.LastScaleTime = &lastScaleTime
+	}
+	return state
+}
+
+// recordScale updates the scaling state of a workload after a scale, and persists it in the workload's annotations
+func recordScale(k8sClient kubernetes.ClientAsync, workload *kubernetes.Workload, state *workloadState, change int32, now time.Time) {
+	state.status.LastScaleTime = &now
+	state.scaleEvents = append(state.scaleEvents, scaleEvent{change, now})
+	scaledUp := change > 0
+
+	timestamp := now.UTC().Format(time.RFC3339)
+	annotations := make(map[string]*string)
+	if scaledUp {
+		state.lastScaleUp = now
+		state.scaleUpCount = state.scaleUpCount + 1
+		count := strconv.FormatInt(state.scaleUpCount, 10)
+		annotations[lastScaleUpAnnotation] = &timestamp
+		annotations[scaleUpCountAnnotation] = &count
+	} else {
+		state.lastScaleDown = now
+		state.scaleDownCount = state.scaleDownCount + 1
+		count := strconv.FormatInt(state.scaleDownCount, 10)
+		annotations[lastScaleDownAnnotation] = &timestamp
+		annotations[scaleDownCountAnnotation] = &count
+	}
+
+	// The scale already happened, so only warn if the state can't be persisted
+	if err := k8sClient.Sync().AnnotateWorkload(workload, annotations); err != nil {
+		logging.Logger.Warnf("Error persisting the scaling state of %s: %s", workload.FriendlyName, err.Error())
+	}
+}
+
+// GetWorkloadStatus returns the state observed by the last scaling iteration of a workload
+func GetWorkloadStatus(workload *kubernetes.Workload) WorkloadStatus {
+	state := getWorkloadState(workload)
+	state.lock.Lock()
+	defer state.lock.Unlock()
+	return state.status
+}
+
+// poolLabels returns the metric labels of an agent pool workload
+func poolLabels(agentPoolID int, workload *kubernetes.Workload) prometheus.Labels {
+	return prometheus.Labels{
+		"namespace": workload.Namespace,
+		"workload":  workload.FriendlyName,
+		"pool_id":   strconv.Itoa(agentPoolID),
+	}
+}
+
+// startIteration resets the state tracked for a single iteration
+func (state *workloadState) startIteration() {
+	state.previousConditionReason, state.conditionReason = state.conditionReason, ""
+}
+
+// recordCondition records an Event for a condition stopping the workload from scaling. Iterations keep seeing the
+// condition while it holds, so the Event is only recorded when the condition starts or its reason changes.
+func recordCondition(k8sClient kubernetes.ClientAsync, workload *kubernetes.Workload, state *workloadState, eventType string, reason string, message string) {
+	state.conditionReason = reason
+	if reason == state.previousConditionReason {
+		return
+	}
+	k8sClient.Sync().RecordEvent(workload, eventType, reason, message)
+}
+
+// getMinAfterIdle returns the min number of free agents. With a min of 0, 1 agent is kept
+// until the workload has had no active agents or queued jobs for the scale to zero delay.
+func getMinAfterIdle(state *workloadState, busy bool, args args.Args, now time.Time) int32 {
+	if busy {
+		state.lastBusy = now
+	}
+	if args.Min > 0 {
+		return args.Min
+	}
+	if now.Before(state.lastBusy.Add(args.ScaleDown.ToZeroDelay)) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,88 @@
+package scaling
+
+import (
+	"sync"
+	"time"
+)
+
+// PoolTriggers wakes up the autoscaling loops of agent pools, ex. when Azure Devops notifies that a job was queued
+type PoolTriggers struct {
+	// A channel per agent pool, closed and replaced when the agent pool is triggered
+	channels map[int]chan struct{}
+	lock     sync.Mutex
+}
+
+// MakePoolTriggers returns a PoolTriggers
+func MakePoolTriggers() *PoolTriggers {
+	return &PoolTriggers{channels: make(map[int]chan struct{})}
+}
+
+// Triggered returns a channel that is closed the next time an agent pool is triggered.
+// It should be called before an iteration starts, so that triggers during the iteration aren't missed.
+func (t *PoolTriggers) Triggered(agentPoolID int) <-chan struct{} {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	channel, exists := t.channels[agentPoolID]
+	if !exists {
+		channel = make(chan struct{})
+		t.channels[agentPoolID] = channel
+	}
+	return channel
+}
+
+// Trigger wakes up the autoscaling loops of an agent pool
+func (t *PoolTriggers) Trigger(agentPoolID int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if channel, exists := t.channels[agentPoolID]; exists {
+		close(channel)
+		delete(t.channels, agentPoolID)
+	}
+}
+
+// TriggerAll wakes up the autoscaling loops of every agent pool
+func (t *PoolTriggers) TriggerAll() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for agentPoolID, channel := range t.channels {
+		close(channel)
+		delete(t.channels, agentPoolID)
+	}
+}
+
+var poolTriggers = MakePoolTriggers()
+
+// GetPoolTriggers returns the triggers of the autoscaling loops of every agent pool
+func GetPoolTriggers() *PoolTriggers {
+	return poolTriggers
+}
+
+// WaitForNextIteration waits until the next autoscaling iteration of an agent pool, and returns false if stop was closed.
+// The next iteration starts after timeToSleep, or early when the pods change or the agent pool is notified.
+// Early iterations don't start less than minInterval after the start of the last iteration,
+// so that a burst of pod changes and notifications only starts one iteration.
+func WaitForNextIteration(stop <-chan struct{}, podsChanged <-chan struct{}, notified <-chan struct{}, lastIteration time.Time, timeToSleep time.Duration, minInterval time.Duration) bool {
+	deadline := time.Now().Add(timeToSleep)
+	select {
+	case <-stop:
+		return false
+	case <-time.After(timeToSleep):
+		return true
+	case <-podsChanged:
+	case <-notified:
+	}
+
+	// Later triggers are coalesced into this iteration
+	earliest := lastIteration.Add(minInterval)
+	if earliest.After(deadline) {
+		earliest = deadline
+	}
+	if wait := time.Until(earliest); wait > 0 {
+		select {
+		case <-stop:
+			return false
+		case <-time.After(wait):
+		}
+	}
+	return true
+}
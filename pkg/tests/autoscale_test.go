@@ -2,9 +2,15 @@ package tests
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
 	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
 	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
 	"github.com/ogmaresca/azp-agent-autoscaler/pkg/math"
@@ -145,3 +151,695 @@ func TestAutoscalePodNames(t *testing.T) {
 		}
 	})
 }
+
+func TestAutoscaleDeploymentPodAge(t *testing.T) {
+	t.Run("verify_pod_age_scale_down_limit", func(t *testing.T) {
+		azdClient := mockAZDClient{
+			NumPools:         5,
+			ErrorListPools:   false,
+			NumFreeAgents:    10,
+			NumRunningAgents: 1,
+			ErrorAgents:      false,
+			NumQueuedJobs:    0,
+			ErrorJobs:        false,
+			FreeAgentsFirst:  true,
+		}
+
+		args := args.Args{
+			Min:  1,
+			Max:  100,
+			Rate: 10 * time.Second,
+			ScaleDown: args.ScaleDownArgs{
+				Delay: 0 * time.Nanosecond,
+				Max:   100,
+			},
+			Kubernetes: args.KubernetesArgs{
+				Type:      "Deployment",
+				Name:      "azp-agent",
+				Namespace: "default",
+			},
+			AZD: args.AzureDevopsArgs{
+				Token: "azdtoken",
+				URL:   "https://dev.azure.com/organization",
+			},
+		}
+
+		expectedPodCount := azdClient.NumFreeAgents + azdClient.NumRunningAgents
+
+		k8sClient := mockK8sClient{
+			Counts: &mockK8sClientCounts{
+				NumPods: expectedPodCount,
+			},
+			HPAExists: false,
+		}
+
+		err := scaling.Autoscale(azdClient, agentPoolID, kubernetes.MakeFromClient(k8sClient), k8sClient.GetWorkloadNoError(args.Kubernetes), args)
+		if err != nil {
+			t.Error(err.Error())
+		}
+
+		if k8sClient.Counts.NumPods != expectedPodCount {
+			t.Fatalf("Expected %d pods (no scale down), but got %d", expectedPodCount, k8sClient.Counts.NumPods)
+		}
+	})
+}
+
+func TestAutoscaleIdlePodRemoval(t *testing.T) {
+	for _, strategy := range []string{"DeletionCost", "Delete"} {
+		t.Run(fmt.Sprintf("verify_%s_only_removes_idle_agents", strings.ToLower(strategy)), func(t *testing.T) {
+			azdClient := mockAZDClient{
+				NumPools:         5,
+				ErrorListPools:   false,
+				NumFreeAgents:    10,
+				NumRunningAgents: 1,
+				ErrorAgents:      false,
+				NumQueuedJobs:    0,
+				ErrorJobs:        false,
+				FreeAgentsFirst:  true,
+			}
+
+			args := args.Args{
+				Min:  1,
+				Max:  100,
+				Rate: 10 * time.Second,
+				ScaleDown: args.ScaleDownArgs{
+					Delay:    0 * time.Nanosecond,
+					Max:      100,
+					Strategy: strategy,
+				},
+				Kubernetes: args.KubernetesArgs{
+					Type:      "Deployment",
+					Name:      "azp-agent",
+					Namespace: "default",
+				},
+				AZD: args.AzureDevopsArgs{
+					Token: "azdtoken",
+					URL:   "https://dev.azure.com/organization",
+				},
+			}
+
+			k8sClient := mockK8sClient{
+				Counts: &mockK8sClientCounts{
+					NumPods: azdClient.NumFreeAgents + azdClient.NumRunningAgents,
+				},
+				HPAExists: false,
+			}
+
+			err := scaling.Autoscale(azdClient, agentPoolID, kubernetes.MakeFromClient(k8sClient), k8sClient.GetWorkloadNoError(args.Kubernetes), args)
+			if err != nil {
+				t.Error(err.Error())
+			}
+
+			expectedPodCount := azdClient.NumRunningAgents + args.Min
+			if k8sClient.Counts.NumPods != expectedPodCount {
+				t.Fatalf("Expected %d pods, but got %d", expectedPodCount, k8sClient.Counts.NumPods)
+			}
+
+			removedPods := k8sClient.Counts.AnnotatedPods
+			if strategy == "Delete" {
+				removedPods = k8sClient.Counts.DeletedPods
+			}
+			if int32(len(removedPods)) != azdClient.NumFreeAgents+azdClient.NumRunningAgents-expectedPodCount {
+				t.Fatalf("Expected %d pods to be removed, but got %v", azdClient.NumFreeAgents+azdClient.NumRunningAgents-expectedPodCount, removedPods)
+			}
+			for _, podName := range removedPods {
+				if podName == fmt.Sprintf("azp-agent-%d", azdClient.NumFreeAgents) {
+					t.Fatalf("The active agent pod %s was removed", podName)
+				}
+			}
+		})
+	}
+}
+
+func TestAutoscaleClearsActivePodDeletionCost(t *testing.T) {
+	for _, test := range []struct {
+		name          string
+		min           int32
+		expectedScale string
+	}{
+		{"before_scale_down", 1, "scale 2"},
+		{"without_scale_down", 11, "scale 12"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			azdClient := mockAZDClient{
+				NumPools:         5,
+				NumFreeAgents:    10,
+				NumRunningAgents: 1,
+				FreeAgentsFirst:  true,
+			}
+
+			args := args.Args{
+				Min:  test.min,
+				Max:  100,
+				Rate: 10 * time.Second,
+				ScaleDown: args.ScaleDownArgs{
+					Delay:    0 * time.Nanosecond,
+					Max:      100,
+					Strategy: "DeletionCost",
+				},
+				Kubernetes: args.KubernetesArgs{
+					Type:      "Deployment",
+					Name:      "azp-agent",
+					Namespace: "deletion-cost-" + test.name,
+				},
+			}
+
+			// The active agent was marked as idle by a scale down that didn't happen
+			activePod := fmt.Sprintf("azp-agent-%d", azdClient.NumFreeAgents)
+			k8sClient := mockK8sClient{
+				Counts: &mockK8sClientCounts{
+					NumPods: azdClient.NumFreeAgents + azdClient.NumRunningAgents,
+					PodAnnotations: map[string]map[string]string{
+						activePod: {"controller.kubernetes.io/pod-deletion-cost": "-1000"},
+					},
+				},
+			}
+
+			err := scaling.Autoscale(azdClient, agentPoolID, kubernetes.MakeFromClient(k8sClient), k8sClient.GetWorkloadNoError(args.Kubernetes), args)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if _, marked := k8sClient.Counts.PodAnnotations[activePod]["controller.kubernetes.io/pod-deletion-cost"]; marked {
+				t.Fatalf("Expected the deletion cost of the active agent pod %s to be removed", activePod)
+			}
+			// The active agent is unmarked before the replicas are lowered
+			clearedAt, scaledAt := -1, -1
+			for i, operation := range k8sClient.Counts.Operations {
+				if operation == "annotate "+activePod && clearedAt < 0 {
+					clearedAt = i
+				} else if strings.HasPrefix(operation, "scale ") {
+					scaledAt = i
+					if operation != test.expectedScale {
+						t.Fatalf("Expected %s, but got %s", test.expectedScale, operation)
+					}
+				}
+			}
+			if clearedAt < 0 {
+				t.Fatalf("Expected the active agent pod %s to be annotated, but got %v", activePod, k8sClient.Counts.Operations)
+			} else if scaledAt < clearedAt {
+				t.Fatalf("Expected the active agent pod %s to be unmarked before the scale, but got %v", activePod, k8sClient.Counts.Operations)
+			}
+		})
+	}
+}
+
+func TestAutoscaleJobs(t *testing.T) {
+	finishedJob := batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "azp-agent-finished",
+		},
+		Status: batchv1.JobStatus{
+			Conditions: []batchv1.JobCondition{{
+				Type:   batchv1.JobComplete,
+				Status: corev1.ConditionTrue,
+			}},
+		},
+	}
+
+	for _, test := range []struct {
+		name         string
+		existingJobs []batchv1.Job
+		queuedJobs   int32
+		max          int32
+		expectedJobs int
+	}{
+		{"create_job_per_queued_job", nil, 3, 10, 4},
+		{"cap_jobs_at_max", nil, 3, 2, 2},
+		{"delete_finished_jobs", []batchv1.Job{finishedJob}, 3, 10, 4},
+		{"keep_min_free_jobs", nil, 0, 10, 1},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			azdClient := mockAZDClient{
+				NumPools:         5,
+				ErrorListPools:   false,
+				NumFreeAgents:    0,
+				NumRunningAgents: 0,
+				ErrorAgents:      false,
+				NumQueuedJobs:    test.queuedJobs,
+				ErrorJobs:        false,
+				FreeAgentsFirst:  false,
+			}
+
+			args := args.Args{
+				Min:  1,
+				Max:  test.max,
+				Rate: 10 * time.Second,
+				Mode: "Jobs",
+				Kubernetes: args.KubernetesArgs{
+					Type:      "StatefulSet",
+					Name:      "azp-agent",
+					Namespace: "default",
+				},
+			}
+
+			k8sClient := mockK8sClient{
+				Counts: &mockK8sClientCounts{
+					Jobs: test.existingJobs,
+				},
+				HPAExists: false,
+			}
+
+			workload := k8sClient.GetWorkloadNoError(args.Kubernetes)
+			workload.PodTemplateSpec.Labels["azure.workload.identity/use"] = "true"
+			err := scaling.Autoscale(azdClient, agentPoolID, kubernetes.MakeFromClient(k8sClient), workload, args)
+			if err != nil {
+				t.Error(err.Error())
+			}
+
+			if len(k8sClient.Counts.Jobs) != test.expectedJobs {
+				t.Fatalf("Expected %d jobs, but got %d", test.expectedJobs, len(k8sClient.Counts.Jobs))
+			}
+			for _, job := range k8sClient.Counts.Jobs {
+				if kubernetes.IsJobFinished(job) {
+					t.Fatalf("Finished job %s was not deleted", job.Name)
+				}
+				if job.Spec.Template.Labels[kubernetes.AgentJobLabel] != args.Kubernetes.Name {
+					t.Fatalf("Job %s pods are missing the %s label", job.Name, kubernetes.AgentJobLabel)
+				}
+				// The Job pods must not be counted as pods of the workload
+				workloadSelector, err := metav1.LabelSelectorAsSelector(workload.PodSelector)
+				if err != nil {
+					t.Fatal(err.Error())
+				}
+				if workloadSelector.Matches(labels.Set(job.Spec.Template.Labels)) {
+					t.Fatalf("Job %s pods match the selector %s of the workload", job.Name, workloadSelector.String())
+				}
+				// The other labels of the pod template are kept
+				if job.Spec.Template.Labels["azure.workload.identity/use"] != "true" {
+					t.Fatalf("Job %s pods are missing the azure.workload.identity/use label", job.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestAutoscalePersistedScaleDown(t *testing.T) {
+	tests := []struct {
+		name                   string
+		lastScaleDown          time.Duration
+		expectedPodCount       int32
+		expectedScaleDownCount string
+	}{
+		{"azp-agent-persisted-cooldown", 1 * time.Minute, 10, "4"},
+		{"azp-agent-persisted-expired", 2 * time.Hour, 9, "5"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			azdClient := mockAZDClient{
+				NumPools:         5,
+				ErrorListPools:   false,
+				NumFreeAgents:    10,
+				NumRunningAgents: 0,
+				ErrorAgents:      false,
+				NumQueuedJobs:    0,
+				ErrorJobs:        false,
+				FreeAgentsFirst:  false,
+			}
+
+			args := args.Args{
+				Min:  1,
+				Max:  100,
+				Rate: 10 * time.Second,
+				ScaleDown: args.ScaleDownArgs{
+					Delay: 1 * time.Hour,
+					Max:   1,
+				},
+				Kubernetes: args.KubernetesArgs{
+					Type:      "StatefulSet",
+					Name:      test.name,
+					Namespace: "default",
+				},
+				AZD: args.AzureDevopsArgs{
+					Token: "azdtoken",
+					URL:   "https://dev.azure.com/organization",
+				},
+			}
+
+			lastScaleDown := time.Now().Add(-test.lastScaleDown).UTC().Format(time.RFC3339)
+			k8sClient := mockK8sClient{
+				Counts: &mockK8sClientCounts{
+					NumPods: azdClient.NumFreeAgents,
+					Annotations: map[string]string{
+						"azp-agent-autoscaler/last-scale-down":  lastScaleDown,
+						"azp-agent-autoscaler/scale-down-count": "4",
+					},
+				},
+				HPAExists: false,
+			}
+
+			// Simulate a restart by loading the scaling state from the workload
+			workload := k8sClient.GetWorkloadNoError(args.Kubernetes)
+			workload.Annotations = map[string]string{}
+			for key, value := range k8sClient.Counts.Annotations {
+				workload.Annotations[key] = value
+			}
+
+			err := scaling.Autoscale(azdClient, agentPoolID, kubernetes.MakeFromClient(k8sClient), workload, args)
+			if err != nil {
+				t.Error(err.Error())
+			}
+
+			if k8sClient.Counts.NumPods != test.expectedPodCount {
+				t.Fatalf("Expected %d pods, but got %d", test.expectedPodCount, k8sClient.Counts.NumPods)
+			}
+			if scaleDownCount := k8sClient.Counts.Annotations["azp-agent-autoscaler/scale-down-count"]; scaleDownCount != test.expectedScaleDownCount {
+				t.Fatalf("Expected a scale down count of %s, but got %s", test.expectedScaleDownCount, scaleDownCount)
+			}
+			if test.expectedPodCount < azdClient.NumFreeAgents && k8sClient.Counts.Annotations["azp-agent-autoscaler/last-scale-down"] == lastScaleDown {
+				t.Fatal("Expected the last scale down time to be updated")
+			}
+		})
+	}
+}
+
+func TestAutoscaleSchedules(t *testing.T) {
+	scheduleMin := func(min int32) *int32 {
+		return &min
+	}
+	tests := []struct {
+		name             string
+		schedules        []args.ScheduleArgs
+		expectedPodCount int32
+	}{
+		{"no_schedules", nil, 2},
+		{"inactive_schedule", []args.ScheduleArgs{{Name: "never", Cron: "* * 31 2 *", Min: scheduleMin(10)}}, 2},
+		{"active_schedule", []args.ScheduleArgs{{Name: "always", Cron: "* * * * *", Timezone: "America/Toronto", Min: scheduleMin(5)}}, 6},
+		{"first_active_schedule", []args.ScheduleArgs{
+			{Name: "never", Cron: "* * 31 2 *", Min: scheduleMin(10)},
+			{Name: "always", Cron: "* * * * *", Min: scheduleMin(3)},
+			{Name: "always-too", Cron: "* * * * *", Min: scheduleMin(7)},
+		}, 4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			azdClient := mockAZDClient{
+				NumPools:         5,
+				ErrorListPools:   false,
+				NumFreeAgents:    1,
+				NumRunningAgents: 1,
+				ErrorAgents:      false,
+				NumQueuedJobs:    0,
+				ErrorJobs:        false,
+				FreeAgentsFirst:  false,
+			}
+
+			args := args.Args{
+				Min:  1,
+				Max:  100,
+				Rate: 10 * time.Second,
+				ScaleDown: args.ScaleDownArgs{
+					Delay: 0 * time.Nanosecond,
+					Max:   1,
+				},
+				Kubernetes: args.KubernetesArgs{
+					Type:      "StatefulSet",
+					Name:      "azp-agent",
+					Namespace: "default",
+				},
+				AZD: args.AzureDevopsArgs{
+					Token: "azdtoken",
+					URL:   "https://dev.azure.com/organization",
+				},
+				Schedules: test.schedules,
+			}
+
+			k8sClient := mockK8sClient{
+				Counts: &mockK8sClientCounts{
+					NumPods: 2,
+				},
+				HPAExists: false,
+			}
+
+			err := scaling.Autoscale(azdClient, agentPoolID, kubernetes.MakeFromClient(k8sClient), k8sClient.GetWorkloadNoError(args.Kubernetes), args)
+			if err != nil {
+				t.Error(err.Error())
+			}
+
+			if k8sClient.Counts.NumPods != test.expectedPodCount {
+				t.Fatalf("Expected %d pods, but got %d", test.expectedPodCount, k8sClient.Counts.NumPods)
+			}
+		})
+	}
+}
+
+func TestAutoscaleToZero(t *testing.T) {
+	tests := []struct {
+		name             string
+		numPods          int32
+		numQueuedJobs    int32
+		demands          []string
+		capabilities     map[string]string
+		toZeroDelay      time.Duration
+		expectedPodCount int32
+	}{
+		{"scale_to_zero", 3, 0, nil, nil, 0, 0},
+		{"idle_delay", 3, 0, nil, nil, 1 * time.Hour, 1},
+		{"wake_on_queued_job", 0, 2, nil, nil, 0, 2},
+		{"wake_on_queued_job_with_idle_delay", 0, 2, nil, nil, 1 * time.Hour, 3},
+		{"wake_on_satisfiable_job", 0, 2, []string{"Agent.Version -gtVersion 2.163.1"}, nil, 0, 2},
+		// The agents may detect maven on their PATH
+		{"wake_on_undeclared_capability", 0, 2, []string{"maven"}, nil, 0, 2},
+		// No agents are online to report docker as a system capability
+		{"wake_on_system_capability_from_zero", 0, 1, []string{"docker"}, nil, 0, 1},
+		{"ignore_unsatisfiable_job", 0, 2, []string{"maven -equals 3"}, map[string]string{"maven": "2"}, 0, 0},
+	}
+
+	for i, test := range tests {
+		// Each workload scales its own agent pool, so queued jobs aren't routed to the workloads of other tests
+		poolID := 100 + i
+		t.Run(test.name, func(t *testing.T) {
+			azdClient := mockAZDClient{
+				NumPools:                5,
+				ErrorListPools:          false,
+				NumFreeAgents:           test.numPods,
+				NumRunningAgents:        0,
+				ErrorAgents:             false,
+				NumQueuedJobs:           test.numQueuedJobs,
+				ErrorJobs:               false,
+				FreeAgentsFirst:         false,
+				QueuedJobsMatchNoAgents: true,
+				QueuedJobDemands:        test.demands,
+			}
+
+			args := args.Args{
+				Min:  0,
+				Max:  10,
+				Rate: 10 * time.Second,
+				ScaleDown: args.ScaleDownArgs{
+					Delay:       0 * time.Nanosecond,
+					Max:         10,
+					ToZeroDelay: test.toZeroDelay,
+				},
+				Kubernetes: args.KubernetesArgs{
+					Type:      "StatefulSet",
+					Name:      "azp-agent-" + strings.Replace(test.name, "_", "-", -1),
+					Namespace: "default",
+				},
+				AZD: args.AzureDevopsArgs{
+					Token: "azdtoken",
+					URL:   "https://dev.azure.com/organization",
+				},
+			}
+
+			k8sClient := mockK8sClient{
+				Counts: &mockK8sClientCounts{
+					NumPods: test.numPods,
+				},
+				HPAExists: false,
+			}
+
+			workload := k8sClient.GetWorkloadNoError(args.Kubernetes)
+			for name, value := range test.capabilities {
+				workload.PodTemplateSpec.Labels[kubernetes.CapabilityLabelPrefix+name] = value
+			}
+
+			err := scaling.Autoscale(azdClient, poolID, kubernetes.MakeFromClient(k8sClient), workload, args)
+			if err != nil {
+				t.Error(err.Error())
+			}
+
+			if k8sClient.Counts.NumPods != test.expectedPodCount {
+				t.Fatalf("Expected %d pods, but got %d", test.expectedPodCount, k8sClient.Counts.NumPods)
+			}
+		})
+	}
+}
+
+func TestAutoscaleFreeAgentOutsideWorkload(t *testing.T) {
+	tests := []struct {
+		name             string
+		numFreeAgents    int32
+		expectedPodCount int32
+	}{
+		// The queued jobs match the free agents azp-agent-0 and azp-agent-1, which run outside of the workload
+		{"free_agents_outside_workload", 2, 1},
+		{"no_online_agents", 0, 3},
+	}
+
+	for i, test := range tests {
+		poolID := 300 + i
+		t.Run(test.name, func(t *testing.T) {
+			azdClient := mockAZDClient{
+				NumPools:                    5,
+				ErrorListPools:              false,
+				NumFreeAgents:               test.numFreeAgents,
+				NumRunningAgents:            0,
+				ErrorAgents:                 false,
+				NumQueuedJobs:               2,
+				ErrorJobs:                   false,
+				FreeAgentsFirst:             false,
+				QueuedJobsMatchListedAgents: true,
+			}
+
+			args := args.Args{
+				Min:  1,
+				Max:  10,
+				Rate: 10 * time.Second,
+				ScaleDown: args.ScaleDownArgs{
+					Delay: 0 * time.Nanosecond,
+					Max:   10,
+				},
+				Kubernetes: args.KubernetesArgs{
+					Type:      "StatefulSet",
+					Name:      "azp-agent-vm-" + strings.Replace(test.name, "_", "-", -1),
+					Namespace: "default",
+				},
+				AZD: args.AzureDevopsArgs{
+					Token: "azdtoken",
+					URL:   "https://dev.azure.com/organization",
+				},
+			}
+
+			k8sClient := mockK8sClient{
+				Counts: &mockK8sClientCounts{
+					NumPods: 1,
+				},
+				HPAExists: false,
+			}
+
+			err := scaling.Autoscale(azdClient, poolID, kubernetes.MakeFromClient(k8sClient), k8sClient.GetWorkloadNoError(args.Kubernetes), args)
+			if err != nil {
+				t.Error(err.Error())
+			}
+
+			if k8sClient.Counts.NumPods != test.expectedPodCount {
+				t.Fatalf("Expected %d pods, but got %d", test.expectedPodCount, k8sClient.Counts.NumPods)
+			}
+		})
+	}
+}
+
+func TestAutoscaleRouting(t *testing.T) {
+	tests := []struct {
+		name                  string
+		matchesAllAgents      bool
+		demands               []string
+		expectedJavaPodCount  int32
+		expectedMavenPodCount int32
+	}{
+		{"generic", true, nil, 2, 0},
+		{"no_demands", false, nil, 2, 0},
+		{"java_demand", false, []string{"java -equals 11"}, 2, 0},
+		{"maven_demand", false, []string{"maven"}, 0, 2},
+		{"java_version_demand", false, []string{"java -equals 8", "maven"}, 0, 2},
+		// Neither workload has online agents, so the first one may detect python on its PATH
+		{"undeclared_demand", false, []string{"python"}, 2, 0},
+		{"unsatisfiable_demand", false, []string{"java -equals 17"}, 0, 0},
+	}
+
+	workloads := []struct {
+		name   string
+		labels map[string]string
+		env    []corev1.EnvVar
+	}{
+		{"azp-agent-java", map[string]string{}, []corev1.EnvVar{{Name: "java", Value: "11"}}},
+		{"azp-agent-maven", map[string]string{kubernetes.CapabilityLabelPrefix + "maven": "3", kubernetes.CapabilityLabelPrefix + "java": "8"}, nil},
+	}
+	orders := []struct {
+		name      string
+		workloads []int
+	}{
+		{"java_first", []int{0, 1}},
+		{"maven_first", []int{1, 0}},
+	}
+
+	for i, test := range tests {
+		for o, order := range orders {
+			poolID := 200 + len(orders)*i + o
+			t.Run(test.name+"_"+order.name, func(t *testing.T) {
+				azdClient := mockAZDClient{
+					NumPools:                5,
+					ErrorListPools:          false,
+					NumFreeAgents:           0,
+					NumRunningAgents:        0,
+					ErrorAgents:             false,
+					NumQueuedJobs:           2,
+					ErrorJobs:               false,
+					FreeAgentsFirst:         false,
+					QueuedJobsMatchNoAgents: !test.matchesAllAgents,
+					QueuedJobDemands:        test.demands,
+				}
+				expectedPodCounts := []int32{test.expectedJavaPodCount, test.expectedMavenPodCount}
+
+				for _, w := range workloads {
+					scaling.ExpectWorkloadRoute("default", "statefulset/"+w.name, 10*time.Second, time.Now())
+				}
+
+				k8sClients := make([]mockK8sClient, len(workloads))
+				for j := range workloads {
+					k8sClients[j] = mockK8sClient{
+						Counts:    &mockK8sClientCounts{},
+						HPAExists: false,
+					}
+				}
+
+				for iteration := 0; iteration < 2; iteration++ {
+					for position, j := range order.workloads {
+						w := workloads[j]
+						args := args.Args{
+							Min:          0,
+							Max:          10,
+							Rate:         10 * time.Second,
+							PollInterval: 10 * time.Second,
+							ScaleDown: args.ScaleDownArgs{
+								Delay: 0 * time.Nanosecond,
+								Max:   10,
+							},
+							Kubernetes: args.KubernetesArgs{
+								Type:      "StatefulSet",
+								Name:      w.name,
+								Namespace: "default",
+							},
+							AZD: args.AzureDevopsArgs{
+								Token: "azdtoken",
+								URL:   "https://dev.azure.com/organization",
+							},
+						}
+
+						k8sClient := k8sClients[j]
+						workload := k8sClient.GetWorkloadNoError(args.Kubernetes)
+						workload.PodTemplateSpec.Labels = w.labels
+						workload.PodTemplateSpec.Spec.Containers = []corev1.Container{{Name: "agent", Env: w.env}}
+
+						err := scaling.Autoscale(azdClient, poolID, kubernetes.MakeFromClient(k8sClient), workload, args)
+						if err != nil {
+							t.Error(err.Error())
+						}
+
+						// In the first iteration, the workload autoscaled first waits for the other one to be routable
+						expectedPodCount := expectedPodCounts[j]
+						if iteration == 0 && position == 0 {
+							expectedPodCount = 0
+						}
+						if k8sClient.Counts.NumPods != expectedPodCount {
+							t.Fatalf("Expected %d pods for %s in iteration %d, but got %d", expectedPodCount, w.name, iteration, k8sClient.Counts.NumPods)
+						}
+					}
+				}
+			})
+		}
+	}
+}
@@ -80,6 +80,12 @@ type mockAZDClient struct {
 	NumQueuedJobs    int32
 	ErrorJobs        bool
 	FreeAgentsFirst  bool
+	// Queued jobs only match the agents in MatchedAgents, as when no agents are online
+	QueuedJobsMatchNoAgents bool
+	// Queued jobs only match the agents of the pool in MatchedAgents, instead of all of the agents in the pool
+	QueuedJobsMatchListedAgents bool
+	// The demands of the queued jobs
+	QueuedJobDemands []string
 }
 
 // ListPoolsAsync retrieves a list of agent pools
@@ -128,7 +134,22 @@ func (c mockAZDClient) ListJobRequestsAsync(channel chan<- azuredevops.JobReques
 			runningAgentPos = c.NumFreeAgents
 		}
 		jobs := Jobs(c.NumRunningAgents, false, agents, 0, runningAgentPos)
-		jobs = append(jobs, Jobs(c.NumQueuedJobs, true, agents, int32(len(agents)), runningAgentPos)...)
+		queuedJobs := Jobs(c.NumQueuedJobs, true, agents, int32(len(agents)), runningAgentPos)
+		if c.QueuedJobsMatchNoAgents {
+			for i := range queuedJobs {
+				queuedJobs[i].MatchesAllAgentsInPool = false
+				queuedJobs[i].MatchedAgents = nil
+			}
+		}
+		if c.QueuedJobsMatchListedAgents {
+			for i := range queuedJobs {
+				queuedJobs[i].MatchesAllAgentsInPool = false
+			}
+		}
+		for i := range queuedJobs {
+			queuedJobs[i].Demands = c.QueuedJobDemands
+		}
+		jobs = append(jobs, queuedJobs...)
 		channel <- azuredevops.JobRequestsResponse{jobs, nil}
 	}
 }
@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/scaling"
+)
+
+func TestAutoscaleBehavior(t *testing.T) {
+	pods := func(value int32) args.BehaviorPolicyArgs {
+		return args.BehaviorPolicyArgs{Type: "Pods", Value: value, Period: 1 * time.Minute}
+	}
+	percent := func(value int32) args.BehaviorPolicyArgs {
+		return args.BehaviorPolicyArgs{Type: "Percent", Value: value, Period: 1 * time.Minute}
+	}
+
+	tests := []struct {
+		name              string
+		behavior          args.BehaviorArgs
+		numPods           int32
+		numQueuedJobs     []int32
+		expectedPodCounts []int32
+	}{
+		{"no_behavior", args.BehaviorArgs{}, 0, []int32{6, 6}, []int32{7, 7}},
+		{"scale_up_pods", args.BehaviorArgs{ScaleUp: args.ScalingRulesArgs{Policies: []args.BehaviorPolicyArgs{pods(2)}}}, 0, []int32{6, 6}, []int32{2, 2}},
+		{"scale_up_percent", args.BehaviorArgs{ScaleUp: args.ScalingRulesArgs{Policies: []args.BehaviorPolicyArgs{percent(50)}}}, 4, []int32{6, 6}, []int32{6, 6}},
+		{"scale_up_percent_from_zero", args.BehaviorArgs{ScaleUp: args.ScalingRulesArgs{Policies: []args.BehaviorPolicyArgs{percent(100)}}}, 0, []int32{6, 6}, []int32{1, 1}},
+		{"scale_up_select_max", args.BehaviorArgs{ScaleUp: args.ScalingRulesArgs{SelectPolicy: "Max", Policies: []args.BehaviorPolicyArgs{pods(1), percent(100)}}}, 2, []int32{6}, []int32{4}},
+		{"scale_up_select_min", args.BehaviorArgs{ScaleUp: args.ScalingRulesArgs{SelectPolicy: "Min", Policies: []args.BehaviorPolicyArgs{pods(1), percent(100)}}}, 2, []int32{6}, []int32{3}},
+		{"scale_up_disabled", args.BehaviorArgs{ScaleUp: args.ScalingRulesArgs{SelectPolicy: "Disabled"}}, 2, []int32{6}, []int32{2}},
+		{"scale_up_window", args.BehaviorArgs{ScaleUp: args.ScalingRulesArgs{StabilizationWindow: 1 * time.Minute}}, 1, []int32{0, 6}, []int32{1, 1}},
+		{"scale_down_window", args.BehaviorArgs{ScaleDown: args.ScalingRulesArgs{StabilizationWindow: 1 * time.Minute}}, 7, []int32{6, 0}, []int32{7, 7}},
+		{"scale_down_no_window", args.BehaviorArgs{}, 7, []int32{6, 0}, []int32{7, 1}},
+		{"scale_down_pods", args.BehaviorArgs{ScaleDown: args.ScalingRulesArgs{Policies: []args.BehaviorPolicyArgs{pods(2)}}}, 10, []int32{0, 0}, []int32{8, 8}},
+		{"scale_down_percent", args.BehaviorArgs{ScaleDown: args.ScalingRulesArgs{Policies: []args.BehaviorPolicyArgs{percent(50)}}}, 10, []int32{0, 0}, []int32{5, 5}},
+		{"scale_down_disabled", args.BehaviorArgs{ScaleDown: args.ScalingRulesArgs{SelectPolicy: "Disabled"}}, 10, []int32{0}, []int32{10}},
+	}
+
+	for i, test := range tests {
+		poolID := 600 + i
+		t.Run(test.name, func(t *testing.T) {
+			args := args.Args{
+				Min:  1,
+				Max:  20,
+				Rate: 10 * time.Second,
+				ScaleDown: args.ScaleDownArgs{
+					Delay: 0 * time.Nanosecond,
+					Max:   20,
+				},
+				Behavior: test.behavior,
+				Kubernetes: args.KubernetesArgs{
+					Type:      "StatefulSet",
+					Name:      "azp-agent-behavior-" + test.name,
+					Namespace: "default",
+				},
+				AZD: args.AzureDevopsArgs{
+					Token: "azdtoken",
+					URL:   "https://dev.azure.com/organization",
+				},
+			}
+
+			k8sClient := mockK8sClient{
+				Counts: &mockK8sClientCounts{
+					NumPods: test.numPods,
+				},
+				HPAExists: false,
+			}
+			workload := k8sClient.GetWorkloadNoError(args.Kubernetes)
+
+			// Each iteration keeps the scaling history of the workload
+			for iteration, numQueuedJobs := range test.numQueuedJobs {
+				azdClient := mockAZDClient{
+					NumPools:                5,
+					ErrorListPools:          false,
+					NumFreeAgents:           k8sClient.Counts.NumPods,
+					NumRunningAgents:        0,
+					ErrorAgents:             false,
+					NumQueuedJobs:           numQueuedJobs,
+					ErrorJobs:               false,
+					FreeAgentsFirst:         false,
+					QueuedJobsMatchNoAgents: true,
+				}
+
+				err := scaling.Autoscale(azdClient, poolID, kubernetes.MakeFromClient(k8sClient), workload, args)
+				if err != nil {
+					t.Error(err.Error())
+				}
+
+				if k8sClient.Counts.NumPods != test.expectedPodCounts[iteration] {
+					t.Fatalf("Expected %d pods after iteration %d, but got %d", test.expectedPodCounts[iteration], iteration, k8sClient.Counts.NumPods)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+)
+
+func defaultPoolArgs() args.PoolArgs {
+	scheduleMin := int32(5)
+	return args.PoolArgs{
+		Min:      1,
+		Max:      10,
+		Mode:     "Replicas",
+		PoolName: "default-pool",
+		ScaleDown: args.ScaleDownArgs{
+			Delay:    10 * time.Minute,
+			Max:      2,
+			Strategy: "Replicas",
+		},
+		Policy: args.PolicyArgs{Type: "FixedBuffer", Buffer: 3, Step: 1},
+		Behavior: args.BehaviorArgs{
+			ScaleUp: args.ScalingRulesArgs{
+				SelectPolicy: "Max",
+				Policies:     []args.BehaviorPolicyArgs{{Type: "Pods", Value: 4, Period: time.Minute}},
+			},
+			ScaleDown: args.ScalingRulesArgs{
+				StabilizationWindow: 5 * time.Minute,
+				SelectPolicy:        "Max",
+			},
+		},
+		Kubernetes: args.KubernetesArgs{Type: "StatefulSet", Namespace: "default"},
+		Schedules:  []args.ScheduleArgs{{Name: "business-hours", Cron: "0 8 * * 1-5", Min: &scheduleMin}},
+	}
+}
+
+func TestLoadPools(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        string
+		expectedError bool
+		verify        func(t *testing.T, pools []args.PoolArgs)
+	}{
+		{"defaults", "pools:\n- name: azp-agent\n", false, func(t *testing.T, pools []args.PoolArgs) {
+			pool := pools[0]
+			defaults := defaultPoolArgs()
+			if pool.Kubernetes.Name != "azp-agent" || pool.Kubernetes.Type != "StatefulSet" || pool.Kubernetes.Namespace != "default" {
+				t.Fatalf("Expected statefulset/azp-agent in namespace default, but got %v", pool.Kubernetes)
+			}
+			if pool.Min != defaults.Min || pool.Max != defaults.Max || pool.PoolName != defaults.PoolName || pool.ScaleDown != defaults.ScaleDown || pool.Policy != defaults.Policy {
+				t.Fatalf("Expected the default args, but got %v", pool)
+			}
+			if len(pool.Schedules) != 1 || len(pool.Behavior.ScaleUp.Policies) != 1 || pool.Behavior.ScaleDown.StabilizationWindow != 5*time.Minute {
+				t.Fatalf("Expected the default schedules and behavior, but got %v and %v", pool.Schedules, pool.Behavior)
+			}
+		}},
+		{"merged", "pools:\n- name: azp-agent\n  kind: Deployment\n  min: 2\n  pool: java\n  scaleDown:\n    delay: 30s\n  policy:\n    buffer: 5\n  behavior:\n    scaleDown:\n      selectPolicy: Min\n", false, func(t *testing.T, pools []args.PoolArgs) {
+			pool := pools[0]
+			if pool.Kubernetes.Type != "Deployment" || pool.Min != 2 || pool.Max != 10 || pool.PoolName != "java" {
+				t.Fatalf("Expected a Deployment with min 2, max 10 and agent pool java, but got %v", pool)
+			}
+			if pool.ScaleDown.Delay != 30*time.Second || pool.ScaleDown.Max != 2 || pool.ScaleDown.Strategy != "Replicas" {
+				t.Fatalf("Expected the scale down delay to be merged with the defaults, but got %v", pool.ScaleDown)
+			}
+			if pool.Policy.Type != "FixedBuffer" || pool.Policy.Buffer != 5 || pool.Policy.Step != 1 {
+				t.Fatalf("Expected the policy buffer to be merged with the defaults, but got %v", pool.Policy)
+			}
+			if pool.Behavior.ScaleDown.SelectPolicy != "Min" || pool.Behavior.ScaleDown.StabilizationWindow != 5*time.Minute {
+				t.Fatalf("Expected the scale down behavior to be merged with the defaults, but got %v", pool.Behavior.ScaleDown)
+			}
+		}},
+		{"replaced_schedules_and_policies", "pools:\n- name: azp-agent\n  schedules:\n  - name: nights\n    cron: '0 20 * * *'\n    max: 1\n  behavior:\n    scaleUp:\n      policies:\n      - type: Percent\n        value: 100\n        period: 2m\n", false, func(t *testing.T, pools []args.PoolArgs) {
+			pool := pools[0]
+			if len(pool.Schedules) != 1 || pool.Schedules[0].Name != "nights" || pool.Schedules[0].Min != nil || *pool.Schedules[0].Max != 1 {
+				t.Fatalf("Expected the schedules to be replaced, but got %v", pool.Schedules)
+			}
+			policies := pool.Behavior.ScaleUp.Policies
+			if len(policies) != 1 || policies[0].Type != "Percent" || policies[0].Value != 100 || policies[0].Period != 2*time.Minute {
+				t.Fatalf("Expected the scale up policies to be replaced, but got %v", policies)
+			}
+		}},
+		{"removed_schedules_and_policies", "pools:\n- name: azp-agent\n  schedules: []\n  behavior:\n    scaleUp:\n      policies: []\n", false, func(t *testing.T, pools []args.PoolArgs) {
+			if len(pools[0].Schedules) != 0 || len(pools[0].Behavior.ScaleUp.Policies) != 0 {
+				t.Fatalf("Expected no schedules and scale up policies, but got %v and %v", pools[0].Schedules, pools[0].Behavior.ScaleUp.Policies)
+			}
+		}},
+		{"json", `{"pools": [{"name": "azp-agent-1"}, {"name": "azp-agent-2", "namespace": "agents"}]}`, false, func(t *testing.T, pools []args.PoolArgs) {
+			if len(pools) != 2 || pools[1].Kubernetes.Name != "azp-agent-2" || pools[1].Kubernetes.Namespace != "agents" {
+				t.Fatalf("Expected 2 pools, but got %v", pools)
+			}
+		}},
+		{"invalid_duration", "pools:\n- name: azp-agent\n  scaleDown:\n    delay: 30\n", true, nil},
+		{"invalid_yaml", "pools:\n- name: [azp-agent\n", true, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := writeTempFile(t, []byte(test.config))
+			defer os.Remove(file)
+
+			pools, err := args.LoadPools(file, defaultPoolArgs())
+			if test.expectedError {
+				if err == nil {
+					t.Fatalf("Expected an error, but got %v", pools)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err.Error())
+			}
+			test.verify(t, pools)
+		})
+	}
+
+	t.Run("missing_file", func(t *testing.T) {
+		if _, err := args.LoadPools("/nonexistent/config.yaml", defaultPoolArgs()); err == nil {
+			t.Fatal("Expected an error")
+		}
+	})
+}
+
+func TestValidatePools(t *testing.T) {
+	tests := []struct {
+		name           string
+		workloads      []args.KubernetesArgs
+		expectedErrors []string
+	}{
+		{"unique", []args.KubernetesArgs{{Type: "StatefulSet", Name: "azp-agent", Namespace: "default"}, {Type: "StatefulSet", Name: "azp-agent", Namespace: "agents"}, {Type: "Deployment", Name: "azp-agent", Namespace: "default"}}, nil},
+		{"duplicate", []args.KubernetesArgs{{Type: "StatefulSet", Name: "azp-agent", Namespace: "default"}, {Type: "StatefulSet", Name: "azp-agent", Namespace: "default"}}, []string{"Pool 1 (statefulset/azp-agent): the workload is listed more than once."}},
+		{"duplicate_kind_case", []args.KubernetesArgs{{Type: "Deployment", Name: "azp-agent", Namespace: "default"}, {Type: "deployment", Name: "azp-agent", Namespace: "default"}}, []string{"Pool 1 (deployment/azp-agent): the workload is listed more than once."}},
+		{"invalid_pool", []args.KubernetesArgs{{Type: "StatefulSet", Namespace: "default"}}, []string{"Pool 0 (statefulset/): StatefulSet name is required."}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var pools []args.PoolArgs
+			for _, workload := range test.workloads {
+				pool := defaultPoolArgs()
+				pool.Kubernetes = workload
+				pools = append(pools, pool)
+			}
+			if validationErrors := args.ValidatePools(pools); strings.Join(validationErrors, "\n") != strings.Join(test.expectedErrors, "\n") {
+				t.Fatalf("Expected the errors %v, but got %v", test.expectedErrors, validationErrors)
+			}
+		})
+	}
+}
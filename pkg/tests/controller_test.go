@@ -0,0 +1,401 @@
+package tests
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/azuredevops"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/controller"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
+)
+
+// mockAgentPoolK8sClient serves the AzpAgentPool resources from a fake dynamic client, and everything else from mockK8sClient
+type mockAgentPoolK8sClient struct {
+	mockK8sClient
+	agentPools kubernetes.Client
+}
+
+// WatchAgentPools sends an event each time an AzpAgentPool is created, updated or deleted, until stopCh is closed.
+func (c mockAgentPoolK8sClient) WatchAgentPools(namespace string, stopCh <-chan struct{}) (<-chan kubernetes.AgentPoolEvent, error) {
+	return c.agentPools.WatchAgentPools(namespace, stopCh)
+}
+
+// UpdateAgentPoolStatus replaces the status of an AzpAgentPool
+func (c mockAgentPoolK8sClient) UpdateAgentPoolStatus(pool *kubernetes.AzpAgentPool, status kubernetes.AzpAgentPoolStatus) error {
+	return c.agentPools.UpdateAgentPoolStatus(pool, status)
+}
+
+// failingAZDClient fails to list the agent pools with a server error a number of times, then succeeds
+type failingAZDClient struct {
+	mockAZDClient
+	failures *int32
+}
+
+// ListPoolsAsync retrieves a list of agent pools
+func (c failingAZDClient) ListPoolsAsync(channel chan<- azuredevops.PoolDetailsResponse) {
+	if atomic.AddInt32(c.failures, -1) >= 0 {
+		channel <- azuredevops.PoolDetailsResponse{Err: &azuredevops.HTTPError{StatusCode: 503}}
+		return
+	}
+	c.mockAZDClient.ListPoolsAsync(channel)
+}
+
+func agentPoolToUnstructured(t *testing.T, pool *kubernetes.AzpAgentPool) *unstructured.Unstructured {
+	pool.APIVersion = kubernetes.AgentPoolResource.GroupVersion().String()
+	pool.Kind = "AzpAgentPool"
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pool)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return &unstructured.Unstructured{Object: content}
+}
+
+// waitFor polls a condition until it's true, or fails the test after a timeout
+func waitFor(t *testing.T, description string, condition func() bool) {
+	for timeout := time.Now().Add(5 * time.Second); time.Now().Before(timeout); time.Sleep(10 * time.Millisecond) {
+		if condition() {
+			return
+		}
+	}
+	t.Fatalf("Timed out waiting for %s", description)
+}
+
+// statusPatches returns the number of patches of the status subresource of AzpAgentPools
+func statusPatches(dynamicClient *dynamicfake.FakeDynamicClient) int {
+	patches := 0
+	for _, action := range dynamicClient.Actions() {
+		if patch, ok := action.(k8stesting.PatchAction); ok && patch.GetResource() == kubernetes.AgentPoolResource && patch.GetSubresource() == "status" {
+			patches++
+		}
+	}
+	return patches
+}
+
+func TestController(t *testing.T) {
+	namespace := "controller"
+	min := int32(1)
+	max := int32(10)
+	pool := &kubernetes.AzpAgentPool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "agents",
+			Namespace:  namespace,
+			Generation: 1,
+		},
+		Spec: kubernetes.AzpAgentPoolSpec{
+			WorkloadRef: kubernetes.AzpAgentPoolWorkloadRef{Kind: "StatefulSet", Name: "azp-agent"},
+			Pool:        "pool-2",
+			Min:         &min,
+			Max:         &max,
+		},
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), agentPoolToUnstructured(t, pool))
+	agentPools := dynamicClient.Resource(kubernetes.AgentPoolResource).Namespace(namespace)
+
+	k8sClient := mockAgentPoolK8sClient{
+		mockK8sClient: mockK8sClient{
+			Counts: &mockK8sClientCounts{},
+		},
+		agentPools: kubernetes.MakeFromClientsets(fake.NewSimpleClientset(), dynamicClient).Sync(),
+	}
+	numPods := func() int32 {
+		k8sClient.Counts.lock.Lock()
+		defer k8sClient.Counts.lock.Unlock()
+		return k8sClient.Counts.NumPods
+	}
+	getStatus := func() *kubernetes.AzpAgentPoolStatus {
+		obj, err := agentPools.Get(pool.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil
+		}
+		current := &kubernetes.AzpAgentPool{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), current); err != nil {
+			t.Fatal(err.Error())
+		}
+		return &current.Status
+	}
+	update := func(generation int64, min int32, max int32) {
+		pool.Generation = generation
+		pool.Spec.Min = &min
+		pool.Spec.Max = &max
+		if _, err := agentPools.Update(agentPoolToUnstructured(t, pool), metav1.UpdateOptions{}); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	c := controller.MakeController(mockAZDClient{NumPools: 5}, kubernetes.MakeFromClient(k8sClient), args.Args{
+		Min:             1,
+		Max:             10,
+		Rate:            20 * time.Millisecond,
+		PollInterval:    20 * time.Millisecond,
+		Mode:            "Replicas",
+		RefreshInterval: time.Minute,
+		ScaleDown: args.ScaleDownArgs{
+			Max:      10,
+			Strategy: "Replicas",
+		},
+		Policy: args.PolicyArgs{
+			Type: "Default",
+			Step: 1,
+		},
+		Kubernetes: args.KubernetesArgs{
+			Type: "StatefulSet",
+		},
+		Retry: args.RetryArgs{
+			FailureThreshold: 5,
+			MaxBackoff:       time.Second,
+		},
+	})
+	stop := make(chan struct{})
+	stopped := make(chan error)
+	go func() {
+		stopped <- c.Run(namespace, stop)
+	}()
+
+	// Created: the workload is scaled to the min, and the status subresource is updated
+	waitFor(t, "the AzpAgentPool to be scaled", func() bool {
+		status := getStatus()
+		return status != nil && status.ObservedGeneration == 1 && numPods() == 1
+	})
+	if status := getStatus(); status.PoolID != 2 || status.Error != "" || status.LastUpdateTime == nil {
+		t.Fatalf("Expected the status of agent pool 2 with no error, but got %v", status)
+	}
+	if statusPatches(dynamicClient) == 0 {
+		t.Fatal("Expected the status subresource to be patched")
+	}
+
+	// Updated: the autoscaling is restarted with the new spec
+	update(2, 3, 10)
+	waitFor(t, "the updated AzpAgentPool to be scaled", func() bool {
+		status := getStatus()
+		return status != nil && status.ObservedGeneration == 2 && numPods() == 3
+	})
+
+	// An invalid spec is reported in the status
+	update(3, 5, 4)
+	waitFor(t, "the invalid AzpAgentPool to be reported", func() bool {
+		status := getStatus()
+		return status != nil && status.ObservedGeneration == 3 && strings.Contains(status.Error, "Max pods argument must be greater than the minimum.")
+	})
+
+	// Deleted: the autoscaling is stopped
+	update(4, 1, 10)
+	waitFor(t, "the fixed AzpAgentPool to be scaled", func() bool {
+		status := getStatus()
+		return status != nil && status.ObservedGeneration == 4 && status.Error == "" && numPods() == 1
+	})
+	if err := agentPools.Delete(pool.Name, &metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err.Error())
+	}
+	time.Sleep(100 * time.Millisecond)
+	patches := statusPatches(dynamicClient)
+	time.Sleep(100 * time.Millisecond)
+	if statusPatches(dynamicClient) != patches {
+		t.Fatal("Expected the status to not be updated after the AzpAgentPool is deleted")
+	}
+
+	close(stop)
+	if err := <-stopped; err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestControllerWorkloadInUse(t *testing.T) {
+	namespace := "controller-in-use"
+	min := int32(2)
+	max := int32(10)
+	makePool := func(name string) *kubernetes.AzpAgentPool {
+		return &kubernetes.AzpAgentPool{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       name,
+				Namespace:  namespace,
+				Generation: 1,
+			},
+			Spec: kubernetes.AzpAgentPoolSpec{
+				WorkloadRef: kubernetes.AzpAgentPoolWorkloadRef{Kind: "StatefulSet", Name: "azp-agent"},
+				Pool:        "pool-2",
+				Min:         &min,
+				Max:         &max,
+			},
+		}
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), agentPoolToUnstructured(t, makePool("agents")))
+	agentPools := dynamicClient.Resource(kubernetes.AgentPoolResource).Namespace(namespace)
+
+	k8sClient := mockAgentPoolK8sClient{
+		mockK8sClient: mockK8sClient{
+			Counts: &mockK8sClientCounts{},
+		},
+		agentPools: kubernetes.MakeFromClientsets(fake.NewSimpleClientset(), dynamicClient).Sync(),
+	}
+	getStatus := func(name string) *kubernetes.AzpAgentPoolStatus {
+		obj, err := agentPools.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return nil
+		}
+		current := &kubernetes.AzpAgentPool{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), current); err != nil {
+			t.Fatal(err.Error())
+		}
+		return &current.Status
+	}
+
+	c := controller.MakeController(mockAZDClient{NumPools: 5}, kubernetes.MakeFromClient(k8sClient), args.Args{
+		Min:             1,
+		Max:             10,
+		Rate:            20 * time.Millisecond,
+		PollInterval:    20 * time.Millisecond,
+		Mode:            "Replicas",
+		RefreshInterval: time.Minute,
+		ScaleDown: args.ScaleDownArgs{
+			Max:      10,
+			Strategy: "Replicas",
+		},
+		Policy: args.PolicyArgs{
+			Type: "Default",
+			Step: 1,
+		},
+		Kubernetes: args.KubernetesArgs{
+			Type: "StatefulSet",
+		},
+		Retry: args.RetryArgs{
+			FailureThreshold: 5,
+			MaxBackoff:       time.Second,
+		},
+	})
+	stop := make(chan struct{})
+	stopped := make(chan error)
+	go func() {
+		stopped <- c.Run(namespace, stop)
+	}()
+
+	waitFor(t, "the first AzpAgentPool to be scaled", func() bool {
+		status := getStatus("agents")
+		return status != nil && status.ObservedGeneration == 1 && status.Error == "" && status.LastUpdateTime != nil
+	})
+
+	// A second AzpAgentPool referencing the same workload is rejected
+	if _, err := agentPools.Create(agentPoolToUnstructured(t, makePool("agents-copy")), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err.Error())
+	}
+	waitFor(t, "the second AzpAgentPool to be rejected", func() bool {
+		status := getStatus("agents-copy")
+		return status != nil && strings.Contains(status.Error, "statefulset/azp-agent is already autoscaled by azpagentpool/agents")
+	})
+
+	// Once the first AzpAgentPool is deleted, the second one autoscales the workload
+	if err := agentPools.Delete("agents", &metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err.Error())
+	}
+	waitFor(t, "the second AzpAgentPool to be scaled", func() bool {
+		status := getStatus("agents-copy")
+		return status != nil && status.Error == "" && status.PoolID == 2
+	})
+
+	close(stop)
+	if err := <-stopped; err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestControllerRestart(t *testing.T) {
+	namespace := "controller-restart"
+	min := int32(2)
+	max := int32(10)
+	pool := &kubernetes.AzpAgentPool{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "agents",
+			Namespace:  namespace,
+			Generation: 1,
+		},
+		Spec: kubernetes.AzpAgentPoolSpec{
+			WorkloadRef: kubernetes.AzpAgentPoolWorkloadRef{Kind: "StatefulSet", Name: "azp-agent"},
+			Pool:        "pool-2",
+			Min:         &min,
+			Max:         &max,
+		},
+	}
+	dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), agentPoolToUnstructured(t, pool))
+	agentPools := dynamicClient.Resource(kubernetes.AgentPoolResource).Namespace(namespace)
+
+	k8sClient := mockAgentPoolK8sClient{
+		mockK8sClient: mockK8sClient{
+			Counts: &mockK8sClientCounts{},
+		},
+		agentPools: kubernetes.MakeFromClientsets(fake.NewSimpleClientset(), dynamicClient).Sync(),
+	}
+	numPods := func() int32 {
+		k8sClient.Counts.lock.Lock()
+		defer k8sClient.Counts.lock.Unlock()
+		return k8sClient.Counts.NumPods
+	}
+	getStatus := func() *kubernetes.AzpAgentPoolStatus {
+		obj, err := agentPools.Get(pool.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil
+		}
+		current := &kubernetes.AzpAgentPool{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), current); err != nil {
+			t.Fatal(err.Error())
+		}
+		return &current.Status
+	}
+
+	// Azure Devops fails more times in a row than the failure threshold
+	failures := int32(3)
+	azdClient := failingAZDClient{mockAZDClient: mockAZDClient{NumPools: 5}, failures: &failures}
+	c := controller.MakeController(azdClient, kubernetes.MakeFromClient(k8sClient), args.Args{
+		Min:             1,
+		Max:             10,
+		Rate:            20 * time.Millisecond,
+		PollInterval:    20 * time.Millisecond,
+		Mode:            "Replicas",
+		RefreshInterval: time.Minute,
+		ScaleDown: args.ScaleDownArgs{
+			Max:      10,
+			Strategy: "Replicas",
+		},
+		Policy: args.PolicyArgs{
+			Type: "Default",
+			Step: 1,
+		},
+		Kubernetes: args.KubernetesArgs{
+			Type: "StatefulSet",
+		},
+		Retry: args.RetryArgs{
+			FailureThreshold: 2,
+			MaxBackoff:       200 * time.Millisecond,
+		},
+	})
+	stop := make(chan struct{})
+	stopped := make(chan error)
+	go func() {
+		stopped <- c.Run(namespace, stop)
+	}()
+
+	// The exhausted retries are reported in the status
+	waitFor(t, "the failures to be reported", func() bool {
+		status := getStatus()
+		return status != nil && strings.Contains(status.Error, "2 consecutive failures")
+	})
+
+	// The autoscaling is restarted, and scales the workload once Azure Devops recovers
+	waitFor(t, "the AzpAgentPool to be scaled after restarting", func() bool {
+		status := getStatus()
+		return status != nil && status.Error == "" && status.PoolID == 2 && numPods() == 2
+	})
+
+	close(stop)
+	if err := <-stopped; err != nil {
+		t.Fatal(err.Error())
+	}
+}
@@ -0,0 +1,59 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/cron"
+)
+
+func TestCronParse(t *testing.T) {
+	for _, expression := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "* * * foo *"} {
+		t.Run(expression, func(t *testing.T) {
+			if _, err := cron.Parse(expression); err == nil {
+				t.Fatalf("Expected an error parsing '%s'", expression)
+			}
+		})
+	}
+}
+
+func TestCronMatches(t *testing.T) {
+	// A Monday
+	monday := time.Date(2019, time.July, 1, 9, 30, 0, 0, time.UTC)
+	tests := []struct {
+		expression string
+		time       time.Time
+		matches    bool
+	}{
+		{"* * * * *", monday, true},
+		{"30 9 * * *", monday, true},
+		{"31 9 * * *", monday, false},
+		{"* 8-17 * * mon-fri", monday, true},
+		{"* 8-17 * * mon-fri", monday.Add(8 * time.Hour), true},
+		{"* 8-17 * * mon-fri", monday.Add(9 * time.Hour), false},
+		{"* 8-17 * * mon-fri", monday.AddDate(0, 0, 5), false},
+		{"* * * * 0", monday.AddDate(0, 0, 6), true},
+		{"* * * * 7", monday.AddDate(0, 0, 6), true},
+		{"*/15 * * * *", monday, true},
+		{"*/20 * * * *", monday, false},
+		{"0,30 * * * *", monday, true},
+		{"* * * jul *", monday, true},
+		{"* * 15 * *", monday, false},
+		// Either the day of month or the day of week can match when both are restricted
+		{"* * 15 * mon", monday, true},
+		{"* * 1 * fri", monday, true},
+		{"* * 15 * fri", monday, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			schedule, err := cron.Parse(test.expression)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			if matches := schedule.Matches(test.time); matches != test.matches {
+				t.Fatalf("Expected '%s' matching %s to be %t", test.expression, test.time.String(), test.matches)
+			}
+		})
+	}
+}
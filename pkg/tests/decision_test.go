@@ -0,0 +1,125 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/scaling"
+)
+
+func TestDecisionLog(t *testing.T) {
+	decisionLog := scaling.MakeDecisionLog(3)
+	if len(decisionLog.List()) != 0 {
+		t.Fatalf("Expected no decisions, but got %v", decisionLog.List())
+	}
+
+	for i := 0; i < 5; i++ {
+		decisionLog.Add(scaling.Decision{PoolID: i, Workload: "statefulset/azp-agent"})
+	}
+	decisions := decisionLog.List()
+	if len(decisions) != 3 {
+		t.Fatalf("Expected 3 decisions, but got %d", len(decisions))
+	}
+	for i, decision := range decisions {
+		if decision.PoolID != i+2 {
+			t.Fatalf("Expected decision %d to be for pool %d, but got %d", i, i+2, decision.PoolID)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	decisionLog.ServeHTTP(recorder, httptest.NewRequest("GET", "/decisions?poolId=3", nil))
+	if recorder.Code != 200 {
+		t.Fatalf("Expected status 200, but got %d", recorder.Code)
+	}
+	var served []scaling.Decision
+	if err := json.Unmarshal(recorder.Body.Bytes(), &served); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(served) != 1 || served[0].PoolID != 3 {
+		t.Fatalf("Expected the decision of pool 3, but got %v", served)
+	}
+
+	disabledLog := scaling.MakeDecisionLog(0)
+	disabledLog.Add(scaling.Decision{})
+	if len(disabledLog.List()) != 0 {
+		t.Fatalf("Expected no decisions, but got %v", disabledLog.List())
+	}
+}
+
+func TestAutoscaleDecisions(t *testing.T) {
+	defer scaling.SetDecisionLog(scaling.GetDecisionLog())
+	decisionLog := scaling.MakeDecisionLog(10)
+	scaling.SetDecisionLog(decisionLog)
+
+	azdClient := mockAZDClient{
+		NumPools:         5,
+		ErrorListPools:   false,
+		NumFreeAgents:    5,
+		NumRunningAgents: 0,
+		ErrorAgents:      false,
+		NumQueuedJobs:    0,
+		ErrorJobs:        false,
+		FreeAgentsFirst:  false,
+	}
+
+	args := args.Args{
+		Min:  1,
+		Max:  100,
+		Rate: 10 * time.Second,
+		ScaleDown: args.ScaleDownArgs{
+			Delay: time.Hour,
+			Max:   1,
+		},
+		Kubernetes: args.KubernetesArgs{
+			Type:      "StatefulSet",
+			Name:      "azp-agent",
+			Namespace: "decisions",
+		},
+		AZD: args.AzureDevopsArgs{
+			Token: "azdtoken",
+			URL:   "https://dev.azure.com/organization",
+		},
+	}
+
+	k8sClient := mockK8sClient{
+		Counts: &mockK8sClientCounts{
+			NumPods: 5,
+		},
+		HPAExists: false,
+	}
+	workload := k8sClient.GetWorkloadNoError(args.Kubernetes)
+
+	for i := 0; i < 2; i++ {
+		if err := scaling.Autoscale(azdClient, 900, kubernetes.MakeFromClient(k8sClient), workload, args); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	decisions := decisionLog.List()
+	if len(decisions) != 2 {
+		t.Fatalf("Expected 2 decisions, but got %d", len(decisions))
+	}
+
+	scaledDown := decisions[0]
+	if scaledDown.Reason != kubernetes.EventReasonScaledDown || scaledDown.From != 5 || scaledDown.To != 4 {
+		t.Fatalf("Expected a scale down from 5 to 4, but got %s from %d to %d", scaledDown.Reason, scaledDown.From, scaledDown.To)
+	}
+	if scaledDown.Inputs.Pods != 5 || scaledDown.Inputs.ActiveAgents != 0 || scaledDown.Inputs.Min != 1 {
+		t.Fatalf("Unexpected inputs %+v", scaledDown.Inputs)
+	}
+	if len(scaledDown.Steps) != 1 || scaledDown.Steps[0].Name != "policy" || scaledDown.Steps[0].Value != 1 {
+		t.Fatalf("Expected the policy step to want 1 agent, but got %+v", scaledDown.Steps)
+	}
+	if len(scaledDown.Clamps) != 1 || scaledDown.Clamps[0] != (scaling.DecisionClamp{Name: "scaleDownMax", From: 1, To: 4}) {
+		t.Fatalf("Expected the scale down to be capped by the scale down max, but got %+v", scaledDown.Clamps)
+	}
+
+	delayed := decisions[1]
+	if delayed.Reason != kubernetes.EventReasonScaleDownDelayed || delayed.From != 4 || delayed.To != 4 {
+		t.Fatalf("Expected a delayed scale down at 4 agents, but got %s from %d to %d", delayed.Reason, delayed.From, delayed.To)
+	}
+}
@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/azuredevops"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
+)
+
+func TestParseDemand(t *testing.T) {
+	tests := []struct {
+		demand   string
+		expected azuredevops.Demand
+	}{
+		{"java", azuredevops.Demand{Name: "java", Operator: azuredevops.DemandOperatorExists}},
+		{"java -equals 11", azuredevops.Demand{Name: "java", Operator: azuredevops.DemandOperatorEquals, Value: "11"}},
+		{"Agent.OS -equals Linux", azuredevops.Demand{Name: "Agent.OS", Operator: azuredevops.DemandOperatorEquals, Value: "Linux"}},
+		{"Agent.Version -gtVersion 2.163.1", azuredevops.Demand{Name: "Agent.Version", Operator: azuredevops.DemandOperatorGtVersion, Value: "2.163.1"}},
+		{"SDK -equals C:\\Program Files\\SDK", azuredevops.Demand{Name: "SDK", Operator: azuredevops.DemandOperatorEquals, Value: "C:\\Program Files\\SDK"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.demand, func(t *testing.T) {
+			if demand := azuredevops.ParseDemand(test.demand); demand != test.expected {
+				t.Fatalf("Expected %+v, but got %+v", test.expected, demand)
+			}
+		})
+	}
+}
+
+func TestDemandsSatisfied(t *testing.T) {
+	podTemplate := &corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{
+				"app": "azp-agent",
+				kubernetes.CapabilityLabelPrefix + "docker": "true",
+			},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Env: []corev1.EnvVar{
+					{Name: "AZP_POOL", Value: "Default"},
+					{Name: "JAVA_VERSION", Value: "11.0.2"},
+					{Name: "TOOL_PATH", ValueFrom: &corev1.EnvVarSource{}},
+				},
+			}},
+		},
+	}
+	capabilities := kubernetes.GetCapabilities(podTemplate)
+
+	tests := []struct {
+		name       string
+		demands    []string
+		satisfied  bool
+		maySatisfy bool
+	}{
+		{"no_demands", nil, true, true},
+		{"env_exists", []string{"JAVA_VERSION"}, true, true},
+		{"env_equals", []string{"azp_pool -equals default"}, true, true},
+		{"env_not_equals", []string{"AZP_POOL -equals Other"}, false, false},
+		{"env_gt_version", []string{"JAVA_VERSION -gtVersion 11"}, true, true},
+		{"env_not_gt_version", []string{"JAVA_VERSION -gtVersion 12.0"}, false, false},
+		{"unknown_value", []string{"TOOL_PATH -equals /opt/tool"}, true, true},
+		{"label", []string{"docker -equals true"}, true, true},
+		{"label_not_equals", []string{"docker -equals false"}, false, false},
+		{"missing", []string{"maven"}, false, true},
+		{"system_capability", []string{"Agent.Version -gtVersion 2.163.1", "Agent.OS -equals Linux"}, true, true},
+		{"one_missing", []string{"JAVA_VERSION", "maven"}, false, true},
+		{"one_missing_one_not_equals", []string{"AZP_POOL -equals Other", "maven"}, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if satisfied := azuredevops.DemandsSatisfied(test.demands, capabilities); satisfied != test.satisfied {
+				t.Fatalf("Expected demands %v to be satisfied: %t", test.demands, test.satisfied)
+			}
+			if maySatisfy := azuredevops.DemandsMaySatisfy(test.demands, capabilities); maySatisfy != test.maySatisfy {
+				t.Fatalf("Expected demands %v to maybe be satisfied: %t", test.demands, test.maySatisfy)
+			}
+		})
+	}
+}
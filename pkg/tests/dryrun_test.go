@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/scaling"
+)
+
+func TestAutoscaleDryRun(t *testing.T) {
+	finishedJob := batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "azp-agent-finished",
+		},
+		Status: batchv1.JobStatus{
+			Conditions: []batchv1.JobCondition{{
+				Type:   batchv1.JobComplete,
+				Status: corev1.ConditionTrue,
+			}},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		kind          string
+		mode          string
+		strategy      string
+		freeAgents    int32
+		runningAgents int32
+		queuedJobs    int32
+	}{
+		{"scale_up", "StatefulSet", "Replicas", "Replicas", 0, 2, 3},
+		{"scale_down", "StatefulSet", "Replicas", "Replicas", 10, 1, 0},
+		{"scale_down_deletion_cost", "Deployment", "Replicas", "DeletionCost", 10, 1, 0},
+		{"scale_down_delete", "Deployment", "Replicas", "Delete", 10, 1, 0},
+		{"jobs", "StatefulSet", "Jobs", "Replicas", 0, 0, 3},
+	}
+
+	for i, test := range tests {
+		poolID := 700 + i
+		t.Run(test.name, func(t *testing.T) {
+			azdClient := mockAZDClient{
+				NumPools:         5,
+				ErrorListPools:   false,
+				NumFreeAgents:    test.freeAgents,
+				NumRunningAgents: test.runningAgents,
+				ErrorAgents:      false,
+				NumQueuedJobs:    test.queuedJobs,
+				ErrorJobs:        false,
+				FreeAgentsFirst:  true,
+			}
+
+			args := args.Args{
+				Min:    1,
+				Max:    100,
+				Rate:   10 * time.Second,
+				Mode:   test.mode,
+				DryRun: true,
+				ScaleDown: args.ScaleDownArgs{
+					Delay:    0 * time.Nanosecond,
+					Max:      100,
+					Strategy: test.strategy,
+				},
+				Kubernetes: args.KubernetesArgs{
+					Type:      test.kind,
+					Name:      "azp-agent",
+					Namespace: "dry-run-" + test.name,
+				},
+				AZD: args.AzureDevopsArgs{
+					Token: "azdtoken",
+					URL:   "https://dev.azure.com/organization",
+				},
+			}
+
+			numPods := test.freeAgents + test.runningAgents
+			var existingJobs []batchv1.Job
+			if test.mode == "Jobs" {
+				numPods = 0
+				existingJobs = []batchv1.Job{finishedJob}
+			}
+			k8sClient := mockK8sClient{
+				Counts: &mockK8sClientCounts{
+					NumPods: numPods,
+					Jobs:    existingJobs,
+				},
+				HPAExists: false,
+			}
+
+			err := scaling.Autoscale(azdClient, poolID, kubernetes.MakeFromClient(k8sClient), k8sClient.GetWorkloadNoError(args.Kubernetes), args)
+			if err != nil {
+				t.Error(err.Error())
+			}
+
+			if k8sClient.Counts.NumPods != numPods {
+				t.Fatalf("Expected %d pods, but got %d", numPods, k8sClient.Counts.NumPods)
+			}
+			if len(k8sClient.Counts.Jobs) != len(existingJobs) {
+				t.Fatalf("Expected %d jobs, but got %d", len(existingJobs), len(k8sClient.Counts.Jobs))
+			}
+			if len(k8sClient.Counts.AnnotatedPods) > 0 || len(k8sClient.Counts.DeletedPods) > 0 {
+				t.Fatalf("Expected no pods to be removed, but got %v and %v", k8sClient.Counts.AnnotatedPods, k8sClient.Counts.DeletedPods)
+			}
+			if len(k8sClient.Counts.Annotations) > 0 {
+				t.Fatalf("Expected the workload to not be annotated, but got %v", k8sClient.Counts.Annotations)
+			}
+		})
+	}
+}
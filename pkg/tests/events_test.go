@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/azuredevops"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/scaling"
+)
+
+func TestAutoscaleEvents(t *testing.T) {
+	tests := []struct {
+		name            string
+		freeAgents      int32
+		runningAgents   int32
+		queuedJobs      int32
+		freeAgentsFirst bool
+		scaleDownDelay  time.Duration
+		// The events expected after each iteration
+		expectedEvents [][]string
+	}{
+		{"scale_up", 0, 2, 3, false, 0, [][]string{{kubernetes.EventReasonScaledUp}}},
+		{"scale_down", 5, 0, 0, false, 0, [][]string{{kubernetes.EventReasonScaledDown}, {kubernetes.EventReasonScaledDown}}},
+		// The scale down stays delayed, so it is only recorded once
+		{"scale_down_delayed", 5, 0, 0, false, time.Hour, [][]string{{kubernetes.EventReasonScaledDown}, {kubernetes.EventReasonScaleDownDelayed}, {}}},
+		{"last_agent_active", 5, 1, 0, true, 0, [][]string{{kubernetes.EventReasonScaleDownBlocked}, {}, {}}},
+	}
+
+	for i, test := range tests {
+		poolID := 800 + i
+		t.Run(test.name, func(t *testing.T) {
+			azdClient := mockAZDClient{
+				NumPools:         5,
+				ErrorListPools:   false,
+				NumFreeAgents:    test.freeAgents,
+				NumRunningAgents: test.runningAgents,
+				ErrorAgents:      false,
+				NumQueuedJobs:    test.queuedJobs,
+				ErrorJobs:        false,
+				FreeAgentsFirst:  test.freeAgentsFirst,
+			}
+
+			args := args.Args{
+				Min:  1,
+				Max:  100,
+				Rate: 10 * time.Second,
+				ScaleDown: args.ScaleDownArgs{
+					Delay: test.scaleDownDelay,
+					Max:   1,
+				},
+				Kubernetes: args.KubernetesArgs{
+					Type:      "StatefulSet",
+					Name:      "azp-agent",
+					Namespace: "events-" + test.name,
+				},
+				AZD: args.AzureDevopsArgs{
+					Token: "azdtoken",
+					URL:   "https://dev.azure.com/organization",
+				},
+			}
+
+			k8sClient := mockK8sClient{
+				Counts: &mockK8sClientCounts{
+					NumPods: test.freeAgents + test.runningAgents,
+				},
+				HPAExists: false,
+			}
+			workload := k8sClient.GetWorkloadNoError(args.Kubernetes)
+
+			var expectedEvents []string
+			for iteration, iterationEvents := range test.expectedEvents {
+				err := scaling.Autoscale(azdClient, poolID, kubernetes.MakeFromClient(k8sClient), workload, args)
+				if err != nil {
+					t.Error(err.Error())
+				}
+
+				expectedEvents = append(expectedEvents, iterationEvents...)
+				if len(k8sClient.Counts.Events) != len(expectedEvents) {
+					t.Fatalf("Expected the events %v after iteration %d, but got %v", expectedEvents, iteration, k8sClient.Counts.Events)
+				}
+				for i, event := range expectedEvents {
+					if k8sClient.Counts.Events[i] != event {
+						t.Fatalf("Expected the events %v after iteration %d, but got %v", expectedEvents, iteration, k8sClient.Counts.Events)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestAgentPoolEvents(t *testing.T) {
+	agentPools := []azuredevops.PoolDetails{{
+		Definition: azuredevops.Definition{ID: 1, Name: "Default"},
+	}}
+
+	k8sClient := mockK8sClient{
+		Counts:    &mockK8sClientCounts{},
+		HPAExists: false,
+	}
+	workload := k8sClient.GetWorkloadNoError(args.KubernetesArgs{
+		Type:      "StatefulSet",
+		Name:      "azp-agent",
+		Namespace: "events-agent-pool",
+	})
+
+	if _, _, err := scaling.GetAgentPoolID(kubernetes.MakeFromClient(k8sClient), agentPools, workload, "Default"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(k8sClient.Counts.Events) != 0 {
+		t.Fatalf("Expected no events, but got %v", k8sClient.Counts.Events)
+	}
+
+	if _, _, err := scaling.GetAgentPoolID(kubernetes.MakeFromClient(k8sClient), agentPools, workload, "Unknown"); err == nil {
+		t.Fatal("Expected an error for an unknown agent pool")
+	}
+	if len(k8sClient.Counts.Events) != 1 || k8sClient.Counts.Events[0] != kubernetes.EventReasonFailedGetAgentPool {
+		t.Fatalf("Expected a %s event, but got %v", kubernetes.EventReasonFailedGetAgentPool, k8sClient.Counts.Events)
+	}
+}
@@ -0,0 +1,238 @@
+package tests
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/forecast"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/scaling"
+)
+
+// learnWeeks observes a demand of 8 from 9:00 to 10:00 every weekday, and 1 otherwise
+func learnWeeks(model *forecast.Model, start time.Time, weeks int) {
+	for t := start; t.Before(start.AddDate(0, 0, 7*weeks)); t = t.Add(5 * time.Minute) {
+		demand := int32(1)
+		if t.Hour() == 9 && t.Weekday() != time.Saturday && t.Weekday() != time.Sunday {
+			demand = 8
+		}
+		model.Observe(t, demand)
+	}
+}
+
+func TestForecastModel(t *testing.T) {
+	// A Monday
+	monday := time.Date(2019, time.July, 1, 0, 0, 0, 0, time.UTC)
+	model := forecast.NewModel()
+	learnWeeks(model, monday, 2)
+
+	tests := []struct {
+		name     string
+		time     time.Time
+		leadTime time.Duration
+		expected int32
+	}{
+		{"before_peak", monday.Add(8 * time.Hour), 0, 1},
+		{"during_peak", monday.Add(9*time.Hour + 30*time.Minute), 0, 8},
+		{"lead_time", monday.Add(8*time.Hour + 40*time.Minute), 30 * time.Minute, 8},
+		{"lead_time_too_short", monday.Add(8 * time.Hour), 30 * time.Minute, 1},
+		{"weekend", monday.AddDate(0, 0, 5).Add(9 * time.Hour), 0, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if peak := model.PredictPeak(test.time, test.leadTime); peak != test.expected {
+				t.Fatalf("Expected a forecast of %d, but got %d", test.expected, peak)
+			}
+		})
+	}
+}
+
+func TestForecastDailyFallback(t *testing.T) {
+	// Only Monday has been observed, so Tuesday uses the demand at the same time of day
+	monday := time.Date(2019, time.July, 1, 0, 0, 0, 0, time.UTC)
+	model := forecast.NewModel()
+	for t := monday; t.Before(monday.AddDate(0, 0, 1).Add(forecast.SlotDuration)); t = t.Add(5 * time.Minute) {
+		demand := int32(0)
+		if t.Hour() == 9 {
+			demand = 4
+		}
+		model.Observe(t, demand)
+	}
+
+	if peak := model.PredictPeak(monday.AddDate(0, 0, 1).Add(9*time.Hour), 0); peak != 4 {
+		t.Fatalf("Expected a forecast of 4, but got %d", peak)
+	}
+	if peak := model.PredictPeak(monday.AddDate(0, 0, 1).Add(12*time.Hour), 0); peak != 0 {
+		t.Fatalf("Expected a forecast of 0, but got %d", peak)
+	}
+}
+
+func TestForecastStores(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forecast")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	k8sClient := mockK8sClient{
+		Counts: &mockK8sClientCounts{},
+	}
+
+	stores := map[string]forecast.Store{
+		"file":      forecast.FileStore{Path: filepath.Join(dir, "forecast.json")},
+		"configmap": forecast.ConfigMapStore{Client: k8sClient, Namespace: "default", Name: "azp-agent-autoscaler-forecast"},
+	}
+
+	monday := time.Date(2019, time.July, 1, 0, 0, 0, 0, time.UTC)
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			forecaster, err := forecast.MakeForecaster(store, 0)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			if _, err := forecaster.Observe("default/statefulset/azp-agent", monday.Add(9*time.Hour), 6); err != nil {
+				t.Fatal(err.Error())
+			}
+			// Learning the slot saves the history
+			if _, err := forecaster.Observe("default/statefulset/azp-agent", monday.Add(9*time.Hour+forecast.SlotDuration), 0); err != nil {
+				t.Fatal(err.Error())
+			}
+
+			loaded, err := forecast.MakeForecaster(store, 0)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			prediction, err := loaded.Observe("default/statefulset/azp-agent", monday.AddDate(0, 0, 7).Add(9*time.Hour), 0)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			if prediction.Peak != 6 {
+				t.Fatalf("Expected a forecast of 6 from the saved history, but got %d", prediction.Peak)
+			}
+		})
+	}
+}
+
+func TestForecastReadOnlyStore(t *testing.T) {
+	k8sClient := mockK8sClient{
+		Counts: &mockK8sClientCounts{},
+	}
+	store := forecast.ConfigMapStore{Client: k8sClient, Namespace: "default", Name: "azp-agent-autoscaler-forecast"}
+	monday := time.Date(2019, time.July, 1, 0, 0, 0, 0, time.UTC)
+
+	// The live autoscaler saves its history
+	forecaster, err := forecast.MakeForecaster(store, 0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	forecaster.Observe("default/statefulset/azp-agent", monday.Add(9*time.Hour), 6)
+	if _, err := forecaster.Observe("default/statefulset/azp-agent", monday.Add(9*time.Hour+forecast.SlotDuration), 0); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// A dry run loads it, but doesn't overwrite it with what it learns
+	dryRun, err := forecast.MakeForecaster(forecast.ReadOnlyStore{Store: store}, 0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	dryRun.Observe("default/statefulset/azp-agent", monday.AddDate(0, 0, 7).Add(9*time.Hour), 20)
+	if _, err := dryRun.Observe("default/statefulset/azp-agent", monday.AddDate(0, 0, 7).Add(9*time.Hour+forecast.SlotDuration), 0); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	loaded, err := forecast.MakeForecaster(store, 0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	prediction, _ := loaded.Observe("default/statefulset/azp-agent", monday.AddDate(0, 0, 14).Add(9*time.Hour), 0)
+	if prediction.Peak != 6 {
+		t.Fatalf("Expected a forecast of 6 from the history of the live autoscaler, but got %d", prediction.Peak)
+	}
+}
+
+func TestAutoscaleForecast(t *testing.T) {
+	tests := []struct {
+		name             string
+		forecast         bool
+		expectedPodCount int32
+	}{
+		{"no_forecast", false, 1},
+		{"pre_scale", true, 8},
+	}
+
+	for i, test := range tests {
+		poolID := 300 + i
+		t.Run(test.name, func(t *testing.T) {
+			azdClient := mockAZDClient{
+				NumPools:         5,
+				ErrorListPools:   false,
+				NumFreeAgents:    1,
+				NumRunningAgents: 0,
+				ErrorAgents:      false,
+				NumQueuedJobs:    0,
+				ErrorJobs:        false,
+				FreeAgentsFirst:  false,
+			}
+
+			args := args.Args{
+				Min:  1,
+				Max:  10,
+				Rate: 10 * time.Second,
+				ScaleDown: args.ScaleDownArgs{
+					Delay: 0 * time.Nanosecond,
+					Max:   10,
+				},
+				Kubernetes: args.KubernetesArgs{
+					Type:      "StatefulSet",
+					Name:      "azp-agent-forecast-" + test.name,
+					Namespace: "default",
+				},
+				AZD: args.AzureDevopsArgs{
+					Token: "azdtoken",
+					URL:   "https://dev.azure.com/organization",
+				},
+			}
+
+			k8sClient := mockK8sClient{
+				Counts: &mockK8sClientCounts{
+					NumPods: azdClient.NumFreeAgents,
+				},
+				HPAExists: false,
+			}
+			workload := k8sClient.GetWorkloadNoError(args.Kubernetes)
+
+			if test.forecast {
+				// Learn a demand of 8 over the last week
+				model := forecast.NewModel()
+				now := time.Now()
+				for t := now.AddDate(0, 0, -7); t.Before(now); t = t.Add(5 * time.Minute) {
+					model.Observe(t, 8)
+				}
+				store := forecast.ConfigMapStore{Client: k8sClient, Namespace: "default", Name: "azp-agent-autoscaler-forecast"}
+				if err := store.Save(map[string]*forecast.Model{"default/" + workload.FriendlyName: model}); err != nil {
+					t.Fatal(err.Error())
+				}
+				forecaster, err := forecast.MakeForecaster(store, 15*time.Minute)
+				if err != nil {
+					t.Fatal(err.Error())
+				}
+				scaling.SetForecaster(forecaster)
+				defer scaling.SetForecaster(nil)
+			}
+
+			err := scaling.Autoscale(azdClient, poolID, kubernetes.MakeFromClient(k8sClient), workload, args)
+			if err != nil {
+				t.Error(err.Error())
+			}
+
+			if k8sClient.Counts.NumPods != test.expectedPodCount {
+				t.Fatalf("Expected %d pods, but got %d", test.expectedPodCount, k8sClient.Counts.NumPods)
+			}
+		})
+	}
+}
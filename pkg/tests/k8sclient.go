@@ -3,7 +3,10 @@ package tests
 import (
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
@@ -18,7 +21,17 @@ type mockK8sClient struct {
 
 // Make this a pointer to allow stateful changes
 type mockK8sClientCounts struct {
-	NumPods int32
+	NumPods        int32
+	AnnotatedPods  []string
+	DeletedPods    []string
+	Jobs           []batchv1.Job
+	Annotations    map[string]string
+	PodAnnotations map[string]map[string]string
+	ConfigMaps     map[string]map[string]string
+	Events         []string
+	// The pod annotations and scales, in order, ex: annotate azp-agent-1, scale 2
+	Operations []string
+	lock       sync.Mutex
 }
 
 // GetWorkload retrieves a Workload with no errors
@@ -38,6 +51,11 @@ func (c mockK8sClient) GetWorkloadNoError(args args.KubernetesArgs) *kubernetes.
 			},
 		},
 		PodTemplateSpec: &corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels: map[string]string{
+					"release": args.Name,
+				},
+			},
 			Spec: corev1.PodSpec{},
 		},
 	}
@@ -59,7 +77,27 @@ func (c mockK8sClient) VerifyNoHorizontalPodAutoscaler(args args.KubernetesArgs)
 
 // Scale scales a given Kubernetes resource
 func (c mockK8sClient) Scale(resource *kubernetes.Workload, replicas int32) error {
+	c.Counts.lock.Lock()
+	defer c.Counts.lock.Unlock()
 	c.Counts.NumPods = replicas
+	c.Counts.Operations = append(c.Counts.Operations, fmt.Sprintf("scale %d", replicas))
+	return nil
+}
+
+// AnnotateWorkload sets annotations on a given Kubernetes resource. Annotations with a nil value are removed.
+func (c mockK8sClient) AnnotateWorkload(resource *kubernetes.Workload, annotations map[string]*string) error {
+	c.Counts.lock.Lock()
+	defer c.Counts.lock.Unlock()
+	if c.Counts.Annotations == nil {
+		c.Counts.Annotations = make(map[string]string)
+	}
+	for key, value := range annotations {
+		if value == nil {
+			delete(c.Counts.Annotations, key)
+		} else {
+			c.Counts.Annotations[key] = *value
+		}
+	}
 	return nil
 }
 
@@ -78,12 +116,24 @@ func (c mockK8sClient) GetEnvValue(podSpec corev1.PodSpec, namespace string, env
 
 // GetPods gets all pods attached to some workload
 func (c mockK8sClient) GetPods(workload *kubernetes.Workload) ([]corev1.Pod, error) {
+	c.Counts.lock.Lock()
+	defer c.Counts.lock.Unlock()
 	var pods []corev1.Pod
 	for i := int32(0); i < c.Counts.NumPods; i++ {
+		name := fmt.Sprintf("%s-%d", workload.Name, i)
+		var annotations map[string]string
+		if podAnnotations, exists := c.Counts.PodAnnotations[name]; exists {
+			annotations = make(map[string]string)
+			for key, value := range podAnnotations {
+				annotations[key] = value
+			}
+		}
 		pods = append(pods, corev1.Pod{
 			ObjectMeta: metav1.ObjectMeta{
-				Name:      fmt.Sprintf("%s-%d", workload.Name, i),
-				Namespace: workload.Namespace,
+				Name:              name,
+				Namespace:         workload.Namespace,
+				Annotations:       annotations,
+				CreationTimestamp: metav1.NewTime(time.Date(2019, time.January, 1, 0, 0, int(i), 0, time.UTC)),
 			},
 			TypeMeta: metav1.TypeMeta{
 				Kind: "Pod",
@@ -96,3 +146,113 @@ func (c mockK8sClient) GetPods(workload *kubernetes.Workload) ([]corev1.Pod, err
 	}
 	return pods, nil
 }
+
+// AnnotatePod sets annotations on a pod. Annotations with a nil value are removed.
+func (c mockK8sClient) AnnotatePod(pod *corev1.Pod, annotations map[string]*string) error {
+	c.Counts.lock.Lock()
+	defer c.Counts.lock.Unlock()
+	c.Counts.AnnotatedPods = append(c.Counts.AnnotatedPods, pod.Name)
+	c.Counts.Operations = append(c.Counts.Operations, "annotate "+pod.Name)
+	if c.Counts.PodAnnotations == nil {
+		c.Counts.PodAnnotations = make(map[string]map[string]string)
+	}
+	if c.Counts.PodAnnotations[pod.Name] == nil {
+		c.Counts.PodAnnotations[pod.Name] = make(map[string]string)
+	}
+	for key, value := range annotations {
+		if value == nil {
+			delete(c.Counts.PodAnnotations[pod.Name], key)
+		} else {
+			c.Counts.PodAnnotations[pod.Name][key] = *value
+		}
+	}
+	return nil
+}
+
+// DeletePod deletes a pod
+func (c mockK8sClient) DeletePod(pod *corev1.Pod) error {
+	c.Counts.lock.Lock()
+	defer c.Counts.lock.Unlock()
+	c.Counts.DeletedPods = append(c.Counts.DeletedPods, pod.Name)
+	return nil
+}
+
+// ListJobs gets all Jobs created for some workload
+func (c mockK8sClient) ListJobs(workload *kubernetes.Workload) ([]batchv1.Job, error) {
+	c.Counts.lock.Lock()
+	defer c.Counts.lock.Unlock()
+	return append([]batchv1.Job{}, c.Counts.Jobs...), nil
+}
+
+// CreateJob creates a Job
+func (c mockK8sClient) CreateJob(job *batchv1.Job) error {
+	c.Counts.lock.Lock()
+	defer c.Counts.lock.Unlock()
+	job.Name = fmt.Sprintf("%s%d", job.GenerateName, len(c.Counts.Jobs))
+	c.Counts.Jobs = append(c.Counts.Jobs, *job)
+	return nil
+}
+
+// DeleteJob deletes a Job and its pods
+func (c mockK8sClient) DeleteJob(job *batchv1.Job) error {
+	c.Counts.lock.Lock()
+	defer c.Counts.lock.Unlock()
+	for i, existingJob := range c.Counts.Jobs {
+		if existingJob.Name == job.Name {
+			c.Counts.Jobs = append(c.Counts.Jobs[:i], c.Counts.Jobs[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("Could not find job %s", job.Name)
+}
+
+// WatchAgentPools sends an event each time an AzpAgentPool is created, updated or deleted, until stopCh is closed.
+func (c mockK8sClient) WatchAgentPools(namespace string, stopCh <-chan struct{}) (<-chan kubernetes.AgentPoolEvent, error) {
+	return make(chan kubernetes.AgentPoolEvent), nil
+}
+
+// UpdateAgentPoolStatus replaces the status of an AzpAgentPool
+func (c mockK8sClient) UpdateAgentPoolStatus(pool *kubernetes.AzpAgentPool, status kubernetes.AzpAgentPoolStatus) error {
+	pool.Status = status
+	return nil
+}
+
+// LeaderElect always acquires the leadership, and never loses it
+func (c mockK8sClient) LeaderElect(args args.LeaderElectionArgs, onStartedLeading func(stop <-chan struct{}), onStoppedLeading func()) error {
+	onStartedLeading(make(chan struct{}))
+	return nil
+}
+
+// GetConfigMapData retrieves the data of a ConfigMap
+func (c mockK8sClient) GetConfigMapData(namespace string, name string) (map[string]string, error) {
+	c.Counts.lock.Lock()
+	defer c.Counts.lock.Unlock()
+	data, exists := c.Counts.ConfigMaps[namespace+"/"+name]
+	if !exists {
+		return map[string]string{}, nil
+	}
+	return data, nil
+}
+
+// SetConfigMapData creates or replaces a ConfigMap
+func (c mockK8sClient) SetConfigMapData(namespace string, name string, data map[string]string) error {
+	c.Counts.lock.Lock()
+	defer c.Counts.lock.Unlock()
+	if c.Counts.ConfigMaps == nil {
+		c.Counts.ConfigMaps = make(map[string]map[string]string)
+	}
+	c.Counts.ConfigMaps[namespace+"/"+name] = data
+	return nil
+}
+
+// RecordEvent records the reason of an Event
+func (c mockK8sClient) RecordEvent(resource *kubernetes.Workload, eventType string, reason string, message string) {
+	c.Counts.lock.Lock()
+	defer c.Counts.lock.Unlock()
+	c.Counts.Events = append(c.Counts.Events, reason)
+}
+
+// WatchWorkload watches a workload and its pods until stopCh is closed. The mock pods never change.
+func (c mockK8sClient) WatchWorkload(workload *kubernetes.Workload, agentJobs bool, stopCh <-chan struct{}) (<-chan struct{}, error) {
+	return make(chan struct{}), nil
+}
@@ -0,0 +1,168 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/health"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
+)
+
+func TestHealthChecks(t *testing.T) {
+	tests := []struct {
+		name           string
+		leaderElection bool
+		ready          bool
+		leader         bool
+		expectedReadyz int
+		expectedLeader int
+	}{
+		{"starting", true, false, false, 503, 503},
+		{"follower", true, true, false, 503, 503},
+		{"leader", true, true, true, 200, 200},
+		{"lost_leadership", true, true, false, 503, 503},
+		{"starting_without_leader_election", false, false, true, 503, 200},
+		{"started_without_leader_election", false, true, true, 200, 200},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			leaderCheck := &health.LeaderCheck{}
+			readinessCheck := &health.ReadinessCheck{}
+			if test.leaderElection {
+				readinessCheck.Leader = leaderCheck
+			}
+			readinessCheck.SetReady(test.ready)
+			leaderCheck.SetLeader(test.leader)
+
+			readyz := httptest.NewRecorder()
+			readinessCheck.ServeHTTP(readyz, httptest.NewRequest("GET", "/readyz", nil))
+			if readyz.Code != test.expectedReadyz {
+				t.Errorf("Expected /readyz to return %d, but got %d", test.expectedReadyz, readyz.Code)
+			}
+			leader := httptest.NewRecorder()
+			leaderCheck.ServeHTTP(leader, httptest.NewRequest("GET", "/leader", nil))
+			if leader.Code != test.expectedLeader {
+				t.Errorf("Expected /leader to return %d, but got %d", test.expectedLeader, leader.Code)
+			}
+		})
+	}
+}
+
+func TestLeaderElect(t *testing.T) {
+	namespace := "leader-election"
+	clientset := fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "azp-agent-autoscaler-0",
+			Namespace: namespace,
+			Labels: map[string]string{
+				// Left over from a previous leadership
+				kubernetes.LeaderLabel: "true",
+			},
+		},
+	})
+	k8sClient := kubernetes.MakeFromClientsets(clientset, nil)
+	leaderArgs := args.LeaderElectionArgs{
+		Enabled:       true,
+		Name:          "azp-agent-autoscaler",
+		Namespace:     namespace,
+		LeaseDuration: 2 * time.Second,
+		RenewDeadline: time.Second,
+		RetryPeriod:   100 * time.Millisecond,
+		PodName:       "azp-agent-autoscaler-0",
+	}
+	leaderLabel := func() string {
+		pod, err := clientset.CoreV1().Pods(namespace).Get(leaderArgs.PodName, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return pod.Labels[kubernetes.LeaderLabel]
+	}
+
+	leaderCheck := &health.LeaderCheck{}
+	var scaling, scaled int32
+	labelsWhileLeading := make(chan string, 1)
+	returned := make(chan error)
+	go func() {
+		returned <- k8sClient.Sync().LeaderElect(leaderArgs, func(stop <-chan struct{}) {
+			leaderCheck.SetLeader(true)
+			if pod, err := clientset.CoreV1().Pods(namespace).Get(leaderArgs.PodName, metav1.GetOptions{}); err == nil {
+				labelsWhileLeading <- pod.Labels[kubernetes.LeaderLabel]
+			} else {
+				labelsWhileLeading <- err.Error()
+			}
+			atomic.StoreInt32(&scaling, 1)
+			<-stop
+			// Finish the scaling iteration in progress
+			time.Sleep(100 * time.Millisecond)
+			atomic.StoreInt32(&scaled, 1)
+		}, func() {
+			leaderCheck.SetLeader(false)
+		})
+	}()
+
+	waitFor(t, "the leadership", func() bool {
+		return atomic.LoadInt32(&scaling) == 1
+	})
+	if !leaderCheck.IsLeader() {
+		t.Fatal("Expected the replica to be the leader")
+	}
+	if label := <-labelsWhileLeading; label != "true" {
+		t.Fatalf("Expected the %s label of the leader pod to be true, but got %s", kubernetes.LeaderLabel, label)
+	}
+
+	// Another replica takes over the Lease, until the leadership is lost
+	leases := clientset.CoordinationV1().Leases(namespace)
+	other := "azp-agent-autoscaler-1"
+	leaseDurationSeconds := int32(60)
+	stealLease := func() {
+		lease, err := leases.Get(leaderArgs.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		now := metav1.NewMicroTime(time.Now())
+		lease.Spec = coordinationv1.LeaseSpec{
+			HolderIdentity:       &other,
+			LeaseDurationSeconds: &leaseDurationSeconds,
+			AcquireTime:          &now,
+			RenewTime:            &now,
+		}
+		if _, err := leases.Update(lease); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	ticker := time.NewTicker(leaderArgs.RetryPeriod)
+	defer ticker.Stop()
+	timeout := time.After(5 * time.Second)
+	for lost := false; !lost; {
+		select {
+		case err := <-returned:
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			lost = true
+		case <-ticker.C:
+			stealLease()
+		case <-timeout:
+			t.Fatal("Timed out waiting for the leadership to be lost")
+		}
+	}
+
+	if atomic.LoadInt32(&scaled) != 1 {
+		t.Fatal("Expected LeaderElect to return after the leader stopped scaling")
+	}
+	if leaderCheck.IsLeader() {
+		t.Fatal("Expected the replica to not be the leader")
+	}
+	if label := leaderLabel(); label != "false" {
+		t.Fatalf("Expected the %s label of the former leader pod to be false, but got %s", kubernetes.LeaderLabel, label)
+	}
+}
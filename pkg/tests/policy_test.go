@@ -0,0 +1,203 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/scaling"
+)
+
+func TestScalingPolicies(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       args.PolicyArgs
+		activeAgents int32
+		queuedJobs   int32
+		min          int32
+		expected     int32
+	}{
+		{"default", args.PolicyArgs{Type: "Default"}, 3, 2, 1, 6},
+		{"default_empty", args.PolicyArgs{}, 3, 2, 1, 6},
+		{"fixed_buffer", args.PolicyArgs{Type: "FixedBuffer", Buffer: 4}, 3, 2, 1, 9},
+		{"fixed_buffer_below_min", args.PolicyArgs{Type: "FixedBuffer", Buffer: 1}, 3, 2, 2, 7},
+		{"fixed_buffer_idle", args.PolicyArgs{Type: "FixedBuffer", Buffer: 4}, 0, 0, 1, 1},
+		{"step", args.PolicyArgs{Type: "Step", Step: 5}, 3, 2, 1, 10},
+		{"step_exact", args.PolicyArgs{Type: "Step", Step: 5}, 3, 1, 1, 5},
+		{"step_zero", args.PolicyArgs{Type: "step", Step: 5}, 0, 0, 0, 0},
+		{"percentage_floor", args.PolicyArgs{Type: "PercentageBuffer", Percent: 20, Floor: 1, Ceiling: 10}, 3, 0, 0, 4},
+		{"percentage_rounded_up", args.PolicyArgs{Type: "PercentageBuffer", Percent: 20, Floor: 1, Ceiling: 10}, 11, 0, 0, 14},
+		{"percentage", args.PolicyArgs{Type: "PercentageBuffer", Percent: 20, Floor: 1, Ceiling: 10}, 30, 2, 0, 38},
+		{"percentage_ceiling", args.PolicyArgs{Type: "PercentageBuffer", Percent: 20, Floor: 1, Ceiling: 10}, 100, 0, 0, 110},
+		{"percentage_unlimited", args.PolicyArgs{Type: "PercentageBuffer", Percent: 20, Floor: 1, Ceiling: 0}, 100, 0, 0, 120},
+		{"percentage_min", args.PolicyArgs{Type: "PercentageBuffer", Percent: 20, Floor: 1, Ceiling: 10}, 3, 0, 2, 5},
+		{"percentage_queued_only", args.PolicyArgs{Type: "PercentageBuffer", Percent: 20, Floor: 1, Ceiling: 10}, 0, 2, 0, 3},
+		{"percentage_idle", args.PolicyArgs{Type: "PercentageBuffer", Percent: 20, Floor: 1, Ceiling: 10}, 0, 0, 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy, err := scaling.MakeScalingPolicy(test.policy)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			desired, reason := policy.DesiredReplicas(scaling.Observation{
+				Pods:         3,
+				RunningPods:  3,
+				ActiveAgents: test.activeAgents,
+				QueuedJobs:   test.queuedJobs,
+				Min:          test.min,
+				Max:          100,
+				Time:         time.Now(),
+			})
+			if desired != test.expected {
+				t.Fatalf("Expected %d replicas, but got %d (%s)", test.expected, desired, reason)
+			}
+		})
+	}
+
+	if _, err := scaling.MakeScalingPolicy(args.PolicyArgs{Type: "Unknown"}); err == nil {
+		t.Fatal("Expected an error for an unknown policy")
+	}
+}
+
+func TestAutoscalePolicies(t *testing.T) {
+	tests := []struct {
+		name             string
+		policy           args.PolicyArgs
+		expectedPodCount int32
+	}{
+		{"default", args.PolicyArgs{Type: "Default"}, 2},
+		{"fixed_buffer", args.PolicyArgs{Type: "FixedBuffer", Buffer: 3}, 4},
+		{"step", args.PolicyArgs{Type: "Step", Step: 4}, 4},
+		{"step_max", args.PolicyArgs{Type: "Step", Step: 8}, 5},
+		{"percentage_buffer", args.PolicyArgs{Type: "PercentageBuffer", Percent: 50, Floor: 2, Ceiling: 10}, 3},
+	}
+
+	for i, test := range tests {
+		poolID := 400 + i
+		t.Run(test.name, func(t *testing.T) {
+			azdClient := mockAZDClient{
+				NumPools:                5,
+				ErrorListPools:          false,
+				NumFreeAgents:           0,
+				NumRunningAgents:        0,
+				ErrorAgents:             false,
+				NumQueuedJobs:           1,
+				ErrorJobs:               false,
+				FreeAgentsFirst:         false,
+				QueuedJobsMatchNoAgents: true,
+			}
+
+			args := args.Args{
+				Min:  1,
+				Max:  5,
+				Rate: 10 * time.Second,
+				ScaleDown: args.ScaleDownArgs{
+					Delay: 0 * time.Nanosecond,
+					Max:   10,
+				},
+				Policy: test.policy,
+				Kubernetes: args.KubernetesArgs{
+					Type:      "StatefulSet",
+					Name:      "azp-agent-policy-" + test.name,
+					Namespace: "default",
+				},
+				AZD: args.AzureDevopsArgs{
+					Token: "azdtoken",
+					URL:   "https://dev.azure.com/organization",
+				},
+			}
+
+			k8sClient := mockK8sClient{
+				Counts: &mockK8sClientCounts{
+					NumPods: 0,
+				},
+				HPAExists: false,
+			}
+
+			err := scaling.Autoscale(azdClient, poolID, kubernetes.MakeFromClient(k8sClient), k8sClient.GetWorkloadNoError(args.Kubernetes), args)
+			if err != nil {
+				t.Error(err.Error())
+			}
+
+			if k8sClient.Counts.NumPods != test.expectedPodCount {
+				t.Fatalf("Expected %d pods, but got %d", test.expectedPodCount, k8sClient.Counts.NumPods)
+			}
+		})
+	}
+}
+
+func TestAutoscalePercentageBuffer(t *testing.T) {
+	tests := []struct {
+		activeAgents     int32
+		freeAgents       int32
+		max              int32
+		expectedPodCount int32
+	}{
+		{3, 0, 100, 4},
+		{10, 0, 100, 12},
+		{10, 5, 100, 12},
+		{50, 0, 100, 60},
+		{90, 0, 95, 95},
+	}
+
+	for i, test := range tests {
+		poolID := 500 + i
+		t.Run(fmt.Sprintf("%d_activeagents,%d_freeagents,%d_max", test.activeAgents, test.freeAgents, test.max), func(t *testing.T) {
+			azdClient := mockAZDClient{
+				NumPools:         5,
+				ErrorListPools:   false,
+				NumFreeAgents:    test.freeAgents,
+				NumRunningAgents: test.activeAgents,
+				ErrorAgents:      false,
+				NumQueuedJobs:    0,
+				ErrorJobs:        false,
+				FreeAgentsFirst:  false,
+			}
+
+			args := args.Args{
+				Min:  1,
+				Max:  test.max,
+				Rate: 10 * time.Second,
+				ScaleDown: args.ScaleDownArgs{
+					Delay: 0 * time.Nanosecond,
+					Max:   10,
+				},
+				Policy: args.PolicyArgs{
+					Type:    "PercentageBuffer",
+					Percent: 20,
+					Floor:   1,
+					Ceiling: 10,
+				},
+				Kubernetes: args.KubernetesArgs{
+					Type:      "StatefulSet",
+					Name:      "azp-agent",
+					Namespace: "default",
+				},
+				AZD: args.AzureDevopsArgs{
+					Token: "azdtoken",
+					URL:   "https://dev.azure.com/organization",
+				},
+			}
+
+			k8sClient := mockK8sClient{
+				Counts: &mockK8sClientCounts{
+					NumPods: test.activeAgents + test.freeAgents,
+				},
+				HPAExists: false,
+			}
+
+			err := scaling.Autoscale(azdClient, poolID, kubernetes.MakeFromClient(k8sClient), k8sClient.GetWorkloadNoError(args.Kubernetes), args)
+			if err != nil {
+				t.Error(err.Error())
+			}
+
+			if k8sClient.Counts.NumPods != test.expectedPodCount {
+				t.Fatalf("Expected %d pods, but got %d", test.expectedPodCount, k8sClient.Counts.NumPods)
+			}
+		})
+	}
+}
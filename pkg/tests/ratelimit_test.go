@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/azuredevops"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		value    string
+		expected *time.Duration
+	}{
+		{"empty", "", nil},
+		{"invalid", "soon", nil},
+		{"seconds", "120", durationPtr(120 * time.Second)},
+		{"date", now.Add(30 * time.Second).Format(http.TimeFormat), durationPtr(30 * time.Second)},
+		{"past_date", now.Add(-30 * time.Second).Format(http.TimeFormat), durationPtr(0)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			retryAfter := azuredevops.ParseRetryAfter(test.value, now)
+			if test.expected == nil && retryAfter != nil {
+				t.Fatalf("Expected no Retry-After, but got %s", retryAfter.String())
+			} else if test.expected != nil && (retryAfter == nil || *retryAfter != *test.expected) {
+				t.Fatalf("Expected a Retry-After of %s, but got %v", test.expected.String(), retryAfter)
+			}
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	reset := now.Add(60 * time.Second).Unix()
+	tests := []struct {
+		name     string
+		header   map[string]string
+		expected time.Duration
+	}{
+		{"no_headers", map[string]string{}, 0},
+		{"under_limit", map[string]string{"X-RateLimit-Limit": "200", "X-RateLimit-Remaining": "150", "X-RateLimit-Reset": strconv.FormatInt(reset, 10)}, 0},
+		{"near_limit", map[string]string{"X-RateLimit-Limit": "200", "X-RateLimit-Remaining": "10", "X-RateLimit-Reset": strconv.FormatInt(reset, 10)}, 6 * time.Second},
+		{"at_limit", map[string]string{"X-RateLimit-Limit": "200", "X-RateLimit-Remaining": "0", "X-RateLimit-Reset": strconv.FormatInt(reset, 10)}, 60 * time.Second},
+		{"retry_after", map[string]string{"Retry-After": "30"}, 30 * time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := http.Header{}
+			for key, value := range test.header {
+				header.Set(key, value)
+			}
+			rateLimiter := &azuredevops.RateLimiter{}
+			rateLimiter.Observe(header, now)
+			if delay := rateLimiter.Delay(now); delay != test.expected {
+				t.Fatalf("Expected a delay of %s, but got %s", test.expected.String(), delay.String())
+			}
+		})
+	}
+}
+
+func TestHTTPErrorRetryAfter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Retry-After", "1")
+		writer.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	azdClient, err := azuredevops.MakeClient(args.AzureDevopsArgs{URL: server.URL, Token: "azdtoken"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	poolsChan := make(chan azuredevops.PoolDetailsResponse)
+	go azdClient.ListPoolsAsync(poolsChan)
+	pools := <-poolsChan
+	httpErr, ok := pools.Err.(*azuredevops.HTTPError)
+	if !ok {
+		t.Fatalf("Expected an HTTPError, but got %v", pools.Err)
+	}
+	if httpErr.RetryAfter == nil || *httpErr.RetryAfter != time.Second {
+		t.Fatalf("Expected a Retry-After of 1s, but got %v", httpErr.RetryAfter)
+	}
+}
+
+func durationPtr(duration time.Duration) *time.Duration {
+	return &duration
+}
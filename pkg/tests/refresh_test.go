@@ -0,0 +1,134 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/retry"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/scaling"
+)
+
+func TestWorkloadRefresher(t *testing.T) {
+	tests := []struct {
+		name           string
+		poolName       string
+		refreshAfter   time.Duration
+		modifyWorkload func(workload *kubernetes.Workload)
+		expectedPoolID int
+		expectedEvents []string
+	}{
+		{"before_refresh_interval", "pool-3", time.Second, nil, 1, []string{}},
+		{"pool_changed", "pool-3", 2 * time.Minute, nil, 3, []string{kubernetes.EventReasonAgentPoolChanged}},
+		{"pool_unchanged", "pool-1", 2 * time.Minute, nil, 1, []string{}},
+		{"unknown_pool", "unknown", 2 * time.Minute, nil, 1, []string{kubernetes.EventReasonFailedGetAgentPool}},
+		// The workload being autoscaled differs from the refreshed workload
+		{"scaled", "pool-3", time.Second, func(workload *kubernetes.Workload) {
+			workload.Generation = 5
+		}, 1, []string{}},
+		{"pod_template_changed", "pool-3", time.Second, func(workload *kubernetes.Workload) {
+			workload.PodTemplateSpec.Annotations = map[string]string{"restartedAt": "2026-10-18T00:00:00Z"}
+		}, 3, []string{kubernetes.EventReasonAgentPoolChanged}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			azdClient := mockAZDClient{
+				NumPools: 5,
+			}
+
+			args := args.Args{
+				Min:             1,
+				Max:             100,
+				Rate:            10 * time.Second,
+				PoolName:        test.poolName,
+				RefreshInterval: time.Minute,
+				Kubernetes: args.KubernetesArgs{
+					Type:      "StatefulSet",
+					Name:      "azp-agent",
+					Namespace: "refresh-" + test.name,
+				},
+			}
+
+			k8sClient := mockK8sClient{
+				Counts:    &mockK8sClientCounts{},
+				HPAExists: false,
+			}
+			workload := k8sClient.GetWorkloadNoError(args.Kubernetes)
+			if test.modifyWorkload != nil {
+				test.modifyWorkload(workload)
+			}
+
+			stop := make(chan struct{})
+			defer close(stop)
+			refresher, err := scaling.MakeWorkloadRefresher(azdClient, kubernetes.MakeFromClient(k8sClient), args, workload, 1, stop)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			if err := refresher.Refresh(time.Now().Add(test.refreshAfter)); err != nil {
+				t.Fatal(err.Error())
+			}
+			if refresher.AgentPoolID != test.expectedPoolID {
+				t.Errorf("Expected agent pool %d, but got %d", test.expectedPoolID, refresher.AgentPoolID)
+			}
+			if len(k8sClient.Counts.Events) != len(test.expectedEvents) {
+				t.Fatalf("Expected the events %v, but got %v", test.expectedEvents, k8sClient.Counts.Events)
+			}
+			for i, event := range test.expectedEvents {
+				if k8sClient.Counts.Events[i] != event {
+					t.Fatalf("Expected the events %v, but got %v", test.expectedEvents, k8sClient.Counts.Events)
+				}
+			}
+		})
+	}
+}
+
+// deletedWorkloadK8sClient is a mockK8sClient whose workload was deleted
+type deletedWorkloadK8sClient struct {
+	mockK8sClient
+}
+
+// GetWorkload returns a NotFound error
+func (c deletedWorkloadK8sClient) GetWorkload(args args.KubernetesArgs) (*kubernetes.Workload, error) {
+	return nil, apierrors.NewNotFound(schema.GroupResource{Group: "apps", Resource: "statefulsets"}, args.Name)
+}
+
+func TestWorkloadRefresherDeletedWorkload(t *testing.T) {
+	args := args.Args{
+		Min:             1,
+		Max:             100,
+		Rate:            10 * time.Second,
+		RefreshInterval: time.Minute,
+		Kubernetes: args.KubernetesArgs{
+			Type:      "StatefulSet",
+			Name:      "azp-agent",
+			Namespace: "refresh-deleted",
+		},
+	}
+
+	k8sClient := deletedWorkloadK8sClient{mockK8sClient{
+		Counts:    &mockK8sClientCounts{},
+		HPAExists: false,
+	}}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	refresher, err := scaling.MakeWorkloadRefresher(mockAZDClient{NumPools: 5}, kubernetes.MakeFromClient(k8sClient), args, k8sClient.GetWorkloadNoError(args.Kubernetes), 1, stop)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	err = refresher.Refresh(time.Now())
+	if err == nil {
+		t.Fatal("Expected an error refreshing a deleted workload")
+	}
+	// Restarting won't bring the workload back
+	if retry.IsRetryable(err) {
+		t.Fatalf("Expected the error to not be retryable: %s", err.Error())
+	}
+}
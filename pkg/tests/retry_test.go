@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/azuredevops"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/retry"
+)
+
+func TestIsRetryable(t *testing.T) {
+	statefulSets := schema.GroupResource{Group: "apps", Resource: "statefulsets"}
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"azd_429", &azuredevops.HTTPError{StatusCode: 429}, true},
+		{"azd_503", &azuredevops.HTTPError{StatusCode: 503}, true},
+		{"azd_401", &azuredevops.HTTPError{StatusCode: 401}, false},
+		{"azd_404", azuredevops.HTTPError{StatusCode: 404}, false},
+		{"k8s_timeout", apierrors.NewServerTimeout(statefulSets, "get", 1), true},
+		{"k8s_internal", apierrors.NewInternalError(fmt.Errorf("etcd")), true},
+		{"k8s_conflict", apierrors.NewConflict(statefulSets, "azp-agent", fmt.Errorf("conflict")), true},
+		{"k8s_not_found", apierrors.NewNotFound(statefulSets, "azp-agent"), false},
+		{"k8s_forbidden", apierrors.NewForbidden(statefulSets, "azp-agent", fmt.Errorf("forbidden")), false},
+		{"unknown", fmt.Errorf("unknown"), true},
+		{"wrapped_k8s_not_found", fmt.Errorf("Error retrieving statefulset/azp-agent: %w", apierrors.NewNotFound(statefulSets, "azp-agent")), false},
+		{"wrapped_azd_401", fmt.Errorf("Error retrieving agent pools: %w", &azuredevops.HTTPError{StatusCode: 401}), false},
+		{"wrapped_azd_503", fmt.Errorf("Error retrieving agent pools: %w", &azuredevops.HTTPError{StatusCode: 503}), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if retryable := retry.IsRetryable(test.err); retryable != test.retryable {
+				t.Fatalf("Expected retryable to be %t, but got %t", test.retryable, retryable)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	rate := 10 * time.Second
+	policy := retry.MakePolicy(rate, time.Minute, args.RetryArgs{FailureThreshold: 5, MaxBackoff: 60 * time.Second}, "default", "statefulset/azp-agent")
+
+	t.Run("backoff", func(t *testing.T) {
+		for failures, expected := range map[int]time.Duration{1: 10 * time.Second, 2: 20 * time.Second, 3: 40 * time.Second, 4: 60 * time.Second, 10: 60 * time.Second} {
+			backoff := policy.Backoff(failures)
+			if backoff < expected*8/10 || backoff > expected*12/10 {
+				t.Errorf("Expected a backoff of about %s after %d failures, but got %s", expected.String(), failures, backoff.String())
+			}
+		}
+	})
+
+	t.Run("retry_after", func(t *testing.T) {
+		retryAfter := 5 * time.Minute
+		backoff, err := policy.Failure(&azuredevops.HTTPError{StatusCode: 429, RetryAfter: &retryAfter})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if backoff != retryAfter {
+			t.Fatalf("Expected a backoff of %s, but got %s", retryAfter.String(), backoff.String())
+		}
+		policy.Success()
+	})
+
+	t.Run("fatal", func(t *testing.T) {
+		if _, err := policy.Failure(&azuredevops.HTTPError{StatusCode: 401}); err == nil {
+			t.Fatal("Expected an error")
+		}
+		policy.Success()
+	})
+
+	t.Run("failure_threshold", func(t *testing.T) {
+		for i := 1; i <= 5; i++ {
+			_, err := policy.Failure(&azuredevops.HTTPError{StatusCode: 500})
+			if i < 5 && err != nil {
+				t.Fatalf("Expected failure %d to be retried, but got %s", i, err.Error())
+			} else if i == 5 && err == nil {
+				t.Fatal("Expected an error after 5 failures")
+			} else if i == 5 && !retry.IsRetryable(err) {
+				// The autoscaling is restarted after too many transient failures
+				t.Fatal("Expected the error after 5 transient failures to be retryable")
+			}
+		}
+		if pollInterval := policy.Success(); pollInterval != time.Minute {
+			t.Fatalf("Expected the poll interval after a success, but got %s", pollInterval.String())
+		}
+	})
+}
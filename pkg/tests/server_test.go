@@ -0,0 +1,247 @@
+package tests
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/azuredevops"
+)
+
+// mockAZDServer is a fake Azure Devops Server collection
+type mockAZDServer struct {
+	collection string
+	// Whether the server lists its resource locations
+	resourceLocations bool
+	// The time the server takes to list its resource locations
+	resourceLocationsDelay time.Duration
+
+	// The requests received, ex: GET pools 4.1
+	requests []string
+	lock     sync.Mutex
+}
+
+func (s *mockAZDServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if _, password, ok := request.BasicAuth(); !ok || password != "azdtoken" {
+		writer.WriteHeader(401)
+		return
+	}
+	path := strings.TrimPrefix(request.URL.Path, s.collection+"/_apis")
+	if path == request.URL.Path {
+		writer.WriteHeader(404)
+		return
+	}
+
+	var resource string
+	var response interface{}
+	if request.Method == "OPTIONS" && path == "" && s.resourceLocations {
+		resource = "locations"
+		time.Sleep(s.resourceLocationsDelay)
+		response = azuredevops.ResourceLocations{Count: 3, Value: []azuredevops.ResourceLocation{
+			{Area: "distributedtask", ResourceName: "pools", ResourceVersion: 1, MinVersion: "3.0", MaxVersion: "4.1", ReleasedVersion: "4.1"},
+			{Area: "distributedtask", ResourceName: "agents", ResourceVersion: 1, MinVersion: "3.0", MaxVersion: "4.1", ReleasedVersion: "4.1"},
+			{Area: "distributedtask", ResourceName: "jobrequests", ResourceVersion: 1, MinVersion: "3.0", MaxVersion: "4.1", ReleasedVersion: "0.0"},
+		}}
+	} else if request.Method == "GET" && path == "/distributedtask/pools" {
+		resource = "pools"
+		response = azuredevops.PoolList{Count: 1, Value: PoolDetails(1, 1)}
+	} else if request.Method == "GET" && path == "/distributedtask/pools/1/agents" {
+		resource = "agents"
+		response = azuredevops.Pool{Count: 1, Value: Agents(1, true, 0)}
+	} else if request.Method == "GET" && path == "/distributedtask/pools/1/jobrequests" {
+		resource = "jobrequests"
+		response = azuredevops.JobRequests{}
+	} else {
+		writer.WriteHeader(404)
+		return
+	}
+
+	s.lock.Lock()
+	s.requests = append(s.requests, strings.TrimSpace(request.Method+" "+resource+" "+strings.TrimPrefix(request.Header.Get("Accept"), "application/json;api-version=")))
+	s.lock.Unlock()
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(200)
+	json.NewEncoder(writer).Encode(response)
+}
+
+func TestAzureDevopsServer(t *testing.T) {
+	tests := []struct {
+		name              string
+		path              string
+		resourceLocations bool
+		apiVersion        string
+		caBundle          bool
+		insecure          bool
+		expectedError     bool
+		expectedRequests  []string
+	}{
+		{"negotiated", "/tfs/DefaultCollection", true, "", true, false, false, []string{"OPTIONS locations application/json", "GET pools 4.1", "GET agents 4.1", "GET jobrequests 4.1-preview.1", "GET pools 4.1"}},
+		{"trailing_slash", "/tfs/DefaultCollection/", true, "", true, false, false, []string{"OPTIONS locations application/json", "GET pools 4.1", "GET agents 4.1", "GET jobrequests 4.1-preview.1", "GET pools 4.1"}},
+		{"apis_path", "/tfs/DefaultCollection/_apis/", true, "", true, false, false, []string{"OPTIONS locations application/json", "GET pools 4.1", "GET agents 4.1", "GET jobrequests 4.1-preview.1", "GET pools 4.1"}},
+		{"configured_api_version", "/tfs/DefaultCollection", true, "4.0", true, false, false, []string{"GET pools 4.0", "GET agents 4.0", "GET jobrequests 4.0", "GET pools 4.0"}},
+		{"no_resource_locations", "/tfs/DefaultCollection", false, "", true, false, false, []string{"GET pools 5.0-preview.1", "GET agents 5.0-preview.1", "GET jobrequests 5.0-preview.1", "GET pools 5.0-preview.1"}},
+		{"insecure", "/tfs/DefaultCollection", true, "", false, true, false, []string{"OPTIONS locations application/json", "GET pools 4.1", "GET agents 4.1", "GET jobrequests 4.1-preview.1", "GET pools 4.1"}},
+		{"untrusted_certificate", "/tfs/DefaultCollection", true, "", false, false, true, []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mockServer := &mockAZDServer{collection: "/tfs/DefaultCollection", resourceLocations: test.resourceLocations}
+			server := httptest.NewTLSServer(mockServer)
+			defer server.Close()
+
+			azdArgs := args.AzureDevopsArgs{
+				URL:                   server.URL + test.path,
+				Token:                 "azdtoken",
+				APIVersion:            test.apiVersion,
+				InsecureSkipTLSVerify: test.insecure,
+			}
+			if test.caBundle {
+				caBundle, err := ioutil.TempFile("", "ca-bundle")
+				if err != nil {
+					t.Fatal(err.Error())
+				}
+				defer os.Remove(caBundle.Name())
+				pem.Encode(caBundle, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+				caBundle.Close()
+				azdArgs.CABundle = caBundle.Name()
+			}
+
+			azdClient, err := azuredevops.MakeClient(azdArgs)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+
+			poolsChan := make(chan azuredevops.PoolDetailsResponse)
+			go azdClient.ListPoolsAsync(poolsChan)
+			pools := <-poolsChan
+			if test.expectedError {
+				if pools.Err == nil {
+					t.Fatal("Expected an error")
+				}
+				return
+			} else if pools.Err != nil {
+				t.Fatal(pools.Err.Error())
+			}
+			if len(pools.Pools) != 1 || pools.Pools[0].ID != 1 {
+				t.Fatalf("Expected agent pool 1, but got %v", pools.Pools)
+			}
+
+			agentsChan := make(chan azuredevops.PoolAgentsResponse)
+			go azdClient.ListPoolAgentsAsync(agentsChan, 1)
+			if agents := <-agentsChan; agents.Err != nil || len(agents.Agents) != 1 {
+				t.Fatalf("Expected 1 agent, but got %v (error: %v)", agents.Agents, agents.Err)
+			}
+			jobsChan := make(chan azuredevops.JobRequestsResponse)
+			go azdClient.ListJobRequestsAsync(jobsChan, 1)
+			if jobs := <-jobsChan; jobs.Err != nil {
+				t.Fatal(jobs.Err.Error())
+			}
+			// The API versions are only negotiated once
+			go azdClient.ListPoolsAsync(poolsChan)
+			if pools := <-poolsChan; pools.Err != nil {
+				t.Fatal(pools.Err.Error())
+			}
+
+			if strings.Join(mockServer.requests, ", ") != strings.Join(test.expectedRequests, ", ") {
+				t.Fatalf("Expected the requests %v, but got %v", test.expectedRequests, mockServer.requests)
+			}
+		})
+	}
+}
+
+func TestAzureDevopsServerConcurrentNegotiation(t *testing.T) {
+	mockServer := &mockAZDServer{collection: "/tfs/DefaultCollection", resourceLocations: true, resourceLocationsDelay: 200 * time.Millisecond}
+	server := httptest.NewTLSServer(mockServer)
+	defer server.Close()
+
+	azdClient, err := azuredevops.MakeClient(args.AzureDevopsArgs{
+		URL:                   server.URL + "/tfs/DefaultCollection",
+		Token:                 "azdtoken",
+		InsecureSkipTLSVerify: true,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// The calls made during the negotiation wait for it, and the API versions are only negotiated once
+	poolsChan := make(chan azuredevops.PoolDetailsResponse)
+	for i := 0; i < 3; i++ {
+		go azdClient.ListPoolsAsync(poolsChan)
+	}
+	for i := 0; i < 3; i++ {
+		if pools := <-poolsChan; pools.Err != nil {
+			t.Fatal(pools.Err.Error())
+		}
+	}
+
+	expectedRequests := []string{"OPTIONS locations application/json", "GET pools 4.1", "GET pools 4.1", "GET pools 4.1"}
+	if strings.Join(mockServer.requests, ", ") != strings.Join(expectedRequests, ", ") {
+		t.Fatalf("Expected the requests %v, but got %v", expectedRequests, mockServer.requests)
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		url           string
+		expected      string
+		expectedError bool
+	}{
+		{"https://dev.azure.com/organization", "https://dev.azure.com/organization", false},
+		{"https://dev.azure.com/organization/", "https://dev.azure.com/organization", false},
+		{"https://tfs.example.com/tfs/DefaultCollection", "https://tfs.example.com/tfs/DefaultCollection", false},
+		{"https://tfs.example.com/tfs/DefaultCollection//", "https://tfs.example.com/tfs/DefaultCollection", false},
+		{"https://tfs.example.com:8443/DefaultCollection/_apis", "https://tfs.example.com:8443/DefaultCollection", false},
+		{"http://tfs:8080/tfs/DefaultCollection", "http://tfs:8080/tfs/DefaultCollection", false},
+		{"tfs.example.com/tfs/DefaultCollection", "", true},
+		{"https://", "", true},
+		{"https://tfs.example.com/tfs/DefaultCollection?api-version=5.0", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			normalized, err := azuredevops.NormalizeURL(test.url)
+			if test.expectedError {
+				if err == nil {
+					t.Fatalf("Expected an error, but got %s", normalized)
+				}
+			} else if err != nil {
+				t.Fatal(err.Error())
+			} else if normalized != test.expected {
+				t.Fatalf("Expected %s, but got %s", test.expected, normalized)
+			}
+		})
+	}
+}
+
+func TestNegotiateAPIVersion(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested string
+		location  azuredevops.ResourceLocation
+		expected  string
+	}{
+		{"released", "5.0", azuredevops.ResourceLocation{ResourceVersion: 1, MinVersion: "3.0", MaxVersion: "7.1", ReleasedVersion: "7.0"}, "5.0"},
+		{"preview", "5.0", azuredevops.ResourceLocation{ResourceVersion: 1, MinVersion: "3.0", MaxVersion: "7.1", ReleasedVersion: "0.0"}, "5.0-preview.1"},
+		{"older_server", "5.0", azuredevops.ResourceLocation{ResourceVersion: 2, MinVersion: "3.0", MaxVersion: "4.1", ReleasedVersion: "4.0"}, "4.1-preview.2"},
+		{"older_server_released", "5.0", azuredevops.ResourceLocation{ResourceVersion: 1, MinVersion: "3.0", MaxVersion: "4.1", ReleasedVersion: "4.1"}, "4.1"},
+		{"newer_server", "5.0", azuredevops.ResourceLocation{ResourceVersion: 1, MinVersion: "6.0", MaxVersion: "7.1", ReleasedVersion: "7.0"}, "6.0"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if version := azuredevops.NegotiateAPIVersion(test.requested, test.location); version != test.expected {
+				t.Fatalf("Expected API version %s, but got %s", test.expected, version)
+			}
+		})
+	}
+}
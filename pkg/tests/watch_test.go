@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/args"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/kubernetes"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/scaling"
+)
+
+func agentPod(namespace string, name string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				"app": "azp-agent",
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+		},
+	}
+}
+
+// expectTrigger fails the test if the channel doesn't receive within the timeout, or receives when it isn't expected
+func expectTrigger(t *testing.T, description string, trigger <-chan struct{}, expected bool) {
+	select {
+	case <-trigger:
+		if !expected {
+			t.Fatalf("Expected no trigger after %s", description)
+		}
+	case <-time.After(2 * time.Second):
+		if expected {
+			t.Fatalf("Expected a trigger after %s", description)
+		}
+	}
+}
+
+func TestWatchWorkload(t *testing.T) {
+	namespace := "watch"
+	replicas := int32(1)
+	statefulSet := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "azp-agent",
+			Namespace:  namespace,
+			Generation: 1,
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"app": "azp-agent",
+				},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						"app": "azp-agent",
+					},
+				},
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(statefulSet, agentPod(namespace, "azp-agent-0"))
+	k8sClient := kubernetes.MakeFromClientsets(clientset, nil).Sync()
+	kubernetesArgs := args.KubernetesArgs{Type: "StatefulSet", Name: "azp-agent", Namespace: namespace}
+
+	workload, err := k8sClient.GetWorkload(kubernetesArgs)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	trigger, err := k8sClient.WatchWorkload(workload, false, stop)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// The pods listed by the informer count as added
+	expectTrigger(t, "the pods were listed", trigger, true)
+
+	// The workload and the pods are read from the informer caches
+	calls := len(clientset.Actions())
+	for i := 0; i < 3; i++ {
+		if _, err := k8sClient.GetWorkload(kubernetesArgs); err != nil {
+			t.Fatal(err.Error())
+		}
+		if pods, err := k8sClient.GetPods(workload); err != nil {
+			t.Fatal(err.Error())
+		} else if len(pods) != 1 {
+			t.Fatalf("Expected 1 pod, but got %d", len(pods))
+		}
+	}
+	if len(clientset.Actions()) != calls {
+		t.Fatalf("Expected no calls to the API server, but got %v", clientset.Actions()[calls:])
+	}
+
+	// Pods are added
+	if _, err := clientset.CoreV1().Pods(namespace).Create(agentPod(namespace, "azp-agent-1")); err != nil {
+		t.Fatal(err.Error())
+	}
+	expectTrigger(t, "a pod was added", trigger, true)
+	if pods, err := k8sClient.GetPods(workload); err != nil {
+		t.Fatal(err.Error())
+	} else if len(pods) != 2 || pods[0].Name != "azp-agent-0" || pods[1].Name != "azp-agent-1" {
+		t.Fatalf("Expected the pods azp-agent-0 and azp-agent-1, but got %v", pods)
+	}
+
+	// The workload is scaled, which changes its generation but not its spec hash
+	replicas = 2
+	statefulSet.Generation = 2
+	if _, err := clientset.AppsV1().StatefulSets(namespace).Update(statefulSet); err != nil {
+		t.Fatal(err.Error())
+	}
+	expectTrigger(t, "the workload was scaled", trigger, false)
+	if cached, err := k8sClient.GetWorkload(kubernetesArgs); err != nil {
+		t.Fatal(err.Error())
+	} else if cached.Generation != 2 || cached.SpecHash() != workload.SpecHash() {
+		t.Fatalf("Expected generation 2 of the workload with the same spec hash, but got generation %d", cached.Generation)
+	}
+
+	// The pod template changes
+	statefulSet.Generation = 3
+	statefulSet.Spec.Template.Spec.Containers = []corev1.Container{{Name: "agent", Image: "azp-agent:2"}}
+	if _, err := clientset.AppsV1().StatefulSets(namespace).Update(statefulSet); err != nil {
+		t.Fatal(err.Error())
+	}
+	expectTrigger(t, "the pod template changed", trigger, true)
+	if cached, err := k8sClient.GetWorkload(kubernetesArgs); err != nil {
+		t.Fatal(err.Error())
+	} else if cached.SpecHash() == workload.SpecHash() {
+		t.Fatal("Expected the spec hash to change with the pod template")
+	}
+}
+
+func TestWaitForNextIteration(t *testing.T) {
+	tests := []struct {
+		name             string
+		triggered        bool
+		stopped          bool
+		sinceLastIter    time.Duration
+		timeToSleep      time.Duration
+		minInterval      time.Duration
+		expectedNextIter bool
+		expectedMinWait  time.Duration
+		expectedMaxWait  time.Duration
+	}{
+		{"rate", false, false, 0, 100 * time.Millisecond, time.Second, true, 100 * time.Millisecond, 500 * time.Millisecond},
+		{"stopped", false, true, 0, time.Minute, time.Second, false, 0, 400 * time.Millisecond},
+		{"triggered", true, false, time.Second, time.Minute, 200 * time.Millisecond, true, 0, 400 * time.Millisecond},
+		{"triggered_within_min_interval", true, false, 0, time.Minute, 300 * time.Millisecond, true, 300 * time.Millisecond, 700 * time.Millisecond},
+		{"min_interval_longer_than_rate", true, false, 0, 200 * time.Millisecond, time.Minute, true, 200 * time.Millisecond, 600 * time.Millisecond},
+		{"no_min_interval", true, false, 0, time.Minute, 0, true, 0, 400 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stop := make(chan struct{})
+			podsChanged := make(chan struct{}, 1)
+			notified := make(chan struct{})
+			if test.stopped {
+				close(stop)
+			}
+			if test.triggered {
+				podsChanged <- struct{}{}
+				// Later triggers are coalesced
+				close(notified)
+			}
+
+			start := time.Now()
+			nextIteration := scaling.WaitForNextIteration(stop, podsChanged, notified, start.Add(-test.sinceLastIter), test.timeToSleep, test.minInterval)
+			waited := time.Since(start)
+			if nextIteration != test.expectedNextIter {
+				t.Fatalf("Expected %t, but got %t", test.expectedNextIter, nextIteration)
+			}
+			if waited < test.expectedMinWait || waited > test.expectedMaxWait {
+				t.Fatalf("Expected to wait between %s and %s, but waited %s", test.expectedMinWait.String(), test.expectedMaxWait.String(), waited.String())
+			}
+		})
+	}
+}
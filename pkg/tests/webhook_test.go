@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/scaling"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/webhook"
+)
+
+func TestWebhookReceiver(t *testing.T) {
+	tests := []struct {
+		name              string
+		method            string
+		url               string
+		secret            string
+		body              string
+		expectedCode      int
+		expectedTriggered []int
+	}{
+		{"wrong_method", "GET", "/webhook", "secret", "", 405, []int{}},
+		{"no_secret", "POST", "/webhook", "", `{"eventType":"build.complete"}`, 401, []int{}},
+		{"wrong_secret", "POST", "/webhook", "wrong", `{"eventType":"build.complete"}`, 401, []int{}},
+		{"invalid_body", "POST", "/webhook", "secret", `{"eventType":`, 400, []int{}},
+		{"invalid_pool_id", "POST", "/webhook?poolId=abc", "secret", `{"eventType":"build.complete"}`, 400, []int{}},
+		{"pool_id", "POST", "/webhook", "secret", `{"eventType":"ms.vss-distributed-task.job-queued","resource":{"poolId":1}}`, 200, []int{1}},
+		{"pool", "POST", "/webhook", "secret", `{"eventType":"ms.vss-distributed-task.agent-updated","resource":{"pool":{"id":2}}}`, 200, []int{2}},
+		{"queue_pool", "POST", "/webhook", "secret", `{"eventType":"build.complete","resource":{"queue":{"id":10,"pool":{"id":1}}}}`, 200, []int{1}},
+		{"pool_id_in_url", "POST", "/webhook?poolId=2", "secret", `{"eventType":"ms.vss-pipelines.job-state-changed-event","resource":{}}`, 200, []int{2}},
+		{"no_pool", "POST", "/webhook", "secret", `{"eventType":"ms.vss-pipelines.job-state-changed-event","resource":{}}`, 200, []int{1, 2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			triggers := scaling.MakePoolTriggers()
+			triggered := map[int]<-chan struct{}{
+				1: triggers.Triggered(1),
+				2: triggers.Triggered(2),
+			}
+			receiver := webhook.MakeReceiver("secret", triggers)
+
+			request := httptest.NewRequest(test.method, test.url, strings.NewReader(test.body))
+			if test.secret != "" {
+				request.SetBasicAuth("azp", test.secret)
+			}
+			recorder := httptest.NewRecorder()
+			receiver.ServeHTTP(recorder, request)
+			if recorder.Code != test.expectedCode {
+				t.Fatalf("Expected status %d, but got %d", test.expectedCode, recorder.Code)
+			}
+
+			for agentPoolID, channel := range triggered {
+				expected := false
+				for _, expectedPoolID := range test.expectedTriggered {
+					expected = expected || expectedPoolID == agentPoolID
+				}
+				select {
+				case <-channel:
+					if !expected {
+						t.Errorf("Expected agent pool %d not to be triggered", agentPoolID)
+					}
+				default:
+					if expected {
+						t.Errorf("Expected agent pool %d to be triggered", agentPoolID)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookNotificationsCoalesced(t *testing.T) {
+	triggers := scaling.MakePoolTriggers()
+	receiver := webhook.MakeReceiver("secret", triggers)
+	minInterval := 200 * time.Millisecond
+
+	// An autoscaling loop of agent pool 1 that is only started by notifications
+	stop := make(chan struct{})
+	iterations := make(chan time.Time, 100)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			iterationStart := time.Now()
+			iterations <- iterationStart
+			notified := triggers.Triggered(1)
+			if !scaling.WaitForNextIteration(stop, nil, notified, iterationStart, time.Minute, minInterval) {
+				return
+			}
+		}
+	}()
+
+	// A burst of notifications
+	for timeout := time.Now().Add(time.Second); time.Now().Before(timeout); time.Sleep(10 * time.Millisecond) {
+		request := httptest.NewRequest("POST", "/webhook", strings.NewReader(`{"eventType":"ms.vss-distributed-task.job-queued","resource":{"poolId":1}}`))
+		request.SetBasicAuth("azp", "secret")
+		recorder := httptest.NewRecorder()
+		receiver.ServeHTTP(recorder, request)
+		if recorder.Code != 200 {
+			t.Fatalf("Expected status 200, but got %d", recorder.Code)
+		}
+	}
+	close(stop)
+	<-stopped
+	close(iterations)
+
+	var starts []time.Time
+	for start := range iterations {
+		starts = append(starts, start)
+	}
+	// The first iteration isn't triggered, and a notification is received at most 10ms after each iteration
+	if len(starts) < 3 || len(starts) > 7 {
+		t.Fatalf("Expected about 1 iteration every %s for a second, but got %d iterations", minInterval.String(), len(starts))
+	}
+	for i := 1; i < len(starts); i++ {
+		if interval := starts[i].Sub(starts[i-1]); interval < minInterval {
+			t.Fatalf("Expected iterations at least %s apart, but iteration %d started %s after the previous one", minInterval.String(), i, interval.String())
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package webhook
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/logging"
+	"github.com/ogmaresca/azp-agent-autoscaler/pkg/scaling"
+)
+
+// maxNotificationSize is the largest notification body read, in bytes
+const maxNotificationSize = 1 << 20
+
+var (
+	notificationsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "azp_agent_autoscaler_webhook_notifications_count",
+		Help: "The total number of Azure Devops service hook notifications received",
+	}, []string{"event_type"})
+	rejectedNotificationsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "azp_agent_autoscaler_webhook_rejected_notifications_count",
+		Help: "The total number of rejected Azure Devops service hook notifications",
+	}, []string{"reason"})
+)
+
+// Notification is an Azure Devops service hook notification, ex. a job state change
+type Notification struct {
+	ID        string   `json:"id"`
+	EventType string   `json:"eventType"`
+	Resource  Resource `json:"resource"`
+}
+
+// Resource holds the fields of a notification resource that reference an agent pool.
+// Depending on the event, the agent pool is in poolId, pool or queue.pool.
+type Resource struct {
+	PoolID int        `json:"poolId"`
+	Pool   *Reference `json:"pool"`
+	Queue  *struct {
+		Pool *Reference `json:"pool"`
+	} `json:"queue"`
+}
+
+// Reference is a reference to an Azure Devops object
+type Reference struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// AgentPoolID returns the ID of the agent pool the resource references, or 0 if it doesn't reference one
+func (r Resource) AgentPoolID() int {
+	if r.PoolID != 0 {
+		return r.PoolID
+	} else if r.Pool != nil {
+		return r.Pool.ID
+	} else if r.Queue != nil && r.Queue.Pool != nil {
+		return r.Queue.Pool.ID
+	}
+	return 0
+}
+
+// Receiver is an HTTP Handler that receives Azure Devops service hook notifications,
+// and triggers the autoscaling of the agent pools they reference
+type Receiver struct {
+	secret   string
+	triggers *scaling.PoolTriggers
+}
+
+// MakeReceiver returns a Receiver. Notifications must have the secret as the basic authentication password.
+func MakeReceiver(secret string, triggers *scaling.PoolTriggers) Receiver {
+	return Receiver{secret, triggers}
+}
+
+func (r Receiver) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	logging.Logger.Trace("Received a webhook notification")
+
+	if request.Method != http.MethodPost {
+		rejectedNotificationsCounter.With(prometheus.Labels{"reason": "method"}).Inc()
+		writer.Header().Set("Allow", http.MethodPost)
+		writer.WriteHeader(405)
+		return
+	}
+
+	_, password, ok := request.BasicAuth()
+	if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(r.secret)) != 1 {
+		logging.Logger.Warnf("Rejecting a webhook notification from %s with an invalid secret", request.RemoteAddr)
+		rejectedNotificationsCounter.With(prometheus.Labels{"reason": "unauthorized"}).Inc()
+		writer.Header().Set("WWW-Authenticate", `Basic realm="azp-agent-autoscaler"`)
+		writer.WriteHeader(401)
+		return
+	}
+
+	var notification Notification
+	if err := json.NewDecoder(io.LimitReader(request.Body, maxNotificationSize)).Decode(&notification); err != nil {
+		logging.Logger.Warnf("Rejecting an invalid webhook notification: %s", err.Error())
+		rejectedNotificationsCounter.With(prometheus.Labels{"reason": "invalid"}).Inc()
+		writer.WriteHeader(400)
+		return
+	}
+	notificationsCounter.With(prometheus.Labels{"event_type": notification.EventType}).Inc()
+
+	// The agent pool can also be set in the service hook URL, for events that don't reference an agent pool
+	agentPoolID := notification.Resource.AgentPoolID()
+	if poolID := request.URL.Query().Get("poolId"); poolID != "" {
+		var err error
+		if agentPoolID, err = strconv.Atoi(poolID); err != nil {
+			rejectedNotificationsCounter.With(prometheus.Labels{"reason": "invalid"}).Inc()
+			writer.WriteHeader(400)
+			return
+		}
+	}
+
+	if agentPoolID == 0 {
+		logging.Logger.Debugf("Received %s notification %s, triggering the autoscaling of every agent pool", notification.EventType, notification.ID)
+		r.triggers.TriggerAll()
+	} else {
+		logging.Logger.Debugf("Received %s notification %s, triggering the autoscaling of agent pool %d", notification.EventType, notification.ID, agentPoolID)
+		r.triggers.Trigger(agentPoolID)
+	}
+
+	writer.WriteHeader(200)
+	writer.Write([]byte("OK"))
+}